@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"pwdh-aether/internal/config"
 	"pwdh-aether/internal/database"
 	"pwdh-aether/internal/handler"
+	"pwdh-aether/internal/mail"
+	"pwdh-aether/internal/repository"
 	"pwdh-aether/internal/ws"
 
 	"github.com/gofiber/fiber/v2"
@@ -31,6 +35,12 @@ func main() {
 	}
 	log.Println("Migrations applied successfully")
 
+	if n, err := repository.NewMFARepository(db, cfg.TOTPEncryptionKey).EncryptPlaintextSecrets(); err != nil {
+		log.Fatalf("totp secrets: %v", err)
+	} else if n > 0 {
+		log.Printf("Encrypted %d plaintext TOTP secrets", n)
+	}
+
 	rdb := database.ConnectRedis(cfg.RedisURL)
 	defer rdb.Close()
 
@@ -42,21 +52,60 @@ func main() {
 		log.Fatalf("minio bucket: %v", err)
 	}
 
-	hub := ws.NewHub(rdb)
+	var broker ws.Broker
+	switch cfg.GatewayBroker {
+	case "memory":
+		broker = ws.NewMemoryBroker()
+	case "nats":
+		broker, err = ws.NewNATSBroker(cfg.NATSURL)
+		if err != nil {
+			log.Fatalf("nats: %v", err)
+		}
+	default:
+		broker = ws.NewRedisBroker(rdb)
+	}
+	defer broker.Close()
+
+	hub := ws.NewHub(
+		broker,
+		rdb,
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewConversationRepository(db),
+		repository.NewPresenceRepository(db),
+	)
 	go hub.Run()
 
 	app := fiber.New(fiber.Config{
 		BodyLimit: 10 * 1024 * 1024,
 	})
 
-	router := handler.NewRouter(db, rdb, minioClient, hub, cfg)
+	var mailer mail.Mailer
+	switch cfg.Mailer {
+	case "smtp":
+		mailer = mail.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	default:
+		mailer = mail.NewLogMailer(cfg.MailDir, cfg.MailFrom, cfg.DevMode)
+	}
+
+	router := handler.NewRouter(db, rdb, minioClient, hub, mailer, cfg)
 	router.Setup(app)
 
+	jobs, stopJobs := context.WithCancel(context.Background())
+	router.StartJobs(jobs)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-quit
 		log.Println("Shutting down server...")
+		stopJobs()
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := hub.Shutdown(ctx); err != nil {
+			log.Printf("gateway drain: %v", err)
+		}
 		_ = app.Shutdown()
 	}()
 
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"errors"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AccountHandler struct {
+	accounts *service.AccountService
+}
+
+func NewAccountHandler(accounts *service.AccountService) *AccountHandler {
+	return &AccountHandler{accounts: accounts}
+}
+
+// Delete schedules the account for deletion. It answers with the user,
+// whose deletion_scheduled_at says until when Restore is possible.
+func (h *AccountHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req model.DeleteAccountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+
+	user, err := h.accounts.ScheduleDeletion(userID, req)
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidGuildPolicy) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return reauthError(c, err, "account deletion failed")
+	}
+	return c.Status(fiber.StatusAccepted).JSON(user)
+}
+
+func (h *AccountHandler) Restore(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	if err := h.accounts.CancelDeletion(userID); err != nil {
+		if errors.Is(err, model.ErrDeletionNotScheduled) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "restore failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -29,7 +29,7 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "password must be at least 6 characters"})
 	}
 
-	resp, err := h.auth.Register(req)
+	resp, err := h.auth.Register(req, clientInfo(c))
 	if err != nil {
 		if errors.Is(err, model.ErrEmailTaken) || errors.Is(err, model.ErrUsernameTaken) {
 			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
@@ -49,13 +49,117 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email and password are required"})
 	}
 
-	resp, err := h.auth.Login(req)
+	resp, challenge, err := h.auth.Login(req, clientInfo(c))
 	if err != nil {
 		if errors.Is(err, model.ErrInvalidCredentials) {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "login failed"})
 	}
+	if challenge != nil {
+		return c.JSON(challenge)
+	}
 
 	return c.JSON(resp)
 }
+
+func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
+	var req model.RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "refresh_token is required"})
+	}
+
+	resp, err := h.auth.Refresh(req.RefreshToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidRefreshToken) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "refresh failed"})
+	}
+	return c.JSON(resp)
+}
+
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	var req model.RefreshRequest
+	if err := c.BodyParser(&req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "refresh_token is required"})
+	}
+
+	if err := h.auth.Logout(req.RefreshToken); err != nil {
+		if errors.Is(err, model.ErrInvalidRefreshToken) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "logout failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) GetSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	sessionID := c.Locals("sessionID").(string)
+
+	sessions, err := h.auth.ListSessions(userID, sessionID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch sessions"})
+	}
+	return c.JSON(sessions)
+}
+
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	if err := h.auth.RevokeSession(userID, c.Params("id")); err != nil {
+		if errors.Is(err, model.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "revoke failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeOtherSessions signs out every device except the one making the
+// request.
+func (h *AuthHandler) RevokeOtherSessions(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	sessionID := c.Locals("sessionID").(string)
+	if err := h.auth.RevokeOtherSessions(userID, sessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "revoke failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ChangePassword needs the current password, if the account has one, and
+// signs out every other device.
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	sessionID := c.Locals("sessionID").(string)
+
+	var req model.ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "new_password is required"})
+	}
+	if len(req.NewPassword) < 6 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "password must be at least 6 characters"})
+	}
+
+	if err := h.auth.ChangePassword(userID, sessionID, req); err != nil {
+		return reauthError(c, err, "password change failed")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// reauthError maps failed password or second-factor confirmation to 403, so
+// clients don't mistake it for an expired access token.
+func reauthError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, model.ErrInvalidPassword), errors.Is(err, model.ErrMFARequired),
+		errors.Is(err, model.ErrInvalidMFACode), errors.Is(err, model.ErrReauthRequired):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+}
+
+func clientInfo(c *fiber.Ctx) model.ClientInfo {
+	return model.ClientInfo{UserAgent: c.Get(fiber.HeaderUserAgent), IP: c.IP()}
+}
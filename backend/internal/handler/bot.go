@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type BotHandler struct {
+	bots *service.BotService
+}
+
+func NewBotHandler(bots *service.BotService) *BotHandler {
+	return &BotHandler{bots: bots}
+}
+
+// Create answers with the new bot including its token, which is not shown
+// again.
+func (h *BotHandler) Create(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	var req model.CreateBotRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+	}
+	if len(req.Username) < 3 || len(req.Username) > 50 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "username must be 3-50 characters"})
+	}
+
+	bot, err := h.bots.Create(userID, req)
+	if err != nil {
+		return botError(c, err, "failed to create bot")
+	}
+	return c.Status(fiber.StatusCreated).JSON(bot)
+}
+
+func (h *BotHandler) GetMyBots(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	bots, err := h.bots.List(userID)
+	if err != nil {
+		return botError(c, err, "failed to fetch bots")
+	}
+	return c.JSON(bots)
+}
+
+// RegenerateToken replaces the bot's token; the old one stops working and
+// its gateway connections are closed.
+func (h *BotHandler) RegenerateToken(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	bot, err := h.bots.RegenerateToken(userID, c.Params("id"))
+	if err != nil {
+		return botError(c, err, "failed to regenerate token")
+	}
+	return c.JSON(bot)
+}
+
+func (h *BotHandler) Delete(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	if err := h.bots.Delete(userID, c.Params("id")); err != nil {
+		return botError(c, err, "failed to delete bot")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func botError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, model.ErrBotNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrUsernameTaken), errors.Is(err, model.ErrBotLimitReached):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+}
@@ -71,6 +71,23 @@ func (h *ConversationHandler) Create(c *fiber.Ctx) error {
 	if err := h.convs.Create(conv, allMembers); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "create failed"})
 	}
+
+	members, _ := h.convs.GetMembers(conv.ID)
+	resp := model.ConversationResponse{
+		ID:        conv.ID,
+		IsGroup:   conv.IsGroup,
+		Name:      conv.Name,
+		Members:   []model.UserResponse{},
+		CreatedAt: conv.CreatedAt,
+	}
+	for _, m := range members {
+		resp.Members = append(resp.Members, m.ToResponse())
+	}
+	for _, m := range members {
+		h.hub.JoinUser(m.ID, "dm:"+conv.ID)
+		h.hub.BroadcastToUser(m.ID, ws.Event{Type: ws.EventConversationCreate, Data: resp})
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(conv)
 }
 
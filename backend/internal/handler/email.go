@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *AuthHandler) VerifyEmail(c *fiber.Ctx) error {
+	var req model.VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+	if err := h.auth.VerifyEmail(req.Token); err != nil {
+		if errors.Is(err, model.ErrInvalidVerifyToken) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "verification failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	if err := h.auth.SendVerification(userID); err != nil {
+		if errors.Is(err, model.ErrEmailAlreadyVerified) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to send verification"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req model.ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email is required"})
+	}
+	if err := h.auth.ForgotPassword(strings.TrimSpace(req.Email)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "request failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req model.ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token and password are required"})
+	}
+	if len(req.Password) < 6 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "password must be at least 6 characters"})
+	}
+	if err := h.auth.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, model.ErrInvalidResetToken) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "reset failed"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/ws"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type EventsHandler struct {
+	hub *ws.Hub
+}
+
+func NewEventsHandler(hub *ws.Hub) *EventsHandler {
+	return &EventsHandler{hub: hub}
+}
+
+// Stream is the Server-Sent Events fallback for networks that block /ws.
+func (h *EventsHandler) Stream(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	opts, err := ws.ParseConnectOptions("", "", c.Query("intents"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	opts.AuthSession = c.Locals("sessionID").(string)
+	opts.Bot = c.Locals("bot").(bool)
+	if h.hub.Draining() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "server shutting down"})
+	}
+
+	var resume *ws.ResumeData
+	if data, ok := ws.ParseLastEventID(c.Get("Last-Event-ID")); ok {
+		resume = &data
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ws.ServeSSE(h.hub, w, userID, opts, resume)
+	})
+	return nil
+}
+
+func (h *EventsHandler) Subscribe(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	roomID := c.Params("roomId")
+	if err := h.hub.Authorize(userID, roomID); err != nil {
+		if errors.Is(err, model.ErrNotMember) || errors.Is(err, model.ErrNotConversationMember) ||
+			errors.Is(err, model.ErrNotAuthorized) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, model.ErrChannelNotFound) || errors.Is(err, model.ErrGuildNotFound) ||
+			errors.Is(err, model.ErrConversationNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "subscription failed"})
+	}
+	h.hub.JoinSession(userID, c.Params("sessionId"), roomID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *EventsHandler) Unsubscribe(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	h.hub.LeaveSession(userID, c.Params("sessionId"), c.Params("roomId"))
+	return c.SendStatus(fiber.StatusNoContent)
+}
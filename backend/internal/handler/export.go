@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ExportHandler struct {
+	exports *service.ExportService
+}
+
+func NewExportHandler(exports *service.ExportService) *ExportHandler {
+	return &ExportHandler{exports: exports}
+}
+
+// Request starts a data export. The archive is built in the background and
+// announced with a DATA_EXPORT_COMPLETE gateway event.
+func (h *ExportHandler) Request(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	export, err := h.exports.Request(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrExportInProgress):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, model.ErrExportCooldown):
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "export failed"})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(export)
+}
+
+// Get returns the latest export, with a fresh download link once ready.
+func (h *ExportHandler) Get(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	export, err := h.exports.Latest(userID)
+	if err != nil {
+		if errors.Is(err, model.ErrNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no export requested"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch export"})
+	}
+	return c.JSON(export)
+}
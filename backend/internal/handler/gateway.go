@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// gatewayTicketTTL is how long a ticket from POST /api/gateway/ticket may be
+// used to open /ws.
+const gatewayTicketTTL = 30 * time.Second
+
+var errInvalidTicket = errors.New("invalid or expired ticket")
+
+type GatewayHandler struct {
+	rdb *redis.Client
+}
+
+func NewGatewayHandler(rdb *redis.Client) *GatewayHandler {
+	return &GatewayHandler{rdb: rdb}
+}
+
+func gatewayTicketKey(ticket string) string { return "gateway:ticket:" + ticket }
+
+// gatewayTicket is what a ticket stands for: the user, the login session or
+// bot token it was issued for, and whether the user is a bot.
+type gatewayTicket struct {
+	UserID    string
+	SessionID string
+	Bot       bool
+}
+
+// CreateTicket issues a single-use ticket for the WebSocket handshake, so the
+// access token never shows up in a URL.
+func (h *GatewayHandler) CreateTicket(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	sessionID := c.Locals("sessionID").(string)
+	kind := "user"
+	if c.Locals("bot").(bool) {
+		kind = "bot"
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create ticket"})
+	}
+	ticket := hex.EncodeToString(buf)
+
+	if err := h.rdb.Set(c.Context(), gatewayTicketKey(ticket), userID+":"+sessionID+":"+kind, gatewayTicketTTL).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create ticket"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"ticket":     ticket,
+		"expires_in": int(gatewayTicketTTL.Seconds()),
+	})
+}
+
+// redeemTicket consumes a ticket and returns what it was issued for.
+func (h *GatewayHandler) redeemTicket(ctx context.Context, ticket string) (gatewayTicket, error) {
+	value, err := h.rdb.GetDel(ctx, gatewayTicketKey(ticket)).Result()
+	if err == redis.Nil {
+		return gatewayTicket{}, errInvalidTicket
+	}
+	if err != nil {
+		return gatewayTicket{}, err
+	}
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) < 2 {
+		return gatewayTicket{}, errInvalidTicket
+	}
+	t := gatewayTicket{UserID: parts[0], SessionID: parts[1]}
+	t.Bot = len(parts) == 3 && parts[2] == "bot"
+	return t, nil
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// revokedSessions is a SessionChecker that reports the sessions in it as
+// revoked.
+type revokedSessions map[string]bool
+
+func (s revokedSessions) IsRevoked(sessionID string) (bool, error) { return s[sessionID], nil }
+
+// newGatewayApp serves POST /api/gateway/ticket for user u1 on session s1
+// and the /ws handshake, which answers with the user it admitted instead of
+// upgrading.
+func newGatewayApp(t *testing.T, sessions revokedSessions) (*fiber.App, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	r := &Router{gateway: NewGatewayHandler(rdb), sessions: sessions}
+
+	app := fiber.New()
+	app.Post("/api/gateway/ticket", func(c *fiber.Ctx) error {
+		c.Locals("userID", "u1")
+		c.Locals("sessionID", "s1")
+		c.Locals("bot", false)
+		return c.Next()
+	}, r.gateway.CreateTicket)
+	app.Use("/ws", r.gatewayHandshake)
+	app.Get("/ws", func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("userID").(string))
+	})
+	return app, mr
+}
+
+func createTicket(t *testing.T, app *fiber.App) string {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("POST", "/api/gateway/ticket", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != fiber.StatusCreated || body.Ticket == "" || body.ExpiresIn != int(gatewayTicketTTL.Seconds()) {
+		t.Fatalf("create ticket: %d %+v", resp.StatusCode, body)
+	}
+	return body.Ticket
+}
+
+// handshake opens /ws with ticket and returns the status and body.
+func handshake(t *testing.T, app *fiber.App, ticket string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/ws?ticket="+ticket, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestGatewayTicketIsSingleUse(t *testing.T) {
+	app, _ := newGatewayApp(t, revokedSessions{})
+	ticket := createTicket(t, app)
+
+	if status, body := handshake(t, app, ticket); status != fiber.StatusOK || body != "u1" {
+		t.Fatalf("first use: %d %s", status, body)
+	}
+	if status, body := handshake(t, app, ticket); status != fiber.StatusUnauthorized {
+		t.Fatalf("second use: %d %s", status, body)
+	}
+}
+
+func TestGatewayTicketExpires(t *testing.T) {
+	app, mr := newGatewayApp(t, revokedSessions{})
+	ticket := createTicket(t, app)
+
+	mr.FastForward(gatewayTicketTTL)
+	if status, body := handshake(t, app, ticket); status != fiber.StatusUnauthorized {
+		t.Fatalf("expired ticket: %d %s", status, body)
+	}
+}
+
+func TestGatewayTicketRejectedForRevokedSession(t *testing.T) {
+	sessions := revokedSessions{}
+	app, _ := newGatewayApp(t, sessions)
+	ticket := createTicket(t, app)
+
+	sessions["s1"] = true
+	if status, body := handshake(t, app, ticket); status != fiber.StatusUnauthorized || body != `{"error":"session revoked"}` {
+		t.Fatalf("revoked session: %d %s", status, body)
+	}
+}
+
+func TestGatewayHandshakeRequiresTicket(t *testing.T) {
+	app, _ := newGatewayApp(t, revokedSessions{})
+	for _, ticket := range []string{"", "not-a-ticket"} {
+		if status, body := handshake(t, app, ticket); status != fiber.StatusUnauthorized {
+			t.Fatalf("ticket %q: %d %s", ticket, status, body)
+		}
+	}
+}
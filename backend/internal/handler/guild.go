@@ -60,7 +60,7 @@ func (h *GuildHandler) Update(c *fiber.Ctx) error {
 
 	guild, err := h.guilds.Update(userID, c.Params("id"), req)
 	if err != nil {
-		if errors.Is(err, model.ErrNotAuthorized) {
+		if errors.Is(err, model.ErrNotAuthorized) || errors.Is(err, model.ErrMFARequired) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "update failed"})
@@ -72,7 +72,7 @@ func (h *GuildHandler) Delete(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 	err := h.guilds.Delete(userID, c.Params("id"))
 	if err != nil {
-		if errors.Is(err, model.ErrNotAuthorized) {
+		if errors.Is(err, model.ErrNotAuthorized) || errors.Is(err, model.ErrMFARequired) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "delete failed"})
@@ -95,6 +95,9 @@ func (h *GuildHandler) Join(c *fiber.Ctx) error {
 		if errors.Is(err, model.ErrAlreadyMember) {
 			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "already a member"})
 		}
+		if errors.Is(err, model.ErrEmailNotVerified) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "join failed"})
 	}
 	return c.JSON(guild)
@@ -128,7 +131,7 @@ func (h *GuildHandler) KickMember(c *fiber.Ctx) error {
 	targetID := c.Params("userId")
 	err := h.guilds.KickMember(userID, c.Params("id"), targetID)
 	if err != nil {
-		if errors.Is(err, model.ErrNotAuthorized) {
+		if errors.Is(err, model.ErrNotAuthorized) || errors.Is(err, model.ErrMFARequired) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "kick failed"})
@@ -144,7 +147,7 @@ func (h *GuildHandler) UpdateMemberRole(c *fiber.Ctx) error {
 	}
 	err := h.guilds.UpdateMemberRole(userID, c.Params("id"), req.UserID, req.Role)
 	if err != nil {
-		if errors.Is(err, model.ErrNotAuthorized) {
+		if errors.Is(err, model.ErrNotAuthorized) || errors.Is(err, model.ErrMFARequired) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "role update failed"})
@@ -22,15 +22,21 @@ func NewMessageHandler(messages *service.MessageService) *MessageHandler {
 func (h *MessageHandler) Create(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 	var req model.CreateMessageRequest
-	if err := c.BodyParser(&req); err != nil || req.Content == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "content is required"})
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
 	msg, err := h.messages.Create(userID, c.Params("id"), req)
 	if err != nil {
+		if errors.Is(err, model.ErrInvalidContent) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 		if errors.Is(err, model.ErrNotMember) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
+		if errors.Is(err, model.ErrChannelNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to send message"})
 	}
 	return c.Status(fiber.StatusCreated).JSON(msg)
@@ -67,12 +73,18 @@ func (h *MessageHandler) GetByChannel(c *fiber.Ctx) error {
 func (h *MessageHandler) Update(c *fiber.Ctx) error {
 	userID := c.Locals("userID").(string)
 	var req model.UpdateMessageRequest
-	if err := c.BodyParser(&req); err != nil || req.Content == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "content is required"})
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
 	msg, err := h.messages.Update(userID, c.Params("id"), req.Content)
 	if err != nil {
+		if errors.Is(err, model.ErrInvalidContent) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, model.ErrMessageNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
 		if errors.Is(err, model.ErrNotAuthorized) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
 		}
@@ -98,12 +110,21 @@ func (h *MessageHandler) AddReaction(c *fiber.Ctx) error {
 	var body struct {
 		Emoji string `json:"emoji"`
 	}
-	if err := c.BodyParser(&body); err != nil || body.Emoji == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "emoji is required"})
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
 	}
 
 	err := h.messages.AddReaction(userID, c.Params("id"), body.Emoji)
 	if err != nil {
+		if errors.Is(err, model.ErrInvalidEmoji) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, model.ErrNotMember) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+		}
+		if errors.Is(err, model.ErrMessageNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "reaction failed"})
 	}
 	return c.SendStatus(fiber.StatusNoContent)
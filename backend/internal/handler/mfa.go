@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"errors"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// VerifyMFA exchanges the ticket from an MFA challenge and a TOTP or
+// recovery code for tokens.
+func (h *AuthHandler) VerifyMFA(c *fiber.Ctx) error {
+	var req model.MFALoginRequest
+	if err := c.BodyParser(&req); err != nil || req.Ticket == "" || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "mfa_ticket and code are required"})
+	}
+
+	resp, err := h.auth.VerifyMFA(req.Ticket, req.Code, clientInfo(c))
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(resp)
+}
+
+func (h *AuthHandler) GetMFA(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	status, err := h.auth.MFAStatus(userID)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(status)
+}
+
+func (h *AuthHandler) EnrollTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	resp, err := h.auth.EnrollTOTP(userID)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(resp)
+}
+
+func (h *AuthHandler) EnableTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	code := mfaCode(c)
+	if code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	resp, err := h.auth.EnableTOTP(userID, code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(resp)
+}
+
+func (h *AuthHandler) DisableTOTP(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	code := mfaCode(c)
+	if code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	if err := h.auth.DisableTOTP(userID, code); err != nil {
+		return mfaError(c, err)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	code := mfaCode(c)
+	if code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code is required"})
+	}
+	resp, err := h.auth.RegenerateRecoveryCodes(userID, code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(resp)
+}
+
+func mfaCode(c *fiber.Ctx) string {
+	var req model.MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ""
+	}
+	return req.Code
+}
+
+func mfaError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, model.ErrInvalidMFATicket), errors.Is(err, model.ErrInvalidMFACode):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrMFAAlreadyEnabled), errors.Is(err, model.ErrMFANotEnabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "two-factor request failed"})
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// oidcBindingCookie ties a provider flow to the browser that started it, so
+// a state handed to someone else cannot log them into the wrong account.
+const oidcBindingCookie = "oidc_binding"
+
+type OIDCHandler struct {
+	oidc *service.OIDCService
+}
+
+func NewOIDCHandler(oidc *service.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidc: oidc}
+}
+
+func (h *OIDCHandler) GetProviders(c *fiber.Ctx) error {
+	return c.JSON(h.oidc.Providers())
+}
+
+// Authorize returns the provider URL for login. The provider redirects back
+// to OIDC_REDIRECT_URL, whose page posts code and state to Callback.
+func (h *OIDCHandler) Authorize(c *fiber.Ctx) error {
+	resp, err := h.oidc.AuthorizeLogin(c.Params("provider"))
+	if err != nil {
+		return oidcError(c, err, "authorization failed")
+	}
+	setBinding(c, resp.Binding)
+	return c.JSON(resp)
+}
+
+// Callback logs in, or registers, the provider's user. Like Login it answers
+// with an MFA challenge when the account has two-factor authentication on.
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	req, ok := callbackRequest(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code and state are required"})
+	}
+
+	resp, challenge, err := h.oidc.Login(req, clientInfo(c))
+	if err != nil {
+		return oidcError(c, err, "login failed")
+	}
+	if challenge != nil {
+		return c.JSON(challenge)
+	}
+	return c.JSON(resp)
+}
+
+func (h *OIDCHandler) GetIdentities(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	identities, err := h.oidc.Identities(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to fetch identities"})
+	}
+	if identities == nil {
+		identities = []model.Identity{}
+	}
+	return c.JSON(identities)
+}
+
+// Link returns the provider URL for adding an identity to the current user.
+func (h *OIDCHandler) Link(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	resp, err := h.oidc.AuthorizeLink(userID, c.Params("provider"))
+	if err != nil {
+		return oidcError(c, err, "authorization failed")
+	}
+	setBinding(c, resp.Binding)
+	return c.JSON(resp)
+}
+
+func (h *OIDCHandler) LinkCallback(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	req, ok := callbackRequest(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code and state are required"})
+	}
+
+	identity, err := h.oidc.Link(userID, req)
+	if err != nil {
+		return oidcError(c, err, "link failed")
+	}
+	return c.Status(fiber.StatusCreated).JSON(identity)
+}
+
+// Reauth returns the provider URL for confirming the current user before a
+// password change or account deletion.
+func (h *OIDCHandler) Reauth(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	resp, err := h.oidc.AuthorizeReauth(userID, c.Params("provider"))
+	if err != nil {
+		return oidcError(c, err, "authorization failed")
+	}
+	setBinding(c, resp.Binding)
+	return c.JSON(resp)
+}
+
+// ReauthCallback answers with a reauth_token for ChangePassword or Delete.
+func (h *OIDCHandler) ReauthCallback(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+
+	req, ok := callbackRequest(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "code and state are required"})
+	}
+
+	resp, err := h.oidc.Reauth(userID, req)
+	if err != nil {
+		return oidcError(c, err, "reauthentication failed")
+	}
+	return c.JSON(resp)
+}
+
+func (h *OIDCHandler) Unlink(c *fiber.Ctx) error {
+	userID := c.Locals("userID").(string)
+	if err := h.oidc.Unlink(userID, c.Params("id")); err != nil {
+		return oidcError(c, err, "unlink failed")
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func setBinding(c *fiber.Ctx, binding string) {
+	c.Cookie(bindingCookie(c, binding, time.Now().Add(service.OIDCStateTTL)))
+}
+
+// bindingCookie builds the binding cookie. Clearing it needs the same path
+// and attributes as setting it, or the browser keeps the old one.
+func bindingCookie(c *fiber.Ctx, value string, expires time.Time) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    value,
+		Path:     "/api",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteStrictMode,
+	}
+}
+
+// callbackRequest reads the posted code and state together with the binding
+// cookie, which it clears: every state is redeemed at most once.
+func callbackRequest(c *fiber.Ctx) (model.OIDCCallbackRequest, bool) {
+	var req model.OIDCCallbackRequest
+	if err := c.BodyParser(&req); err != nil || req.Code == "" || req.State == "" {
+		return req, false
+	}
+	req.Binding = c.Cookies(oidcBindingCookie)
+	c.Cookie(bindingCookie(c, "", time.Unix(0, 0)))
+	return req, true
+}
+
+func oidcError(c *fiber.Ctx, err error, fallback string) error {
+	switch {
+	case errors.Is(err, model.ErrUnknownProvider), errors.Is(err, model.ErrIdentityNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrInvalidOIDCState), errors.Is(err, model.ErrOIDCEmailRequired):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrOIDCLoginFailed):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrReauthRequired):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, model.ErrIdentityTaken), errors.Is(err, model.ErrIdentityEmailInUse),
+		errors.Is(err, model.ErrLastLoginMethod):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fallback})
+}
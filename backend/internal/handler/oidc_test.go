@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/service"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCallbackExpiresBindingCookieOnItsPath(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer rdb.Close()
+	h := NewOIDCHandler(service.NewOIDCService(nil, nil, nil, rdb, &config.Config{}))
+
+	app := fiber.New()
+	app.Post("/api/auth/oidc/callback", h.Callback)
+	req := httptest.NewRequest("POST", "/api/auth/oidc/callback", strings.NewReader(`{"code":"c","state":"s"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: oidcBindingCookie, Value: "binding"})
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cleared *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == oidcBindingCookie {
+			cleared = c
+		}
+	}
+	if cleared == nil {
+		t.Fatal("binding cookie not cleared")
+	}
+	if cleared.Value != "" || cleared.Path != "/api" || !cleared.HttpOnly ||
+		cleared.SameSite != http.SameSiteStrictMode || !cleared.Expires.Before(time.Now()) {
+		t.Fatalf("cleared with %q, want an expired cookie on /api with the attributes it was set with", resp.Header.Values("Set-Cookie"))
+	}
+}
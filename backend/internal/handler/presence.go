@@ -5,16 +5,18 @@ import (
 
 	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type PresenceHandler struct {
 	presence *repository.PresenceRepository
+	hub      *ws.Hub
 }
 
-func NewPresenceHandler(presence *repository.PresenceRepository) *PresenceHandler {
-	return &PresenceHandler{presence: presence}
+func NewPresenceHandler(presence *repository.PresenceRepository, hub *ws.Hub) *PresenceHandler {
+	return &PresenceHandler{presence: presence, hub: hub}
 }
 
 func (h *PresenceHandler) Update(c *fiber.Ctx) error {
@@ -28,14 +30,23 @@ func (h *PresenceHandler) Update(c *fiber.Ctx) error {
 	p := &model.UserPresence{
 		UserID:       userID,
 		Status:       existing.Status,
+		ManualStatus: existing.ManualStatus,
 		GameName:     existing.GameName,
 		GameStartedAt: existing.GameStartedAt,
 		CustomStatus: existing.CustomStatus,
 	}
 
 	if req.Status != nil {
-		p.Status = *req.Status
+		switch *req.Status {
+		case model.StatusOnline:
+			p.ManualStatus = nil
+		case model.StatusIdle, model.StatusDND, model.StatusInvisible:
+			p.ManualStatus = req.Status
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "status must be ONLINE, IDLE, DND or INVISIBLE"})
+		}
 	}
+	p.Status = h.hub.PresenceStatus(userID, p.ManualStatus)
 	if req.GameName != nil {
 		p.GameName = req.GameName
 		if *req.GameName != "" {
@@ -52,6 +63,8 @@ func (h *PresenceHandler) Update(c *fiber.Ctx) error {
 	if err := h.presence.Upsert(p); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "update failed"})
 	}
+
+	h.hub.BroadcastPresence(*p)
 	return c.JSON(p)
 }
 
@@ -1,18 +1,21 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"strings"
 
 	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/mail"
 	"pwdh-aether/internal/middleware"
+	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
 	"pwdh-aether/internal/service"
 	"pwdh-aether/internal/ws"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/minio/minio-go/v7"
 	"github.com/redis/go-redis/v9"
 )
@@ -29,11 +32,21 @@ type Router struct {
 	soundboard   *SoundboardHandler
 	presence     *PresenceHandler
 	conversation *ConversationHandler
+	events       *EventsHandler
+	gateway      *GatewayHandler
+	account      *AccountHandler
+	accounts     *service.AccountService
+	export       *ExportHandler
+	exports      *service.ExportService
+	oidc         *OIDCHandler
+	bot          *BotHandler
+	sessions     middleware.SessionChecker
+	bots         middleware.BotAuthenticator
 	hub          *ws.Hub
 	cfg          *config.Config
 }
 
-func NewRouter(db *sql.DB, rdb *redis.Client, minioClient *minio.Client, hub *ws.Hub, cfg *config.Config) *Router {
+func NewRouter(db *sql.DB, rdb *redis.Client, minioClient *minio.Client, hub *ws.Hub, mailer mail.Mailer, cfg *config.Config) *Router {
 	userRepo := repository.NewUserRepository(db)
 	guildRepo := repository.NewGuildRepository(db)
 	channelRepo := repository.NewChannelRepository(db)
@@ -42,11 +55,19 @@ func NewRouter(db *sql.DB, rdb *redis.Client, minioClient *minio.Client, hub *ws
 	soundboardRepo := repository.NewSoundboardRepository(db)
 	presenceRepo := repository.NewPresenceRepository(db)
 	convRepo := repository.NewConversationRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	mfaRepo := repository.NewMFARepository(db, cfg.TOTPEncryptionKey)
+	identityRepo := repository.NewIdentityRepository(db)
+	botRepo := repository.NewBotRepository(db)
 
-	authService := service.NewAuthService(userRepo, cfg)
-	guildService := service.NewGuildService(guildRepo, channelRepo)
-	channelService := service.NewChannelService(channelRepo, guildRepo)
-	messageService := service.NewMessageService(messageRepo, userRepo, guildRepo, channelRepo, hub)
+	authService := service.NewAuthService(userRepo, sessionRepo, mfaRepo, rdb, hub, mailer, cfg)
+	guildService := service.NewGuildService(guildRepo, channelRepo, userRepo, botRepo, hub, cfg)
+	channelService := service.NewChannelService(channelRepo, guildRepo, userRepo, botRepo, hub)
+	messageService := service.NewMessageService(messageRepo, userRepo, guildRepo, channelRepo, botRepo, hub)
+	hub.SetMessageWriter(messageService)
+	exportService := service.NewExportService(repository.NewExportRepository(db), userRepo, lfgRepo, presenceRepo, sessionRepo, identityRepo, minioClient, hub, cfg)
+	accountService := service.NewAccountService(userRepo, guildRepo, lfgRepo, convRepo, presenceRepo, botRepo, authService, exportService, rdb, hub, cfg)
+	botService := service.NewBotService(botRepo, userRepo, accountService, authService, rdb)
 
 	return &Router{
 		auth:         NewAuthHandler(authService),
@@ -58,24 +79,124 @@ func NewRouter(db *sql.DB, rdb *redis.Client, minioClient *minio.Client, hub *ws
 		livekit:      NewLiveKitHandler(cfg, guildRepo, userRepo),
 		lfg:          NewLFGHandler(lfgRepo, userRepo, guildRepo, hub),
 		soundboard:   NewSoundboardHandler(soundboardRepo, guildRepo),
-		presence:     NewPresenceHandler(presenceRepo),
+		presence:     NewPresenceHandler(presenceRepo, hub),
 		conversation: NewConversationHandler(convRepo, userRepo, hub),
+		events:       NewEventsHandler(hub),
+		gateway:      NewGatewayHandler(rdb),
+		account:      NewAccountHandler(accountService),
+		accounts:     accountService,
+		export:       NewExportHandler(exportService),
+		exports:      exportService,
+		oidc:         NewOIDCHandler(service.NewOIDCService(userRepo, identityRepo, authService, rdb, cfg)),
+		bot:          NewBotHandler(botService),
+		sessions:     authService,
+		bots:         botService,
 		hub:          hub,
 		cfg:          cfg,
 	}
 }
 
+// StartJobs runs the background work of the services until ctx is
+// cancelled.
+func (r *Router) StartJobs(ctx context.Context) {
+	go r.accounts.RunDeletions(ctx)
+	go r.exports.RunCleanup(ctx)
+}
+
+// gatewayAuth authenticates a /ws handshake. Browsers redeem a ticket from
+// POST /api/gateway/ticket; bots may instead send their token in the
+// Authorization header.
+func (r *Router) gatewayAuth(c *fiber.Ctx) (gatewayTicket, error) {
+	if scheme, token, ok := strings.Cut(c.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "bot") {
+		claims, err := middleware.ParseBotToken(r.bots, token)
+		if err != nil {
+			return gatewayTicket{}, model.ErrInvalidBotToken
+		}
+		return gatewayTicket{UserID: claims.UserID, SessionID: claims.SessionID, Bot: true}, nil
+	}
+
+	ticket := c.Query("ticket")
+	if ticket == "" {
+		return gatewayTicket{}, errors.New("ticket required")
+	}
+	t, err := r.gateway.redeemTicket(c.Context(), ticket)
+	if err != nil {
+		return gatewayTicket{}, errInvalidTicket
+	}
+	return t, nil
+}
+
+// gatewayHandshake admits a /ws upgrade whose credentials pass gatewayAuth
+// and whose session is not revoked.
+func (r *Router) gatewayHandshake(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+	t, err := r.gatewayAuth(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+	if revoked, err := r.sessions.IsRevoked(t.SessionID); err != nil || revoked {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "session revoked"})
+	}
+	opts, err := ws.ParseConnectOptions(c.Query("encoding"), c.Query("compress"), "")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	opts.AuthSession = t.SessionID
+	opts.Bot = t.Bot
+	c.Locals("userID", t.UserID)
+	c.Locals("connectOptions", opts)
+	return c.Next()
+}
+
 func (r *Router) Setup(app *fiber.App) {
-	middleware.Setup(app, r.cfg)
+	middleware.Setup(app, r.cfg, r.bots)
 
 	auth := app.Group("/api/auth")
 	auth.Post("/register", r.auth.Register)
 	auth.Post("/login", r.auth.Login)
+	auth.Post("/refresh", r.auth.Refresh)
+	auth.Post("/logout", r.auth.Logout)
+	auth.Post("/mfa", r.auth.VerifyMFA)
+	auth.Post("/verify", r.auth.VerifyEmail)
+	auth.Post("/forgot", r.auth.ForgotPassword)
+	auth.Post("/reset", r.auth.ResetPassword)
+	auth.Get("/oidc/providers", r.oidc.GetProviders)
+	auth.Post("/oidc/callback", r.oidc.Callback)
+	auth.Post("/oidc/:provider/authorize", r.oidc.Authorize)
 
-	api := app.Group("/api", middleware.AuthRequired(r.cfg.JWTSecret))
+	api := app.Group("/api", middleware.AuthRequired(r.cfg.JWTSecret, r.sessions, r.bots))
+	human := middleware.HumanOnly()
 
 	api.Get("/users/@me", r.user.GetMe)
 	api.Patch("/users/@me", r.user.UpdateMe)
+	api.Delete("/users/@me", human, r.account.Delete)
+	api.Post("/users/@me/restore", human, r.account.Restore)
+	api.Post("/users/@me/password", human, r.auth.ChangePassword)
+	api.Get("/users/@me/export", human, r.export.Get)
+	api.Post("/users/@me/export", human, r.export.Request)
+	api.Post("/users/@me/verify", human, r.auth.ResendVerification)
+	api.Get("/users/@me/identities", human, r.oidc.GetIdentities)
+	api.Post("/users/@me/identities/callback", human, r.oidc.LinkCallback)
+	api.Post("/users/@me/identities/:provider", human, r.oidc.Link)
+	api.Delete("/users/@me/identities/:id", human, r.oidc.Unlink)
+	api.Post("/users/@me/reauth/callback", human, r.oidc.ReauthCallback)
+	api.Post("/users/@me/reauth/:provider", human, r.oidc.Reauth)
+	api.Get("/users/@me/sessions", human, r.auth.GetSessions)
+	api.Delete("/users/@me/sessions", human, r.auth.RevokeOtherSessions)
+	api.Delete("/users/@me/sessions/:id", human, r.auth.RevokeSession)
+	api.Get("/users/@me/mfa", human, r.auth.GetMFA)
+	api.Post("/users/@me/mfa/totp", human, r.auth.EnrollTOTP)
+	api.Post("/users/@me/mfa/totp/enable", human, r.auth.EnableTOTP)
+	api.Post("/users/@me/mfa/totp/disable", human, r.auth.DisableTOTP)
+	api.Post("/users/@me/mfa/recovery-codes", human, r.auth.RegenerateRecoveryCodes)
+
+	// Bots
+	api.Get("/bots", human, r.bot.GetMyBots)
+	api.Post("/bots", human, r.bot.Create)
+	api.Post("/bots/:id/token", human, r.bot.RegenerateToken)
+	api.Delete("/bots/:id", human, r.bot.Delete)
 
 	api.Get("/guilds", r.guild.GetMyGuilds)
 	api.Post("/guilds", r.guild.Create)
@@ -127,40 +248,26 @@ func (r *Router) Setup(app *fiber.App) {
 	api.Get("/conversations/:id/messages", r.conversation.GetMessages)
 	api.Post("/conversations/:id/messages", r.conversation.SendMessage)
 
-	app.Use("/ws", func(c *fiber.Ctx) error {
-		if websocket.IsWebSocketUpgrade(c) {
-			token := c.Query("token")
-			if token == "" {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "token required"})
-			}
-			userID, err := r.parseToken(token)
-			if err != nil {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token"})
-			}
-			c.Locals("userID", userID)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
+	api.Post("/gateway/ticket", r.gateway.CreateTicket)
+
+	// Server-Sent Events
+	api.Get("/events", r.events.Stream)
+	api.Put("/events/sessions/:sessionId/rooms/:roomId", r.events.Subscribe)
+	api.Delete("/events/sessions/:sessionId/rooms/:roomId", r.events.Unsubscribe)
+
+	app.Use("/ws", r.gatewayHandshake)
 
 	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
 		userID := c.Locals("userID").(string)
-		ws.ServeWs(r.hub, c, userID)
+		opts := c.Locals("connectOptions").(ws.ConnectOptions)
+		ws.ServeWs(r.hub, c, userID, opts)
 	}))
 
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok"})
 	})
-}
 
-func (r *Router) parseToken(tokenString string) (string, error) {
-	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		return []byte(r.cfg.JWTSecret), nil
+	app.Get("/health/gateway", func(c *fiber.Ctx) error {
+		return c.JSON(r.hub.Stats())
 	})
-	if err != nil || !token.Valid {
-		return "", err
-	}
-	claims := token.Claims.(jwt.MapClaims)
-	return claims["sub"].(string), nil
 }
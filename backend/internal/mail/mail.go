@@ -0,0 +1,132 @@
+// Package mail sends the transactional emails of the auth flows.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a plain-text message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type SMTPMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer sends through an SMTP relay. The connection is upgraded with
+// STARTTLS when the server offers it; auth is skipped without a username.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, port), host: host, from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, envelopeAddress(m.from), []string{msg.To}, render(m.from, msg))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp send: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes messages to dir as .eml files, or to the log when dir is
+// empty. It is meant for development, where the links are copied from there.
+// Unless revealTokens is set, the tokens in links are redacted, so access to
+// the log or the directory is not enough to reset a password.
+type LogMailer struct {
+	dir          string
+	from         string
+	revealTokens bool
+}
+
+func NewLogMailer(dir, from string, revealTokens bool) *LogMailer {
+	return &LogMailer{dir: dir, from: from, revealTokens: revealTokens}
+}
+
+var (
+	unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+	linkToken       = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+)
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	if err := validate(msg); err != nil {
+		return err
+	}
+	if !m.revealTokens {
+		msg.Body = linkToken.ReplaceAllString(msg.Body, "${1}REDACTED")
+	}
+	data := render(m.from, msg)
+	if m.dir == "" {
+		log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("mail dir: %w", err)
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	if err := os.WriteFile(filepath.Join(m.dir, name), data, 0o644); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	return nil
+}
+
+// validate keeps user-supplied values from injecting headers.
+func validate(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("mail: invalid header value")
+	}
+	return nil
+}
+
+func render(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// envelopeAddress strips the display name from "Name <addr>".
+func envelopeAddress(from string) string {
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		return strings.TrimSuffix(from[i+1:], ">")
+	}
+	return from
+}
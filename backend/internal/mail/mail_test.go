@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sentMail(t *testing.T, reveal bool, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	m := NewLogMailer(dir, "PWDH Aether <noreply@localhost>", reveal)
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Passwort zuruecksetzen",
+		Body:    body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("%d mails written", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLogMailerWritesMessage(t *testing.T) {
+	data := sentMail(t, false, "Zeile 1\nZeile 2\n")
+	for _, want := range []string{
+		"From: PWDH Aether <noreply@localhost>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Passwort zuruecksetzen\r\n",
+		"\r\n\r\nZeile 1\r\nZeile 2\r\n",
+	} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("%q missing in\n%s", want, data)
+		}
+	}
+}
+
+const resetBody = "Link:\n\nhttp://localhost:3000/reset-password?token=s3cr3t-Token_1\n"
+
+func TestLogMailerRedactsTokens(t *testing.T) {
+	body := sentMail(t, false, resetBody)
+	if strings.Contains(body, "s3cr3t") {
+		t.Fatalf("token written:\n%s", body)
+	}
+	if !strings.Contains(body, "/reset-password?token=REDACTED") {
+		t.Fatalf("link missing:\n%s", body)
+	}
+}
+
+func TestLogMailerRevealsTokensInDevMode(t *testing.T) {
+	if body := sentMail(t, true, resetBody); !strings.Contains(body, "?token=s3cr3t-Token_1") {
+		t.Fatalf("token not kept:\n%s", body)
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	m := NewLogMailer(t.TempDir(), "noreply@localhost", false)
+	for _, msg := range []Message{
+		{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "x"},
+		{To: "alice@example.com", Subject: "x\nBcc: mallory@example.com"},
+	} {
+		if err := m.Send(context.Background(), msg); err == nil {
+			t.Fatalf("sent %+v", msg)
+		}
+	}
+}
+
+func TestEnvelopeAddress(t *testing.T) {
+	for from, want := range map[string]string{
+		"PWDH Aether <noreply@localhost>": "noreply@localhost",
+		"noreply@localhost":               "noreply@localhost",
+	} {
+		if got := envelopeAddress(from); got != want {
+			t.Errorf("envelopeAddress(%q) = %q, want %q", from, got, want)
+		}
+	}
+}
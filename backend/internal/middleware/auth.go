@@ -1,13 +1,36 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 
+	"pwdh-aether/internal/model"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthRequired(secret string) fiber.Handler {
+// Claims is what an access token identifies: the user and the login session
+// it was issued for.
+type Claims struct {
+	UserID    string
+	SessionID string
+}
+
+// SessionChecker reports whether a login session was revoked.
+type SessionChecker interface {
+	IsRevoked(sessionID string) (bool, error)
+}
+
+// BotAuthenticator resolves a bot token to the bot user and the ID of the
+// token, which takes the place of the login session.
+type BotAuthenticator interface {
+	AuthenticateBot(token string) (userID, tokenID string, err error)
+}
+
+// AuthRequired accepts "Bearer <access token>" from users and
+// "Bot <token>" from bot accounts. Bots are marked with the "bot" local.
+func AuthRequired(secret string, sessions SessionChecker, bots BotAuthenticator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		header := c.Get("Authorization")
 		if header == "" {
@@ -15,33 +38,108 @@ func AuthRequired(secret string) fiber.Handler {
 		}
 
 		parts := strings.SplitN(header, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		if len(parts) != 2 {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid authorization format"})
 		}
 
-		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.NewError(fiber.StatusUnauthorized, "invalid signing method")
+		var claims Claims
+		var err error
+		bot := false
+		switch strings.ToLower(parts[0]) {
+		case "bearer":
+			claims, err = ParseToken(secret, parts[1])
+		case "bot":
+			if resolved, ok := c.Locals(botClaimsLocal).(Claims); ok {
+				claims = resolved
+			} else {
+				claims, err = ParseBotToken(bots, parts[1])
 			}
-			return []byte(secret), nil
-		})
-
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired token"})
+			bot = true
+		default:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid authorization format"})
 		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token claims"})
+		if err != nil {
+			if bot && !errors.Is(err, model.ErrInvalidBotToken) {
+				return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "token check failed"})
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		userID, ok := claims["sub"].(string)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token subject"})
+		revoked, err := sessions.IsRevoked(claims.SessionID)
+		if err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "session check failed"})
+		}
+		if revoked {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "session revoked"})
 		}
 
-		c.Locals("userID", userID)
+		c.Locals("userID", claims.UserID)
+		c.Locals("sessionID", claims.SessionID)
+		c.Locals("bot", bot)
 		return c.Next()
 	}
 }
+
+// HumanOnly keeps bots out of account management: credentials, sessions,
+// two-factor setup, linked identities, data exports and other bots.
+func HumanOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if bot, _ := c.Locals("bot").(bool); bot {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "not available to bots"})
+		}
+		return c.Next()
+	}
+}
+
+// ParseBotToken resolves a bot token to claims whose session is the token's
+// ID. Every place that accepts a bot token goes through here.
+func ParseBotToken(bots BotAuthenticator, token string) (Claims, error) {
+	if token == "" {
+		return Claims{}, model.ErrInvalidBotToken
+	}
+	userID, tokenID, err := bots.AuthenticateBot(token)
+	if err != nil {
+		return Claims{}, err
+	}
+	return Claims{UserID: userID, SessionID: tokenID}, nil
+}
+
+// ParseToken validates an HMAC-signed access token and returns its claims.
+func ParseToken(secret, tokenString string) (Claims, error) {
+	claims, err := ParseTypedToken(secret, tokenString, "")
+	if err != nil {
+		return Claims{}, err
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok || userID == "" {
+		return Claims{}, errors.New("invalid token subject")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return Claims{}, errors.New("invalid token session")
+	}
+	return Claims{UserID: userID, SessionID: sessionID}, nil
+}
+
+// ParseTypedToken validates an HMAC-signed token and returns its claims if
+// its "typ" claim is typ. Access tokens carry no "typ" and are parsed with
+// an empty typ, so a token issued for one purpose never passes for another.
+// Every place that accepts a JWT goes through here.
+func ParseTypedToken(secret, tokenString, typ string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return []byte(secret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid or expired token")
+	}
+	got, present := claims["typ"]
+	if (typ == "" && present) || (typ != "" && got != typ) {
+		return nil, errors.New("invalid token type")
+	}
+	return claims, nil
+}
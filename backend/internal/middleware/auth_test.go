@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "test-secret"
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseTokenRejectsTypedTokens(t *testing.T) {
+	exp := time.Now().Add(time.Minute).Unix()
+	access := sign(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{"sub": "u", "sid": "s", "exp": exp})
+	verify := sign(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{"typ": "email_verify", "sub": "u", "sid": "s", "exp": exp})
+
+	if claims, err := ParseToken(testSecret, access); err != nil || claims.UserID != "u" || claims.SessionID != "s" {
+		t.Fatalf("access token: %+v, %v", claims, err)
+	}
+	if _, err := ParseToken(testSecret, verify); err == nil {
+		t.Fatal("email verification token accepted as access token")
+	}
+	if _, err := ParseTypedToken(testSecret, access, "email_verify"); err == nil {
+		t.Fatal("access token accepted as email verification token")
+	}
+	if _, err := ParseTypedToken(testSecret, verify, "email_verify"); err != nil {
+		t.Fatalf("email verification token: %v", err)
+	}
+}
+
+func TestParseTypedTokenRejectsBadTokens(t *testing.T) {
+	claims := jwt.MapClaims{"typ": "email_verify", "sub": "u", "exp": time.Now().Add(time.Minute).Unix()}
+	for name, token := range map[string]string{
+		"wrong secret": sign(t, jwt.SigningMethodHS256, []byte("other"), claims),
+		"unsigned":     sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims),
+		"expired": sign(t, jwt.SigningMethodHS256, []byte(testSecret), jwt.MapClaims{
+			"typ": "email_verify", "sub": "u", "exp": time.Now().Add(-time.Minute).Unix(),
+		}),
+	} {
+		if _, err := ParseTypedToken(testSecret, token, "email_verify"); err == nil {
+			t.Errorf("%s token accepted", name)
+		}
+	}
+}
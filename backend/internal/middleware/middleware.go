@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"strings"
+
 	"pwdh-aether/internal/config"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,7 +13,7 @@ import (
 	"time"
 )
 
-func Setup(app *fiber.App, cfg *config.Config) {
+func Setup(app *fiber.App, cfg *config.Config, bots BotAuthenticator) {
 	app.Use(recover.New())
 
 	app.Use(logger.New(logger.Config{
@@ -22,15 +24,62 @@ func Setup(app *fiber.App, cfg *config.Config) {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.FrontendURL,
 		AllowMethods:     "GET,POST,PATCH,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,Last-Event-ID",
 		AllowCredentials: true,
 	}))
 
+	// Bot tokens are resolved before the limiters: only a valid token moves
+	// a request from the per-IP limit to the per-bot one, so guessing tokens
+	// is no cheaper than any other request.
+	app.Use(identifyBot(bots))
+
 	app.Use(limiter.New(limiter.Config{
+		Next:       botRequest,
 		Max:        100,
 		Expiration: 1 * time.Minute,
 		KeyGenerator: func(c *fiber.Ctx) string {
 			return c.IP()
 		},
 	}))
+
+	// Bots are limited per token rather than per IP, since several of them
+	// often run on one host.
+	app.Use(limiter.New(limiter.Config{
+		Next:       func(c *fiber.Ctx) bool { return !botRequest(c) },
+		Max:        cfg.BotRateLimit,
+		Expiration: 1 * time.Minute,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			return "bot:" + c.Locals(botClaimsLocal).(Claims).SessionID
+		},
+	}))
+}
+
+// botClaimsLocal holds the claims of a bot token resolved by identifyBot.
+const botClaimsLocal = "botClaims"
+
+// identifyBot resolves a "Bot" authorization header and keeps the claims for
+// the limiters and AuthRequired. The auth endpoints never accept a bot token,
+// so they stay limited per IP.
+func identifyBot(bots BotAuthenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scheme, token, ok := strings.Cut(c.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "bot") && !authPath(c.Path()) {
+			if claims, err := ParseBotToken(bots, token); err == nil {
+				c.Locals(botClaimsLocal, claims)
+			}
+		}
+		return c.Next()
+	}
+}
+
+// botRequest reports whether the request carries a valid bot token.
+func botRequest(c *fiber.Ctx) bool {
+	_, ok := c.Locals(botClaimsLocal).(Claims)
+	return ok
+}
+
+// authPath matches the auth endpoints the way the router does, ignoring case.
+func authPath(path string) bool {
+	const prefix = "/api/auth/"
+	return len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix)
 }
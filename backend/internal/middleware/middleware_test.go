@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// stubBots accepts a single token and counts the lookups.
+type stubBots struct{ lookups atomic.Int32 }
+
+func (b *stubBots) AuthenticateBot(token string) (string, string, error) {
+	b.lookups.Add(1)
+	if token != "valid" {
+		return "", "", model.ErrInvalidBotToken
+	}
+	return "bot-user", "token-1", nil
+}
+
+func testConfig(botRateLimit int) *config.Config {
+	return &config.Config{FrontendURL: "http://localhost:3000", BotRateLimit: botRateLimit}
+}
+
+func newLimitedApp(t *testing.T, bots *stubBots) *fiber.App {
+	t.Helper()
+	app := fiber.New()
+	Setup(app, testConfig(3), bots)
+	app.Post("/api/auth/login", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	app.Get("/api/ping", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	return app
+}
+
+func status(t *testing.T, app *fiber.App, method, path, auth string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestInvalidBotTokenCountsAgainstIP(t *testing.T) {
+	app := newLimitedApp(t, &stubBots{})
+	for i := 0; i < 100; i++ {
+		if got := status(t, app, "GET", "/api/ping", "Bot garbage"); got != fiber.StatusNoContent {
+			t.Fatalf("request %d: status %d", i, got)
+		}
+	}
+	if got := status(t, app, "GET", "/api/ping", ""); got != fiber.StatusTooManyRequests {
+		t.Fatalf("request past the IP limit: status %d, want 429", got)
+	}
+}
+
+func TestValidBotTokenHasOwnLimit(t *testing.T) {
+	bots := &stubBots{}
+	app := newLimitedApp(t, bots)
+	for i := 0; i < 3; i++ {
+		if got := status(t, app, "GET", "/api/ping", "Bot valid"); got != fiber.StatusNoContent {
+			t.Fatalf("request %d: status %d", i, got)
+		}
+	}
+	if got := status(t, app, "GET", "/api/ping", "bot valid"); got != fiber.StatusTooManyRequests {
+		t.Fatalf("request past the bot limit: status %d, want 429", got)
+	}
+	// The bot's requests did not use up the IP limit.
+	if got := status(t, app, "GET", "/api/ping", ""); got != fiber.StatusNoContent {
+		t.Fatalf("user request: status %d", got)
+	}
+}
+
+func TestAuthPathsIgnoreBotTokens(t *testing.T) {
+	bots := &stubBots{}
+	app := newLimitedApp(t, bots)
+	for _, path := range []string{"/api/auth/login", "/API/Auth/login"} {
+		if got := status(t, app, "POST", path, "Bot valid"); got != fiber.StatusNoContent {
+			t.Fatalf("%s: status %d", path, got)
+		}
+	}
+	if n := bots.lookups.Load(); n != 0 {
+		t.Fatalf("resolved a bot token on an auth path %d times", n)
+	}
+}
+
+func TestAuthRequiredReusesResolvedBotToken(t *testing.T) {
+	bots := &stubBots{}
+	app := fiber.New()
+	Setup(app, testConfig(10), bots)
+	app.Get("/api/ping", AuthRequired(testSecret, noRevocations{}, bots), func(c *fiber.Ctx) error {
+		if c.Locals("userID") != "bot-user" || c.Locals("bot") != true {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	if got := status(t, app, "GET", "/api/ping", "Bot valid"); got != fiber.StatusNoContent {
+		t.Fatalf("status %d", got)
+	}
+	if n := bots.lookups.Load(); n != 1 {
+		t.Fatalf("looked the token up %d times, want once", n)
+	}
+	if got := status(t, app, "GET", "/api/ping", "Bot garbage"); got != fiber.StatusUnauthorized {
+		t.Fatalf("invalid token: status %d, want 401", got)
+	}
+}
+
+func TestHumanOnlyRejectsBots(t *testing.T) {
+	bots := &stubBots{}
+	app := fiber.New()
+	Setup(app, testConfig(10), bots)
+	app.Get("/api/account", AuthRequired(testSecret, noRevocations{}, bots), HumanOnly(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	if got := status(t, app, "GET", "/api/account", "Bot valid"); got != fiber.StatusForbidden {
+		t.Fatalf("status %d, want 403", got)
+	}
+}
+
+type noRevocations struct{}
+
+func (noRevocations) IsRevoked(string) (bool, error) { return false, nil }
@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// Bot is the bot side of a user with users.bot set: who owns it and which
+// token it authenticates with. Only a hash of the token is stored; TokenID
+// changes with every regeneration and stands in for the login session.
+type Bot struct {
+	UserID         string    `json:"user_id" db:"user_id"`
+	OwnerID        string    `json:"owner_id" db:"owner_id"`
+	TokenID        string    `json:"-" db:"token_id"`
+	TokenHash      string    `json:"-" db:"token_hash"`
+	TokenCreatedAt time.Time `json:"token_created_at" db:"token_created_at"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// BotResponse carries the token only right after it was created or
+// regenerated; it cannot be read again later.
+type BotResponse struct {
+	User           UserResponse `json:"user"`
+	OwnerID        string       `json:"owner_id"`
+	Token          string       `json:"token,omitempty"`
+	TokenCreatedAt time.Time    `json:"token_created_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+type CreateBotRequest struct {
+	Username  string  `json:"username" validate:"required,min=3,max=50"`
+	AvatarURL *string `json:"avatar_url"`
+}
@@ -3,17 +3,48 @@ package model
 import "errors"
 
 var (
-	ErrNotFound           = errors.New("not found")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrEmailTaken         = errors.New("email already in use")
-	ErrUsernameTaken      = errors.New("username already in use")
-	ErrInvalidCredentials = errors.New("invalid email or password")
-	ErrGuildNotFound      = errors.New("guild not found")
-	ErrChannelNotFound    = errors.New("channel not found")
-	ErrMessageNotFound    = errors.New("message not found")
-	ErrNotAuthorized      = errors.New("not authorized")
-	ErrNotMember          = errors.New("not a member of this guild")
-	ErrAlreadyMember      = errors.New("already a member")
-	ErrInvalidInvite      = errors.New("invalid or expired invite")
-	ErrConversationNotFound = errors.New("conversation not found")
+	ErrNotFound              = errors.New("not found")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrEmailTaken            = errors.New("email already in use")
+	ErrUsernameTaken         = errors.New("username already in use")
+	ErrInvalidCredentials    = errors.New("invalid email or password")
+	ErrGuildNotFound         = errors.New("guild not found")
+	ErrChannelNotFound       = errors.New("channel not found")
+	ErrMessageNotFound       = errors.New("message not found")
+	ErrNotAuthorized         = errors.New("not authorized")
+	ErrNotMember             = errors.New("not a member of this guild")
+	ErrAlreadyMember         = errors.New("already a member")
+	ErrInvalidInvite         = errors.New("invalid or expired invite")
+	ErrConversationNotFound  = errors.New("conversation not found")
+	ErrNotConversationMember = errors.New("not a member of this conversation")
+	ErrInvalidContent        = errors.New("content is required and may be at most 4000 characters")
+	ErrInvalidEmoji          = errors.New("emoji is required")
+	ErrSessionNotFound       = errors.New("session not found")
+	ErrInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	ErrMFARequired           = errors.New("two-factor authentication required")
+	ErrInvalidMFACode        = errors.New("invalid two-factor code")
+	ErrInvalidMFATicket      = errors.New("invalid or expired mfa ticket")
+	ErrMFAAlreadyEnabled     = errors.New("two-factor authentication already enabled")
+	ErrMFANotEnabled         = errors.New("two-factor authentication not enabled")
+	ErrEmailNotVerified      = errors.New("email address not verified")
+	ErrEmailAlreadyVerified  = errors.New("email address already verified")
+	ErrInvalidVerifyToken    = errors.New("invalid or expired verification token")
+	ErrInvalidResetToken     = errors.New("invalid or expired reset token")
+	ErrInvalidPassword       = errors.New("current password is incorrect")
+	ErrInvalidGuildPolicy    = errors.New("guild_policy must be transfer or delete")
+	ErrDeletionNotScheduled  = errors.New("no account deletion scheduled")
+	ErrExportInProgress      = errors.New("an export is already being prepared")
+	ErrExportCooldown        = errors.New("an export was already created in the last 24 hours")
+	ErrUnknownProvider       = errors.New("unknown login provider")
+	ErrInvalidOIDCState      = errors.New("invalid or expired login state")
+	ErrOIDCLoginFailed       = errors.New("provider login failed")
+	ErrOIDCEmailRequired     = errors.New("provider did not return an email address")
+	ErrIdentityNotFound      = errors.New("identity not found")
+	ErrIdentityTaken         = errors.New("identity already linked to an account")
+	ErrIdentityEmailInUse    = errors.New("an account with this email exists; log in and link the provider from there")
+	ErrLastLoginMethod       = errors.New("cannot remove the last way to log in")
+	ErrReauthRequired        = errors.New("log in with your provider again to confirm")
+	ErrBotNotFound           = errors.New("bot not found")
+	ErrInvalidBotToken       = errors.New("invalid bot token")
+	ErrBotLimitReached       = errors.New("bot limit reached")
 )
@@ -0,0 +1,65 @@
+package model
+
+import "time"
+
+const (
+	ExportPending = "PENDING"
+	ExportReady   = "READY"
+	ExportFailed  = "FAILED"
+	ExportExpired = "EXPIRED"
+)
+
+// DataExport is a ZIP of everything stored about a user. DownloadURL is a
+// presigned link filled in when the export is handed out.
+type DataExport struct {
+	ID          string     `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	Status      string     `json:"status" db:"status"`
+	ObjectKey   *string    `json:"-" db:"object_key"`
+	SizeBytes   *int64     `json:"size_bytes" db:"size_bytes"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// Rows of the export archive.
+
+type ExportMembership struct {
+	GuildID   string    `json:"guild_id"`
+	GuildName string    `json:"guild_name"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+type ExportMessage struct {
+	ID            string     `json:"id"`
+	GuildID       string     `json:"guild_id"`
+	ChannelID     string     `json:"channel_id"`
+	ChannelName   string     `json:"channel_name"`
+	Content       string     `json:"content"`
+	AttachmentURL *string    `json:"attachment_url"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+}
+
+type ExportDirectMessage struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	Content        string    `json:"content"`
+	AttachmentURL  *string   `json:"attachment_url"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type ExportReaction struct {
+	MessageID string    `json:"message_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportPresenceChange struct {
+	Status       string    `json:"status"`
+	ManualStatus *string   `json:"manual_status,omitempty"`
+	GameName     *string   `json:"game_name"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
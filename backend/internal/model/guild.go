@@ -8,6 +8,7 @@ type Guild struct {
 	IconURL    *string   `json:"icon_url" db:"icon_url"`
 	OwnerID    string    `json:"owner_id" db:"owner_id"`
 	InviteCode string    `json:"invite_code" db:"invite_code"`
+	RequireMFA bool      `json:"require_mfa" db:"require_mfa"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -40,8 +41,9 @@ type CreateGuildRequest struct {
 }
 
 type UpdateGuildRequest struct {
-	Name    *string `json:"name"`
-	IconURL *string `json:"icon_url"`
+	Name       *string `json:"name"`
+	IconURL    *string `json:"icon_url"`
+	RequireMFA *bool   `json:"require_mfa"`
 }
 
 type JoinGuildRequest struct {
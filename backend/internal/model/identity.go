@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// Identity links a user to an account at an external OIDC provider, keyed by
+// the provider's subject claim.
+type Identity struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	Provider  string    `json:"provider" db:"provider"`
+	Subject   string    `json:"subject" db:"subject"`
+	Email     *string   `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type OIDCProviderResponse struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// OIDCAuthorizeResponse carries the provider URL. Binding is not part of the
+// body; the handler sets it as a cookie.
+type OIDCAuthorizeResponse struct {
+	URL     string `json:"url"`
+	Binding string `json:"-"`
+}
+
+// OIDCCallbackRequest is what the callback page posts. Binding comes from the
+// cookie set when the flow started.
+type OIDCCallbackRequest struct {
+	Code    string `json:"code" validate:"required"`
+	State   string `json:"state" validate:"required"`
+	Binding string `json:"-"`
+}
+
+// ReauthResponse carries a one-time token that confirms the user instead of
+// a password.
+type ReauthResponse struct {
+	ReauthToken string `json:"reauth_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
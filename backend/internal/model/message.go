@@ -2,6 +2,9 @@ package model
 
 import "time"
 
+// MaxMessageLength is the maximum message length in characters.
+const MaxMessageLength = 4000
+
 type Message struct {
 	ID            string     `json:"id" db:"id"`
 	ChannelID     string     `json:"channel_id" db:"channel_id"`
@@ -5,6 +5,9 @@ import "time"
 type UserPresence struct {
 	UserID       string     `json:"user_id" db:"user_id"`
 	Status       string     `json:"status" db:"status"`
+	// ManualStatus is the status the user picked, nil while it follows the
+	// connections. Only the user sees it.
+	ManualStatus *string    `json:"manual_status,omitempty" db:"manual_status"`
 	GameName     *string    `json:"game_name" db:"game_name"`
 	GameStartedAt *time.Time `json:"game_started_at" db:"game_started_at"`
 	CustomStatus *string    `json:"custom_status" db:"custom_status"`
@@ -12,7 +15,7 @@ type UserPresence struct {
 }
 
 type UpdatePresenceRequest struct {
-	Status       *string `json:"status" validate:"omitempty,oneof=ONLINE IDLE DND OFFLINE"`
+	Status       *string `json:"status" validate:"omitempty,oneof=ONLINE IDLE DND INVISIBLE"`
 	GameName     *string `json:"game_name"`
 	CustomStatus *string `json:"custom_status"`
 }
@@ -22,4 +25,7 @@ const (
 	StatusIdle    = "IDLE"
 	StatusDND     = "DND"
 	StatusOffline = "OFFLINE"
+	// StatusInvisible is only ever a manual status: the user shows as
+	// OFFLINE while connected.
+	StatusInvisible = "INVISIBLE"
 )
@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+// Session is one login on one device. It owns the rotating refresh token,
+// of which only the SHA-256 hash is stored.
+type Session struct {
+	ID                string     `json:"id" db:"id"`
+	UserID            string     `json:"user_id" db:"user_id"`
+	RefreshTokenHash  string     `json:"-" db:"refresh_token_hash"`
+	PreviousTokenHash *string    `json:"-" db:"previous_token_hash"`
+	UserAgent         string     `json:"user_agent" db:"user_agent"`
+	IP                string     `json:"ip" db:"ip"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt        time.Time  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt         time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt         *time.Time `json:"revoked_at" db:"revoked_at"`
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// ClientInfo describes the device a session is created or refreshed from.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+func (s *Session) ToResponse(currentID string) SessionResponse {
+	return SessionResponse{
+		ID:         s.ID,
+		UserAgent:  s.UserAgent,
+		IP:         s.IP,
+		CreatedAt:  s.CreatedAt,
+		LastUsedAt: s.LastUsedAt,
+		Current:    s.ID == currentID,
+	}
+}
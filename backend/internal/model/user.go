@@ -3,20 +3,27 @@ package model
 import "time"
 
 type User struct {
-	ID           string    `json:"id" db:"id"`
-	Username     string    `json:"username" db:"username"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	AvatarURL    *string   `json:"avatar_url" db:"avatar_url"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ID              string     `json:"id" db:"id"`
+	Username        string     `json:"username" db:"username"`
+	Email           string     `json:"email" db:"email"`
+	PasswordHash    string     `json:"-" db:"password_hash"`
+	AvatarURL       *string    `json:"avatar_url" db:"avatar_url"`
+	TOTPEnabled     bool       `json:"-" db:"totp_enabled"`
+	EmailVerifiedAt *time.Time `json:"-" db:"email_verified_at"`
+	DeletionAt      *time.Time `json:"-" db:"deletion_scheduled_at"`
+	Bot             bool       `json:"bot" db:"bot"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
 }
 
 type UserResponse struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	AvatarURL *string   `json:"avatar_url"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            string     `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	AvatarURL     *string    `json:"avatar_url"`
+	EmailVerified bool       `json:"email_verified,omitempty"`
+	DeletionAt    *time.Time `json:"deletion_scheduled_at,omitempty"`
+	Bot           bool       `json:"bot,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
 }
 
 type RegisterRequest struct {
@@ -37,8 +44,10 @@ type UpdateUserRequest struct {
 }
 
 type TokenResponse struct {
-	AccessToken string       `json:"access_token"`
-	User        UserResponse `json:"user"`
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresIn    int64        `json:"expires_in"`
+	User         UserResponse `json:"user"`
 }
 
 func (u *User) ToResponse() UserResponse {
@@ -48,5 +57,80 @@ func (u *User) ToResponse() UserResponse {
 		Email:     u.Email,
 		AvatarURL: u.AvatarURL,
 		CreatedAt: u.CreatedAt,
+
+		EmailVerified: u.EmailVerifiedAt != nil,
+		DeletionAt:    u.DeletionAt,
+		Bot:           u.Bot,
 	}
 }
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+	Code            string `json:"code"`
+	ReauthToken     string `json:"reauth_token"`
+}
+
+// Guild policies for account deletion: owned guilds are handed to the
+// highest-ranking remaining member, or deleted.
+const (
+	GuildPolicyTransfer = "transfer"
+	GuildPolicyDelete   = "delete"
+)
+
+type DeleteAccountRequest struct {
+	Password    string `json:"password" validate:"required"`
+	Code        string `json:"code"`
+	ReauthToken string `json:"reauth_token"`
+	GuildPolicy string `json:"guild_policy"`
+}
+
+type AccountDeletion struct {
+	UserID      string
+	GuildPolicy string
+	ScheduledAt time.Time
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+// MFAChallenge is returned by login instead of tokens when the account has
+// two-factor authentication enabled. The ticket is exchanged for tokens
+// together with a TOTP or recovery code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	Ticket      string `json:"mfa_ticket"`
+}
+
+type MFALoginRequest struct {
+	Ticket string `json:"mfa_ticket" validate:"required"`
+	Code   string `json:"code" validate:"required"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAStatusResponse struct {
+	TOTPEnabled            bool `json:"totp_enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
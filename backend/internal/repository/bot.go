@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/lib/pq"
+)
+
+type BotRepository struct {
+	db *sql.DB
+}
+
+func NewBotRepository(db *sql.DB) *BotRepository {
+	return &BotRepository{db: db}
+}
+
+// Create inserts the bot's user row and its token in one transaction. Bots
+// have no password and count as verified, since they have no mailbox.
+func (r *BotRepository) Create(user *model.User, bot *model.Bot) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`INSERT INTO users (id, username, email, password_hash, avatar_url, bot, email_verified_at)
+		VALUES ($1, $2, $3, '', $4, TRUE, NOW()) RETURNING email_verified_at, created_at`,
+		user.ID, user.Username, user.Email, user.AvatarURL,
+	).Scan(&user.EmailVerifiedAt, &user.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return model.ErrUsernameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("create bot user: %w", err)
+	}
+	user.Bot = true
+
+	err = tx.QueryRow(`INSERT INTO bots (user_id, owner_id, token_id, token_hash) VALUES ($1, $2, $3, $4)
+		RETURNING token_created_at, created_at`,
+		bot.UserID, bot.OwnerID, bot.TokenID, bot.TokenHash,
+	).Scan(&bot.TokenCreatedAt, &bot.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create bot: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *BotRepository) GetByUserID(userID string) (*model.Bot, error) {
+	return r.get(`WHERE user_id = $1`, userID)
+}
+
+func (r *BotRepository) GetByTokenHash(hash string) (*model.Bot, error) {
+	return r.get(`WHERE token_hash = $1`, hash)
+}
+
+func (r *BotRepository) get(where string, arg string) (*model.Bot, error) {
+	b := &model.Bot{}
+	query := `SELECT user_id, owner_id, token_id, token_hash, token_created_at, created_at FROM bots ` + where
+	err := r.db.QueryRow(query, arg).Scan(&b.UserID, &b.OwnerID, &b.TokenID, &b.TokenHash, &b.TokenCreatedAt, &b.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrBotNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get bot: %w", err)
+	}
+	return b, nil
+}
+
+func (r *BotRepository) GetByOwnerID(ownerID string) ([]model.Bot, error) {
+	query := `SELECT user_id, owner_id, token_id, token_hash, token_created_at, created_at FROM bots
+		WHERE owner_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bots []model.Bot
+	for rows.Next() {
+		var b model.Bot
+		if err := rows.Scan(&b.UserID, &b.OwnerID, &b.TokenID, &b.TokenHash, &b.TokenCreatedAt, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		bots = append(bots, b)
+	}
+	return bots, rows.Err()
+}
+
+func (r *BotRepository) CountByOwnerID(ownerID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM bots WHERE owner_id = $1`, ownerID).Scan(&count)
+	return count, err
+}
+
+// UpdateToken replaces the bot's token; the old one stops working at once.
+func (r *BotRepository) UpdateToken(bot *model.Bot) error {
+	query := `UPDATE bots SET token_id = $2, token_hash = $3, token_created_at = NOW() WHERE user_id = $1
+		RETURNING token_created_at`
+	err := r.db.QueryRow(query, bot.UserID, bot.TokenID, bot.TokenHash).Scan(&bot.TokenCreatedAt)
+	if err == sql.ErrNoRows {
+		return model.ErrBotNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("update bot token: %w", err)
+	}
+	return nil
+}
@@ -73,8 +73,14 @@ func (r *ConversationRepository) IsMember(convID, userID string) (bool, error) {
 	return exists, err
 }
 
+func (r *ConversationRepository) RemoveMember(convID, userID string) error {
+	query := `DELETE FROM conversation_members WHERE conversation_id = $1 AND user_id = $2`
+	_, err := r.db.Exec(query, convID, userID)
+	return err
+}
+
 func (r *ConversationRepository) GetMembers(convID string) ([]model.User, error) {
-	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.created_at
+	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.bot, u.created_at
 		FROM conversation_members cm JOIN users u ON cm.user_id = u.id WHERE cm.conversation_id = $1`
 	rows, err := r.db.Query(query, convID)
 	if err != nil {
@@ -85,7 +91,7 @@ func (r *ConversationRepository) GetMembers(convID string) ([]model.User, error)
 	var users []model.User
 	for rows.Next() {
 		var u model.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.CreatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.Bot, &u.CreatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pwdh-aether/internal/model"
+)
+
+type ExportRepository struct {
+	db *sql.DB
+}
+
+func NewExportRepository(db *sql.DB) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+const exportColumns = `id, user_id, status, object_key, size_bytes, created_at, completed_at, expires_at`
+
+func scanExport(row interface{ Scan(...interface{}) error }) (*model.DataExport, error) {
+	e := &model.DataExport{}
+	err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.ObjectKey, &e.SizeBytes, &e.CreatedAt, &e.CompletedAt, &e.ExpiresAt)
+	return e, err
+}
+
+func (r *ExportRepository) Create(userID string) (*model.DataExport, error) {
+	query := `INSERT INTO data_exports (user_id) VALUES ($1) RETURNING ` + exportColumns
+	e, err := scanExport(r.db.QueryRow(query, userID))
+	if err != nil {
+		return nil, fmt.Errorf("create export: %w", err)
+	}
+	return e, nil
+}
+
+func (r *ExportRepository) GetLatestByUserID(userID string) (*model.DataExport, error) {
+	query := `SELECT ` + exportColumns + ` FROM data_exports WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`
+	e, err := scanExport(r.db.QueryRow(query, userID))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get export: %w", err)
+	}
+	return e, nil
+}
+
+// GetByUserID lists every export of a user, whatever its status.
+func (r *ExportRepository) GetByUserID(userID string) ([]model.DataExport, error) {
+	query := `SELECT ` + exportColumns + ` FROM data_exports WHERE user_id = $1`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []model.DataExport
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *e)
+	}
+	return exports, rows.Err()
+}
+
+func (r *ExportRepository) DeleteByUserID(userID string) error {
+	_, err := r.db.Exec(`DELETE FROM data_exports WHERE user_id = $1`, userID)
+	return err
+}
+
+func (r *ExportRepository) MarkReady(id, objectKey string, size int64, expiresAt time.Time) error {
+	query := `UPDATE data_exports SET status = 'READY', object_key = $2, size_bytes = $3,
+		completed_at = NOW(), expires_at = $4 WHERE id = $1`
+	_, err := r.db.Exec(query, id, objectKey, size, expiresAt)
+	return err
+}
+
+func (r *ExportRepository) MarkFailed(id string) error {
+	_, err := r.db.Exec(`UPDATE data_exports SET status = 'FAILED', completed_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (r *ExportRepository) MarkExpired(id string) error {
+	_, err := r.db.Exec(`UPDATE data_exports SET status = 'EXPIRED' WHERE id = $1`, id)
+	return err
+}
+
+// GetExpired returns ready exports past their retention.
+func (r *ExportRepository) GetExpired(limit int) ([]model.DataExport, error) {
+	query := `SELECT ` + exportColumns + ` FROM data_exports
+		WHERE status = 'READY' AND expires_at <= NOW() ORDER BY expires_at LIMIT $1`
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []model.DataExport
+	for rows.Next() {
+		e, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, *e)
+	}
+	return exports, rows.Err()
+}
+
+func (r *ExportRepository) GetMemberships(userID string) ([]model.ExportMembership, error) {
+	query := `SELECT g.id, g.name, m.role, m.joined_at
+		FROM members m JOIN guilds g ON g.id = m.guild_id WHERE m.user_id = $1 ORDER BY m.joined_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ExportMembership
+	for rows.Next() {
+		var m model.ExportMembership
+		if err := rows.Scan(&m.GuildID, &m.GuildName, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *ExportRepository) GetMessages(userID string) ([]model.ExportMessage, error) {
+	query := `SELECT m.id, c.guild_id, m.channel_id, c.name, m.content, m.attachment_url, m.created_at, m.updated_at
+		FROM messages m JOIN channels c ON c.id = m.channel_id WHERE m.user_id = $1 ORDER BY m.created_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ExportMessage
+	for rows.Next() {
+		var m model.ExportMessage
+		if err := rows.Scan(&m.ID, &m.GuildID, &m.ChannelID, &m.ChannelName, &m.Content, &m.AttachmentURL, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *ExportRepository) GetDirectMessages(userID string) ([]model.ExportDirectMessage, error) {
+	query := `SELECT id, conversation_id, content, attachment_url, created_at
+		FROM direct_messages WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ExportDirectMessage
+	for rows.Next() {
+		var m model.ExportDirectMessage
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Content, &m.AttachmentURL, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *ExportRepository) GetReactions(userID string) ([]model.ExportReaction, error) {
+	query := `SELECT message_id, emoji, created_at FROM reactions WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ExportReaction
+	for rows.Next() {
+		var m model.ExportReaction
+		if err := rows.Scan(&m.MessageID, &m.Emoji, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *ExportRepository) GetSoundboardClips(userID string) ([]model.SoundboardClip, error) {
+	query := `SELECT id, guild_id, name, file_url, uploaded_by, created_at
+		FROM soundboard_clips WHERE uploaded_by = $1 ORDER BY created_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.SoundboardClip
+	for rows.Next() {
+		var c model.SoundboardClip
+		if err := rows.Scan(&c.ID, &c.GuildID, &c.Name, &c.FileURL, &c.UploadedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+func (r *ExportRepository) GetPresenceHistory(userID string) ([]model.ExportPresenceChange, error) {
+	query := `SELECT status, manual_status, game_name, changed_at
+		FROM presence_history WHERE user_id = $1 ORDER BY changed_at, id`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []model.ExportPresenceChange
+	for rows.Next() {
+		var p model.ExportPresenceChange
+		if err := rows.Scan(&p.Status, &p.ManualStatus, &p.GameName, &p.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
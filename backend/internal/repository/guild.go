@@ -27,8 +27,8 @@ func (r *GuildRepository) Create(guild *model.Guild) error {
 
 func (r *GuildRepository) GetByID(id string) (*model.Guild, error) {
 	g := &model.Guild{}
-	query := `SELECT id, name, icon_url, owner_id, invite_code, created_at FROM guilds WHERE id = $1`
-	err := r.db.QueryRow(query, id).Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.CreatedAt)
+	query := `SELECT id, name, icon_url, owner_id, invite_code, require_mfa, created_at FROM guilds WHERE id = $1`
+	err := r.db.QueryRow(query, id).Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.RequireMFA, &g.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrGuildNotFound
 	}
@@ -36,7 +36,7 @@ func (r *GuildRepository) GetByID(id string) (*model.Guild, error) {
 }
 
 func (r *GuildRepository) GetByUserID(userID string) ([]model.Guild, error) {
-	query := `SELECT g.id, g.name, g.icon_url, g.owner_id, g.invite_code, g.created_at
+	query := `SELECT g.id, g.name, g.icon_url, g.owner_id, g.invite_code, g.require_mfa, g.created_at
 		FROM guilds g JOIN members m ON g.id = m.guild_id WHERE m.user_id = $1 ORDER BY g.created_at`
 	rows, err := r.db.Query(query, userID)
 	if err != nil {
@@ -47,7 +47,26 @@ func (r *GuildRepository) GetByUserID(userID string) ([]model.Guild, error) {
 	var guilds []model.Guild
 	for rows.Next() {
 		var g model.Guild
-		if err := rows.Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.CreatedAt); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.RequireMFA, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		guilds = append(guilds, g)
+	}
+	return guilds, rows.Err()
+}
+
+func (r *GuildRepository) GetOwnedByUserID(userID string) ([]model.Guild, error) {
+	query := `SELECT id, name, icon_url, owner_id, invite_code, require_mfa, created_at FROM guilds WHERE owner_id = $1`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guilds []model.Guild
+	for rows.Next() {
+		var g model.Guild
+		if err := rows.Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.RequireMFA, &g.CreatedAt); err != nil {
 			return nil, err
 		}
 		guilds = append(guilds, g)
@@ -56,8 +75,8 @@ func (r *GuildRepository) GetByUserID(userID string) ([]model.Guild, error) {
 }
 
 func (r *GuildRepository) Update(guild *model.Guild) error {
-	query := `UPDATE guilds SET name = $2, icon_url = $3 WHERE id = $1`
-	_, err := r.db.Exec(query, guild.ID, guild.Name, guild.IconURL)
+	query := `UPDATE guilds SET name = $2, icon_url = $3, require_mfa = $4 WHERE id = $1`
+	_, err := r.db.Exec(query, guild.ID, guild.Name, guild.IconURL, guild.RequireMFA)
 	return err
 }
 
@@ -105,9 +124,14 @@ func (r *GuildRepository) GetMember(guildID, userID string) (*model.Member, erro
 	return m, err
 }
 
+// memberResponseQuery selects members with their user and current status.
+const memberResponseQuery = `SELECT u.id, u.username, u.email, u.avatar_url, u.bot, u.created_at, m.role, m.joined_at,
+		COALESCE(p.status, 'OFFLINE')
+	FROM members m JOIN users u ON m.user_id = u.id
+	LEFT JOIN user_presence p ON p.user_id = m.user_id`
+
 func (r *GuildRepository) GetMembers(guildID string) ([]model.MemberResponse, error) {
-	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.created_at, m.role, m.joined_at
-		FROM members m JOIN users u ON m.user_id = u.id WHERE m.guild_id = $1 ORDER BY m.role, u.username`
+	query := memberResponseQuery + ` WHERE m.guild_id = $1 ORDER BY m.role, u.username`
 	rows, err := r.db.Query(query, guildID)
 	if err != nil {
 		return nil, err
@@ -118,26 +142,72 @@ func (r *GuildRepository) GetMembers(guildID string) ([]model.MemberResponse, er
 	for rows.Next() {
 		var mr model.MemberResponse
 		var u model.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.CreatedAt, &mr.Role, &mr.JoinedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.Bot, &u.CreatedAt, &mr.Role, &mr.JoinedAt, &mr.Status); err != nil {
 			return nil, err
 		}
 		mr.User = u.ToResponse()
-		mr.Status = model.StatusOffline
 		members = append(members, mr)
 	}
 	return members, rows.Err()
 }
 
+// GetMemberResponse returns a single member the way GetMembers lists them.
+func (r *GuildRepository) GetMemberResponse(guildID, userID string) (*model.MemberResponse, error) {
+	var mr model.MemberResponse
+	var u model.User
+	query := memberResponseQuery + ` WHERE m.guild_id = $1 AND m.user_id = $2`
+	err := r.db.QueryRow(query, guildID, userID).Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.Bot, &u.CreatedAt, &mr.Role, &mr.JoinedAt, &mr.Status)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	mr.User = u.ToResponse()
+	return &mr, nil
+}
+
 func (r *GuildRepository) GetByInviteCode(code string) (*model.Guild, error) {
 	g := &model.Guild{}
-	query := `SELECT id, name, icon_url, owner_id, invite_code, created_at FROM guilds WHERE invite_code = $1`
-	err := r.db.QueryRow(query, code).Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.CreatedAt)
+	query := `SELECT id, name, icon_url, owner_id, invite_code, require_mfa, created_at FROM guilds WHERE invite_code = $1`
+	err := r.db.QueryRow(query, code).Scan(&g.ID, &g.Name, &g.IconURL, &g.OwnerID, &g.InviteCode, &g.RequireMFA, &g.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrInvalidInvite
 	}
 	return g, err
 }
 
+// GetSuccessor picks the member who inherits the guild when ownerID goes:
+// the highest role first, then the longest membership.
+func (r *GuildRepository) GetSuccessor(guildID, ownerID string) (*model.Member, error) {
+	m := &model.Member{}
+	query := `SELECT user_id, guild_id, role, joined_at FROM members
+		WHERE guild_id = $1 AND user_id <> $2
+		ORDER BY CASE role WHEN 'ADMIN' THEN 0 WHEN 'MODERATOR' THEN 1 ELSE 2 END, joined_at
+		LIMIT 1`
+	err := r.db.QueryRow(query, guildID, ownerID).Scan(&m.UserID, &m.GuildID, &m.Role, &m.JoinedAt)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotMember
+	}
+	return m, err
+}
+
+func (r *GuildRepository) TransferOwnership(guildID, newOwnerID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE guilds SET owner_id = $2 WHERE id = $1`, guildID, newOwnerID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE members SET role = 'OWNER' WHERE guild_id = $1 AND user_id = $2`, guildID, newOwnerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (r *GuildRepository) UpdateMemberRole(guildID, userID, role string) error {
 	query := `UPDATE members SET role = $3 WHERE guild_id = $1 AND user_id = $2`
 	_, err := r.db.Exec(query, guildID, userID, role)
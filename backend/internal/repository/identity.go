@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/lib/pq"
+)
+
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// Create links the identity. A subject that is already linked, or a second
+// identity at the same provider, is reported as ErrIdentityTaken.
+func (r *IdentityRepository) Create(identity *model.Identity) error {
+	query := `INSERT INTO user_identities (id, user_id, provider, subject, email) VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+	err := r.db.QueryRow(query, identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email).
+		Scan(&identity.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return model.ErrIdentityTaken
+	}
+	if err != nil {
+		return fmt.Errorf("create identity: %w", err)
+	}
+	return nil
+}
+
+func (r *IdentityRepository) GetBySubject(provider, subject string) (*model.Identity, error) {
+	i := &model.Identity{}
+	query := `SELECT id, user_id, provider, subject, email, created_at FROM user_identities
+		WHERE provider = $1 AND subject = $2`
+	err := r.db.QueryRow(query, provider, subject).Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrIdentityNotFound
+	}
+	return i, err
+}
+
+func (r *IdentityRepository) GetByUserID(userID string) ([]model.Identity, error) {
+	query := `SELECT id, user_id, provider, subject, email, created_at FROM user_identities
+		WHERE user_id = $1 ORDER BY created_at`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []model.Identity{}
+	for rows.Next() {
+		var i model.Identity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+func (r *IdentityRepository) Delete(id, userID string) error {
+	res, err := r.db.Exec(`DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrIdentityNotFound
+	}
+	return nil
+}
@@ -24,7 +24,25 @@ func (r *LFGRepository) Create(post *model.LFGPost) error {
 func (r *LFGRepository) GetByGuildID(guildID string) ([]model.LFGPost, error) {
 	query := `SELECT id, guild_id, user_id, game_name, description, slots_total, slots_filled, expires_at, created_at
 		FROM lfg_posts WHERE guild_id = $1 AND expires_at > NOW() ORDER BY created_at DESC`
-	rows, err := r.db.Query(query, guildID)
+	return r.queryPosts(query, guildID)
+}
+
+// GetByUserID returns the posts userID created.
+func (r *LFGRepository) GetByUserID(userID string) ([]model.LFGPost, error) {
+	query := `SELECT id, guild_id, user_id, game_name, description, slots_total, slots_filled, expires_at, created_at
+		FROM lfg_posts WHERE user_id = $1`
+	return r.queryPosts(query, userID)
+}
+
+// GetJoinedByUserID returns the posts userID takes part in.
+func (r *LFGRepository) GetJoinedByUserID(userID string) ([]model.LFGPost, error) {
+	query := `SELECT p.id, p.guild_id, p.user_id, p.game_name, p.description, p.slots_total, p.slots_filled, p.expires_at, p.created_at
+		FROM lfg_posts p JOIN lfg_participants lp ON lp.lfg_id = p.id WHERE lp.user_id = $1`
+	return r.queryPosts(query, userID)
+}
+
+func (r *LFGRepository) queryPosts(query string, args ...interface{}) ([]model.LFGPost, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +121,7 @@ func (r *LFGRepository) Delete(id string) error {
 }
 
 func (r *LFGRepository) GetParticipants(lfgID string) ([]model.User, error) {
-	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.created_at
+	query := `SELECT u.id, u.username, u.email, u.avatar_url, u.bot, u.created_at
 		FROM lfg_participants lp JOIN users u ON lp.user_id = u.id WHERE lp.lfg_id = $1`
 	rows, err := r.db.Query(query, lfgID)
 	if err != nil {
@@ -114,7 +132,7 @@ func (r *LFGRepository) GetParticipants(lfgID string) ([]model.User, error) {
 	var users []model.User
 	for rows.Next() {
 		var u model.User
-		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.CreatedAt); err != nil {
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.Bot, &u.CreatedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
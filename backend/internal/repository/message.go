@@ -37,14 +37,14 @@ func (r *MessageRepository) GetByChannelID(channelID string, before *time.Time,
 
 	if before != nil {
 		query := `SELECT m.id, m.channel_id, m.content, m.attachment_url, m.created_at, m.updated_at,
-				u.id, u.username, u.email, u.avatar_url, u.created_at
+				u.id, u.username, u.email, u.avatar_url, u.bot, u.created_at
 			FROM messages m JOIN users u ON m.user_id = u.id
 			WHERE m.channel_id = $1 AND m.created_at < $2
 			ORDER BY m.created_at DESC LIMIT $3`
 		rows, err = r.db.Query(query, channelID, before, limit)
 	} else {
 		query := `SELECT m.id, m.channel_id, m.content, m.attachment_url, m.created_at, m.updated_at,
-				u.id, u.username, u.email, u.avatar_url, u.created_at
+				u.id, u.username, u.email, u.avatar_url, u.bot, u.created_at
 			FROM messages m JOIN users u ON m.user_id = u.id
 			WHERE m.channel_id = $1
 			ORDER BY m.created_at DESC LIMIT $2`
@@ -61,7 +61,7 @@ func (r *MessageRepository) GetByChannelID(channelID string, before *time.Time,
 		var u model.User
 		if err := rows.Scan(
 			&msg.ID, &msg.ChannelID, &msg.Content, &msg.AttachmentURL, &msg.CreatedAt, &msg.UpdatedAt,
-			&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.CreatedAt,
+			&u.ID, &u.Username, &u.Email, &u.AvatarURL, &u.Bot, &u.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
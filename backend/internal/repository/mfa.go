@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"pwdh-aether/internal/model"
+)
+
+// MFARepository keeps TOTP secrets sealed with AES-256-GCM under a key
+// derived from the server's TOTP encryption key, with the user ID as
+// additional data, so a database dump alone does not yield the secrets.
+type MFARepository struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+func NewMFARepository(db *sql.DB, encryptionKey string) *MFARepository {
+	key := sha256.Sum256([]byte(encryptionKey))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &MFARepository{db: db, aead: aead}
+}
+
+func (r *MFARepository) seal(userID, secret string) ([]byte, error) {
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return r.aead.Seal(nonce, nonce, []byte(secret), []byte(userID)), nil
+}
+
+func (r *MFARepository) open(userID string, sealed []byte) (string, error) {
+	if len(sealed) < r.aead.NonceSize() {
+		return "", errors.New("sealed totp secret too short")
+	}
+	nonce, ciphertext := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+	secret, err := r.aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// GetTOTP returns the user's TOTP secret, which is set from enrollment on
+// but only in effect once enabled is true. It is the only place the secret
+// is decrypted.
+func (r *MFARepository) GetTOTP(userID string) (*string, bool, error) {
+	var sealed []byte
+	var enabled bool
+	err := r.db.QueryRow(`SELECT totp_secret_encrypted, totp_enabled FROM users WHERE id = $1`, userID).Scan(&sealed, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, false, model.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("get totp: %w", err)
+	}
+	if sealed == nil {
+		return nil, enabled, nil
+	}
+	secret, err := r.open(userID, sealed)
+	if err != nil {
+		return nil, false, fmt.Errorf("decrypt totp secret: %w", err)
+	}
+	return &secret, enabled, nil
+}
+
+// SetPendingTOTP stores a freshly generated secret that still has to be
+// confirmed with a code.
+func (r *MFARepository) SetPendingTOTP(userID, secret string) error {
+	sealed, err := r.seal(userID, secret)
+	if err != nil {
+		return fmt.Errorf("encrypt totp secret: %w", err)
+	}
+	_, err = r.db.Exec(`UPDATE users SET totp_secret_encrypted = $2 WHERE id = $1 AND NOT totp_enabled`, userID, sealed)
+	return err
+}
+
+// EncryptPlaintextSecrets seals the secrets still stored in plain text from
+// before they were encrypted and clears them. It runs at startup and
+// returns how many it converted.
+func (r *MFARepository) EncryptPlaintextSecrets() (int, error) {
+	rows, err := r.db.Query(`SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`)
+	if err != nil {
+		return 0, fmt.Errorf("list plaintext totp secrets: %w", err)
+	}
+	type plaintext struct{ userID, secret string }
+	var pending []plaintext
+	for rows.Next() {
+		var p plaintext
+		if err := rows.Scan(&p.userID, &p.secret); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan plaintext totp secret: %w", err)
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("list plaintext totp secrets: %w", err)
+	}
+
+	for i, p := range pending {
+		sealed, err := r.seal(p.userID, p.secret)
+		if err != nil {
+			return i, fmt.Errorf("encrypt totp secret: %w", err)
+		}
+		if _, err := r.db.Exec(`UPDATE users SET totp_secret_encrypted = $2, totp_secret = NULL
+			WHERE id = $1 AND totp_secret = $3`, p.userID, sealed, p.secret); err != nil {
+			return i, fmt.Errorf("store encrypted totp secret: %w", err)
+		}
+	}
+	return len(pending), nil
+}
+
+// EnableTOTP turns the pending secret on and replaces the recovery codes.
+func (r *MFARepository) EnableTOTP(userID string, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = TRUE WHERE id = $1`, userID); err != nil {
+		return fmt.Errorf("enable totp: %w", err)
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *MFARepository) DisableTOTP(userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = FALSE, totp_secret_encrypted = NULL WHERE id = $1`, userID); err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (r *MFARepository) ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return fmt.Errorf("insert recovery code: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether
+// there was one.
+func (r *MFARepository) UseRecoveryCode(userID, codeHash string) (bool, error) {
+	res, err := r.db.Exec(`UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("use recovery code: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *MFARepository) CountRecoveryCodes(userID string) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
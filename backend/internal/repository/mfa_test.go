@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// sealedArg is a sqlmock argument that accepts any []byte and keeps it.
+type sealedArg struct{ value []byte }
+
+func (a *sealedArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	a.value = b
+	return ok
+}
+
+func TestTOTPSecretIsEncryptedAtRest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+	repo := NewMFARepository(db, "test-key")
+
+	var sealed sealedArg
+	mock.ExpectExec(`UPDATE users SET totp_secret_encrypted = \$2 WHERE id = \$1 AND NOT totp_enabled`).
+		WithArgs(id, &sealed).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repo.SetPendingTOTP(id, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed.value) == 0 || bytes.Contains(sealed.value, []byte(testTOTPSecret)) {
+		t.Fatalf("stored %q", sealed.value)
+	}
+
+	mock.ExpectQuery(`SELECT totp_secret_encrypted, totp_enabled FROM users WHERE id = \$1`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret_encrypted", "totp_enabled"}).AddRow(sealed.value, true))
+	secret, enabled, err := repo.GetTOTP(id)
+	if err != nil || secret == nil || *secret != testTOTPSecret || !enabled {
+		t.Fatalf("GetTOTP: %v %v %v", secret, enabled, err)
+	}
+
+	// The ciphertext is bound to the key and to the user it was sealed for.
+	for name, tc := range map[string]struct {
+		repo *MFARepository
+		id   string
+	}{
+		"other key":  {NewMFARepository(db, "other-key"), id},
+		"other user": {repo, "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"},
+	} {
+		mock.ExpectQuery(`SELECT totp_secret_encrypted`).WithArgs(tc.id).
+			WillReturnRows(sqlmock.NewRows([]string{"totp_secret_encrypted", "totp_enabled"}).AddRow(sealed.value, true))
+		if secret, _, err := tc.repo.GetTOTP(tc.id); err == nil {
+			t.Fatalf("%s: decrypted %q", name, *secret)
+		}
+	}
+
+	mock.ExpectQuery(`SELECT totp_secret_encrypted`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret_encrypted", "totp_enabled"}).AddRow(nil, false))
+	if secret, enabled, err := repo.GetTOTP(id); err != nil || secret != nil || enabled {
+		t.Fatalf("GetTOTP without secret: %v %v %v", secret, enabled, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptPlaintextSecrets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+	repo := NewMFARepository(db, "test-key")
+
+	var sealed sealedArg
+	mock.ExpectQuery(`SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).AddRow(id, testTOTPSecret))
+	mock.ExpectExec(`UPDATE users SET totp_secret_encrypted = \$2, totp_secret = NULL`).
+		WithArgs(id, &sealed, testTOTPSecret).WillReturnResult(sqlmock.NewResult(0, 1))
+	if n, err := repo.EncryptPlaintextSecrets(); err != nil || n != 1 {
+		t.Fatalf("EncryptPlaintextSecrets: %d, %v", n, err)
+	}
+	if secret, err := repo.open(id, sealed.value); err != nil || secret != testTOTPSecret {
+		t.Fatalf("sealed secret opens to %q, %v", secret, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
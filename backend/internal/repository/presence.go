@@ -15,30 +15,36 @@ func NewPresenceRepository(db *sql.DB) *PresenceRepository {
 }
 
 func (r *PresenceRepository) Upsert(presence *model.UserPresence) error {
-	query := `INSERT INTO user_presence (user_id, status, game_name, game_started_at, custom_status, updated_at)
-		VALUES ($1, $2, $3, $4, $5, NOW())
+	query := `INSERT INTO user_presence (user_id, status, manual_status, game_name, game_started_at, custom_status, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
 		ON CONFLICT (user_id) DO UPDATE SET
 			status = EXCLUDED.status,
+			manual_status = EXCLUDED.manual_status,
 			game_name = EXCLUDED.game_name,
 			game_started_at = EXCLUDED.game_started_at,
 			custom_status = EXCLUDED.custom_status,
 			updated_at = NOW()`
-	_, err := r.db.Exec(query, presence.UserID, presence.Status, presence.GameName, presence.GameStartedAt, presence.CustomStatus)
+	_, err := r.db.Exec(query, presence.UserID, presence.Status, presence.ManualStatus, presence.GameName, presence.GameStartedAt, presence.CustomStatus)
 	return err
 }
 
 func (r *PresenceRepository) GetByUserID(userID string) (*model.UserPresence, error) {
 	p := &model.UserPresence{}
-	query := `SELECT user_id, status, game_name, game_started_at, custom_status, updated_at FROM user_presence WHERE user_id = $1`
-	err := r.db.QueryRow(query, userID).Scan(&p.UserID, &p.Status, &p.GameName, &p.GameStartedAt, &p.CustomStatus, &p.UpdatedAt)
+	query := `SELECT user_id, status, manual_status, game_name, game_started_at, custom_status, updated_at FROM user_presence WHERE user_id = $1`
+	err := r.db.QueryRow(query, userID).Scan(&p.UserID, &p.Status, &p.ManualStatus, &p.GameName, &p.GameStartedAt, &p.CustomStatus, &p.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return &model.UserPresence{UserID: userID, Status: model.StatusOffline}, nil
 	}
 	return p, err
 }
 
+// GetByGuildID lists the presences of a guild's members as others see them:
+// without the manual status, and without the game of users shown OFFLINE.
 func (r *PresenceRepository) GetByGuildID(guildID string) ([]model.UserPresence, error) {
-	query := `SELECT up.user_id, up.status, up.game_name, up.game_started_at, up.custom_status, up.updated_at
+	query := `SELECT up.user_id, up.status,
+			CASE WHEN up.status = 'OFFLINE' THEN NULL ELSE up.game_name END,
+			CASE WHEN up.status = 'OFFLINE' THEN NULL ELSE up.game_started_at END,
+			up.custom_status, up.updated_at
 		FROM user_presence up JOIN members m ON up.user_id = m.user_id WHERE m.guild_id = $1`
 	rows, err := r.db.Query(query, guildID)
 	if err != nil {
@@ -57,8 +63,36 @@ func (r *PresenceRepository) GetByGuildID(guildID string) ([]model.UserPresence,
 	return presences, rows.Err()
 }
 
+// GetOnlineUserIDs lists the users whose stored status is not OFFLINE.
+func (r *PresenceRepository) GetOnlineUserIDs() ([]string, error) {
+	rows, err := r.db.Query(`SELECT user_id FROM user_presence WHERE status <> 'OFFLINE'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
 func (r *PresenceRepository) SetOffline(userID string) error {
 	query := `UPDATE user_presence SET status = 'OFFLINE', game_name = NULL, game_started_at = NULL, updated_at = NOW() WHERE user_id = $1`
 	_, err := r.db.Exec(query, userID)
 	return err
 }
+
+// Delete removes the presence of a user together with its history.
+func (r *PresenceRepository) Delete(userID string) error {
+	if _, err := r.db.Exec(`DELETE FROM user_presence WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`DELETE FROM presence_history WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeletePresenceRemovesHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+
+	mock.ExpectExec(`DELETE FROM user_presence WHERE user_id = \$1`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM presence_history WHERE user_id = \$1`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 5))
+
+	if err := NewPresenceRepository(db).Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pwdh-aether/internal/model"
+)
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+const sessionColumns = `id, user_id, refresh_token_hash, previous_token_hash, COALESCE(user_agent, ''),
+	COALESCE(ip, ''), created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*model.Session, error) {
+	s := &model.Session{}
+	err := row.Scan(&s.ID, &s.UserID, &s.RefreshTokenHash, &s.PreviousTokenHash, &s.UserAgent,
+		&s.IP, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt, &s.RevokedAt)
+	return s, err
+}
+
+func (r *SessionRepository) Create(s *model.Session) error {
+	query := `INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.db.Exec(query, s.ID, s.UserID, s.RefreshTokenHash, s.UserAgent, s.IP, s.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create session: %w", err)
+	}
+	return nil
+}
+
+func (r *SessionRepository) GetByID(id string) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = $1`
+	s, err := scanSession(r.db.QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session: %w", err)
+	}
+	return s, nil
+}
+
+// GetByTokenHash finds the session whose current refresh token hashes to
+// hash.
+func (r *SessionRepository) GetByTokenHash(hash string) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE refresh_token_hash = $1`
+	s, err := scanSession(r.db.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session by token: %w", err)
+	}
+	return s, nil
+}
+
+// GetByPreviousTokenHash finds the session a rotated-out refresh token
+// belonged to, which is how reuse of a stolen token is detected.
+func (r *SessionRepository) GetByPreviousTokenHash(hash string) (*model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE previous_token_hash = $1`
+	s, err := scanSession(r.db.QueryRow(query, hash))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get session by previous token: %w", err)
+	}
+	return s, nil
+}
+
+// Rotate replaces the refresh token of an active session. It fails with
+// ErrSessionNotFound if the session was rotated or revoked concurrently.
+func (r *SessionRepository) Rotate(id, oldHash, newHash string, info model.ClientInfo, expiresAt time.Time) error {
+	query := `UPDATE sessions
+		SET refresh_token_hash = $3, previous_token_hash = $2, user_agent = $4, ip = $5,
+			last_used_at = NOW(), expires_at = $6
+		WHERE id = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL`
+	res, err := r.db.Exec(query, id, oldHash, newHash, info.UserAgent, info.IP, expiresAt)
+	if err != nil {
+		return fmt.Errorf("rotate session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrSessionNotFound
+	}
+	return nil
+}
+
+// GetActiveByUserID lists the sessions that can still be refreshed.
+func (r *SessionRepository) GetActiveByUserID(userID string) ([]model.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+func (r *SessionRepository) Revoke(id string) error {
+	_, err := r.db.Exec(`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	return err
+}
+
+// RevokeAllExcept revokes every active session of userID but keepID and
+// returns the IDs it revoked. keepID may be empty.
+func (r *SessionRepository) RevokeAllExcept(userID, keepID string) ([]string, error) {
+	query := `UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL AND id::text <> $2
+		RETURNING id`
+	rows, err := r.db.Query(query, userID, keepID)
+	if err != nil {
+		return nil, fmt.Errorf("revoke sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"pwdh-aether/internal/model"
 )
@@ -27,9 +28,11 @@ func (r *UserRepository) Create(user *model.User) error {
 
 func (r *UserRepository) GetByID(id string) (*model.User, error) {
 	user := &model.User{}
-	query := `SELECT id, username, email, password_hash, avatar_url, created_at FROM users WHERE id = $1`
+	query := `SELECT id, username, email, password_hash, avatar_url, totp_enabled, email_verified_at,
+		deletion_scheduled_at, bot, created_at FROM users WHERE id = $1`
 	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.TOTPEnabled,
+		&user.EmailVerifiedAt, &user.DeletionAt, &user.Bot, &user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrUserNotFound
@@ -42,9 +45,11 @@ func (r *UserRepository) GetByID(id string) (*model.User, error) {
 
 func (r *UserRepository) GetByEmail(email string) (*model.User, error) {
 	user := &model.User{}
-	query := `SELECT id, username, email, password_hash, avatar_url, created_at FROM users WHERE email = $1`
+	query := `SELECT id, username, email, password_hash, avatar_url, totp_enabled, email_verified_at,
+		deletion_scheduled_at, bot, created_at FROM users WHERE email = $1`
 	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.TOTPEnabled,
+		&user.EmailVerifiedAt, &user.DeletionAt, &user.Bot, &user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrUserNotFound
@@ -57,9 +62,11 @@ func (r *UserRepository) GetByEmail(email string) (*model.User, error) {
 
 func (r *UserRepository) GetByUsername(username string) (*model.User, error) {
 	user := &model.User{}
-	query := `SELECT id, username, email, password_hash, avatar_url, created_at FROM users WHERE username = $1`
+	query := `SELECT id, username, email, password_hash, avatar_url, totp_enabled, email_verified_at,
+		deletion_scheduled_at, bot, created_at FROM users WHERE username = $1`
 	err := r.db.QueryRow(query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.CreatedAt,
+		&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.AvatarURL, &user.TOTPEnabled,
+		&user.EmailVerifiedAt, &user.DeletionAt, &user.Bot, &user.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrUserNotFound
@@ -79,6 +86,114 @@ func (r *UserRepository) Update(user *model.User) error {
 	return nil
 }
 
+// MarkEmailVerified confirms email for the user, as long as it is still the
+// address on the account.
+func (r *UserRepository) MarkEmailVerified(id, email string) (bool, error) {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1 AND email = $2`
+	res, err := r.db.Exec(query, id, email)
+	if err != nil {
+		return false, fmt.Errorf("verify email: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *UserRepository) UpdatePassword(id, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2 WHERE id = $1`
+	_, err := r.db.Exec(query, id, passwordHash)
+	if err != nil {
+		return fmt.Errorf("update password: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) ScheduleDeletion(id string, at time.Time, guildPolicy string) error {
+	query := `UPDATE users SET deletion_scheduled_at = $2, deletion_guild_policy = $3 WHERE id = $1 AND deleted_at IS NULL`
+	_, err := r.db.Exec(query, id, at, guildPolicy)
+	if err != nil {
+		return fmt.Errorf("schedule deletion: %w", err)
+	}
+	return nil
+}
+
+// CancelDeletion reports whether a pending deletion was cancelled.
+func (r *UserRepository) CancelDeletion(id string) (bool, error) {
+	query := `UPDATE users SET deletion_scheduled_at = NULL, deletion_guild_policy = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL`
+	res, err := r.db.Exec(query, id)
+	if err != nil {
+		return false, fmt.Errorf("cancel deletion: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetDueDeletions returns accounts whose grace period is over.
+func (r *UserRepository) GetDueDeletions(limit int) ([]model.AccountDeletion, error) {
+	query := `SELECT id, COALESCE(deletion_guild_policy, 'transfer'), deletion_scheduled_at FROM users
+		WHERE deletion_scheduled_at <= NOW() AND deleted_at IS NULL
+		ORDER BY deletion_scheduled_at LIMIT $1`
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get due deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var due []model.AccountDeletion
+	for rows.Next() {
+		var d model.AccountDeletion
+		if err := rows.Scan(&d.UserID, &d.GuildPolicy, &d.ScheduledAt); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// Anonymize turns the account into a tombstone. The row stays so messages
+// keep their author reference, but nothing on it identifies the person and
+// it can no longer log in. The content and attachments of their channel and
+// direct messages are cleared in the same transaction.
+func (r *UserRepository) Anonymize(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET
+			username = 'deleted-' || LEFT(REPLACE(id::text, '-', ''), 12),
+			email = id::text || '@deleted.invalid',
+			password_hash = '',
+			avatar_url = NULL,
+			totp_secret = NULL,
+			totp_secret_encrypted = NULL,
+			totp_enabled = FALSE,
+			email_verified_at = NULL,
+			deleted_at = NOW()
+		WHERE id = $1`
+	if _, err := tx.Exec(query, id); err != nil {
+		return fmt.Errorf("anonymize user: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE messages SET content = '', attachment_url = NULL WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("anonymize messages: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE direct_messages SET content = '', attachment_url = NULL WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("anonymize direct messages: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_identities WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("delete identities: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM bots WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("delete bot token: %w", err)
+	}
+	return tx.Commit()
+}
+
 func (r *UserRepository) EmailExists(email string) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAnonymizeClearsMessagesInTheSameTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE messages SET content = '', attachment_url = NULL WHERE user_id = \$1`).
+		WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE direct_messages SET content = '', attachment_url = NULL WHERE user_id = \$1`).
+		WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM recovery_codes`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM user_identities`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM bots`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := NewUserRepository(db).Anonymize(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnonymizeRollsBackWhenMessagesFail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const id = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET`).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE messages SET`).WithArgs(id).WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+
+	if err := NewUserRepository(db).Anonymize(id); err == nil {
+		t.Fatal("anonymized although the messages could not be cleared")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	deletionSweepInterval = 10 * time.Minute
+	deletionBatchSize     = 50
+	// deletionLockTTL keeps two nodes from purging the same account at once.
+	deletionLockTTL = 10 * time.Minute
+)
+
+// AccountService handles the deletion of accounts. Deletion is scheduled
+// first; the account is only anonymized once the grace period has passed
+// without the user restoring it.
+type AccountService struct {
+	users    *repository.UserRepository
+	guilds   *repository.GuildRepository
+	lfg      *repository.LFGRepository
+	convs    *repository.ConversationRepository
+	presence *repository.PresenceRepository
+	bots     *repository.BotRepository
+	auth     *AuthService
+	exports  *ExportService
+	rdb      *redis.Client
+	hub      *ws.Hub
+	cfg      *config.Config
+}
+
+func NewAccountService(
+	users *repository.UserRepository,
+	guilds *repository.GuildRepository,
+	lfg *repository.LFGRepository,
+	convs *repository.ConversationRepository,
+	presence *repository.PresenceRepository,
+	bots *repository.BotRepository,
+	auth *AuthService,
+	exports *ExportService,
+	rdb *redis.Client,
+	hub *ws.Hub,
+	cfg *config.Config,
+) *AccountService {
+	return &AccountService{
+		users: users, guilds: guilds, lfg: lfg, convs: convs, presence: presence,
+		bots: bots, auth: auth, exports: exports, rdb: rdb, hub: hub, cfg: cfg,
+	}
+}
+
+// ScheduleDeletion confirms the user's credentials and marks the account for
+// deletion after the grace period.
+func (s *AccountService) ScheduleDeletion(userID string, req model.DeleteAccountRequest) (*model.UserResponse, error) {
+	policy := req.GuildPolicy
+	if policy == "" {
+		policy = model.GuildPolicyTransfer
+	}
+	if policy != model.GuildPolicyTransfer && policy != model.GuildPolicyDelete {
+		return nil, model.ErrInvalidGuildPolicy
+	}
+	if err := s.auth.Reauthenticate(userID, req.Password, req.Code, req.ReauthToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.ScheduleDeletion(userID, time.Now().Add(s.cfg.AccountDeletionGrace), policy); err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := user.ToResponse()
+	return &resp, nil
+}
+
+func (s *AccountService) CancelDeletion(userID string) error {
+	ok, err := s.users.CancelDeletion(userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return model.ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+// RunDeletions purges accounts whose grace period is over until ctx is done.
+func (s *AccountService) RunDeletions(ctx context.Context) {
+	ticker := time.NewTicker(deletionSweepInterval)
+	defer ticker.Stop()
+	for {
+		s.purgeDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AccountService) purgeDue(ctx context.Context) {
+	due, err := s.users.GetDueDeletions(deletionBatchSize)
+	if err != nil {
+		log.Printf("account deletion: %v", err)
+		return
+	}
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		locked, err := s.rdb.SetNX(ctx, "account:deleting:"+d.UserID, 1, deletionLockTTL).Result()
+		if err != nil || !locked {
+			continue
+		}
+		if err := s.purge(d); err != nil {
+			log.Printf("account deletion: user=%s: %v", d.UserID, err)
+		}
+	}
+}
+
+// purge removes the user from everything they take part in, deletes their
+// data exports and anonymizes the account, along with the bots they own. Every step can be repeated, so
+// a purge that fails halfway is simply retried on the next sweep.
+func (s *AccountService) purge(d model.AccountDeletion) error {
+	if err := s.auth.RevokeOtherSessions(d.UserID, ""); err != nil {
+		return err
+	}
+
+	bots, err := s.bots.GetByOwnerID(d.UserID)
+	if err != nil {
+		return err
+	}
+	for i := range bots {
+		s.auth.revokeBotToken(&bots[i])
+		if err := s.purge(model.AccountDeletion{UserID: bots[i].UserID, GuildPolicy: d.GuildPolicy}); err != nil {
+			return err
+		}
+	}
+
+	owned, err := s.guilds.GetOwnedByUserID(d.UserID)
+	if err != nil {
+		return err
+	}
+	for _, g := range owned {
+		if err := s.handOverGuild(g.ID, d.UserID, d.GuildPolicy); err != nil {
+			return err
+		}
+	}
+
+	guilds, err := s.guilds.GetByUserID(d.UserID)
+	if err != nil {
+		return err
+	}
+	for _, g := range guilds {
+		if err := s.guilds.RemoveMember(g.ID, d.UserID); err != nil {
+			return err
+		}
+		s.hub.RevokeGuild(g.ID, d.UserID)
+		s.hub.BroadcastToGuild(g.ID, ws.Event{
+			Type: ws.EventMemberLeave,
+			Data: ws.MemberLeaveData{GuildID: g.ID, UserID: d.UserID, Reason: ws.LeaveReasonDeleted},
+		})
+	}
+
+	if err := s.cleanupLFG(d.UserID); err != nil {
+		return err
+	}
+
+	convs, err := s.convs.GetByUserID(d.UserID)
+	if err != nil {
+		return err
+	}
+	for _, c := range convs {
+		if err := s.convs.RemoveMember(c.ID, d.UserID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.presence.Delete(d.UserID); err != nil {
+		return err
+	}
+	if err := s.exports.DeleteAll(context.Background(), d.UserID); err != nil {
+		return err
+	}
+	if err := s.users.Anonymize(d.UserID); err != nil {
+		return err
+	}
+	log.Printf("account deleted: user=%s", d.UserID)
+	return nil
+}
+
+// handOverGuild applies the user's guild policy to a guild they own. A guild
+// nobody else is in is deleted either way.
+func (s *AccountService) handOverGuild(guildID, ownerID, policy string) error {
+	if policy == model.GuildPolicyTransfer {
+		successor, err := s.guilds.GetSuccessor(guildID, ownerID)
+		if err == nil {
+			if err := s.guilds.TransferOwnership(guildID, successor.UserID); err != nil {
+				return err
+			}
+			s.hub.BroadcastToGuild(guildID, ws.Event{
+				Type: ws.EventMemberUpdate,
+				Data: ws.MemberUpdateData{GuildID: guildID, UserID: successor.UserID, Role: model.RoleOwner},
+			})
+			return nil
+		}
+		if !errors.Is(err, model.ErrNotMember) {
+			return err
+		}
+	}
+
+	members, err := s.guilds.GetMembers(guildID)
+	if err != nil {
+		return err
+	}
+	if err := s.guilds.Delete(guildID); err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.User.ID == ownerID {
+			continue
+		}
+		s.hub.RevokeGuild(guildID, m.User.ID)
+		s.hub.BroadcastToUser(m.User.ID, ws.Event{
+			Type: ws.EventGuildRemove,
+			Data: map[string]string{"guild_id": guildID, "reason": ws.LeaveReasonDeleted},
+		})
+	}
+	return nil
+}
+
+func (s *AccountService) cleanupLFG(userID string) error {
+	posts, err := s.lfg.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		if err := s.lfg.Delete(p.ID); err != nil {
+			return err
+		}
+		s.hub.BroadcastToGuild(p.GuildID, ws.Event{Type: ws.EventLFGDelete, Data: map[string]string{"id": p.ID}})
+	}
+
+	joined, err := s.lfg.GetJoinedByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, p := range joined {
+		if err := s.lfg.Leave(p.ID, userID); err != nil {
+			return err
+		}
+		if post, err := s.lfg.GetByID(p.ID); err == nil {
+			s.hub.BroadcastToGuild(post.GuildID, ws.Event{Type: ws.EventLFGUpdate, Data: post})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const successorID = "8d7c6b5a-4f3e-4d2c-9b1a-0f9e8d7c6b5a"
+
+// stubS3 records the objects deleted from it and fails while broken is set.
+type stubS3 struct {
+	mu      sync.Mutex
+	deleted []string
+	broken  bool
+}
+
+func (s *stubS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method != http.MethodDelete || s.broken {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.deleted = append(s.deleted, r.URL.Path)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestAccountService(t *testing.T) (*AccountService, sqlmock.Sqlmock, *stubS3) {
+	t.Helper()
+	auth, db, mock, rdb := newTestAuthServiceDB(t)
+	s3 := &stubS3{}
+	srv := httptest.NewServer(s3)
+	t.Cleanup(srv.Close)
+	client, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:      credentials.NewStaticV4("key", "secret", ""),
+		Region:     "us-east-1",
+		MaxRetries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{MinioBucket: "aether"}
+	users := repository.NewUserRepository(db)
+	lfg := repository.NewLFGRepository(db)
+	presence := repository.NewPresenceRepository(db)
+	hub := newTestHub(db)
+	exports := NewExportService(repository.NewExportRepository(db), users, lfg, presence,
+		repository.NewSessionRepository(db), repository.NewIdentityRepository(db), client, hub, cfg)
+	accounts := NewAccountService(users, repository.NewGuildRepository(db), lfg,
+		repository.NewConversationRepository(db), presence, repository.NewBotRepository(db),
+		auth, exports, rdb, hub, cfg)
+	return accounts, mock, s3
+}
+
+// expectLeaveEverything expects the steps of a purge before the exports, for
+// a user without sessions, bots, guilds, LFG posts or conversations.
+func expectLeaveEverything(mock sqlmock.Sqlmock) {
+	none := sqlmock.NewRows([]string{"id"})
+	mock.ExpectQuery(`UPDATE sessions SET revoked_at = NOW\(\)`).WithArgs(testUserID, "").WillReturnRows(none)
+	mock.ExpectQuery(`FROM bots`).WithArgs(testUserID).WillReturnRows(none)
+	mock.ExpectQuery(`FROM guilds WHERE owner_id = \$1`).WithArgs(testUserID).WillReturnRows(none)
+	expectLeaveRest(mock)
+}
+
+// expectLeaveRest expects the steps of a purge after the owned guilds.
+func expectLeaveRest(mock sqlmock.Sqlmock) {
+	none := sqlmock.NewRows([]string{"id"})
+	mock.ExpectQuery(`FROM guilds g`).WithArgs(testUserID).WillReturnRows(none)
+	mock.ExpectQuery(`FROM lfg_posts`).WithArgs(testUserID).WillReturnRows(none)
+	mock.ExpectQuery(`FROM lfg_posts p`).WithArgs(testUserID).WillReturnRows(none)
+	mock.ExpectQuery(`FROM conversations c`).WithArgs(testUserID).WillReturnRows(none)
+	mock.ExpectExec(`DELETE FROM user_presence`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM presence_history`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func exportRows() *sqlmock.Rows {
+	key := "exports/" + testUserID + "/1.zip"
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "user_id", "status", "object_key", "size_bytes", "created_at", "completed_at", "expires_at"}).
+		AddRow("1", testUserID, model.ExportReady, key, 10, now, now, now.Add(time.Hour)).
+		AddRow("2", testUserID, model.ExportFailed, nil, nil, now, now, nil)
+}
+
+func expectAnonymize(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE messages SET`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE direct_messages SET`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM recovery_codes`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM user_identities`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM bots`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+}
+
+func TestPurgeDeletesExportsBeforeAnonymizing(t *testing.T) {
+	accounts, mock, s3 := newTestAccountService(t)
+
+	expectLeaveEverything(mock)
+	mock.ExpectQuery(`FROM data_exports WHERE user_id = \$1`).WithArgs(testUserID).WillReturnRows(exportRows())
+	mock.ExpectExec(`DELETE FROM data_exports WHERE user_id = \$1`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 2))
+	expectAnonymize(mock)
+
+	if err := accounts.purge(model.AccountDeletion{UserID: testUserID, GuildPolicy: model.GuildPolicyTransfer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	want := "/aether/exports/" + testUserID + "/1.zip"
+	if len(s3.deleted) != 1 || s3.deleted[0] != want {
+		t.Fatalf("deleted %v, want %s", s3.deleted, want)
+	}
+}
+
+func TestPurgeKeepsAccountWhenArchiveRemovalFails(t *testing.T) {
+	accounts, mock, s3 := newTestAccountService(t)
+	s3.broken = true
+
+	expectLeaveEverything(mock)
+	mock.ExpectQuery(`FROM data_exports WHERE user_id = \$1`).WithArgs(testUserID).WillReturnRows(exportRows())
+
+	err := accounts.purge(model.AccountDeletion{UserID: testUserID, GuildPolicy: model.GuildPolicyTransfer})
+	if err == nil || !strings.Contains(err.Error(), "remove export 1") {
+		t.Fatalf("got %v, want the archive removal to fail the purge", err)
+	}
+	// Neither the rows nor the account were touched, so the next sweep
+	// starts over.
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeTransfersOwnedGuild(t *testing.T) {
+	accounts, mock, _ := newTestAccountService(t)
+	mock.ExpectQuery(`UPDATE sessions SET revoked_at = NOW\(\)`).WithArgs(testUserID, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM bots`).WithArgs(testUserID).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM guilds WHERE owner_id = \$1`).WithArgs(testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "icon_url", "owner_id", "invite_code", "require_mfa", "created_at"}).
+			AddRow(testGuildID, "guild", nil, testUserID, "invite", false, time.Now()))
+	mock.ExpectQuery(`FROM members\s+WHERE guild_id = \$1 AND user_id <> \$2`).WithArgs(testGuildID, testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "guild_id", "role", "joined_at"}).
+			AddRow(successorID, testGuildID, model.RoleAdmin, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE guilds SET owner_id = \$2`).WithArgs(testGuildID, successorID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE members SET role = 'OWNER'`).WithArgs(testGuildID, successorID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectLeaveRest(mock)
+	mock.ExpectQuery(`FROM data_exports WHERE user_id = \$1`).WithArgs(testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status", "object_key", "size_bytes", "created_at", "completed_at", "expires_at"}))
+	mock.ExpectExec(`DELETE FROM data_exports WHERE user_id = \$1`).WithArgs(testUserID).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAnonymize(mock)
+
+	if err := accounts.purge(model.AccountDeletion{UserID: testUserID, GuildPolicy: model.GuildPolicyTransfer}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduleDeletionRejectsUnknownPolicy(t *testing.T) {
+	accounts, mock, _ := newTestAccountService(t)
+	_, err := accounts.ScheduleDeletion(testUserID, model.DeleteAccountRequest{Password: "x", GuildPolicy: "KEEP"})
+	if !errors.Is(err, model.ErrInvalidGuildPolicy) {
+		t.Fatalf("got %v, want ErrInvalidGuildPolicy", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,28 +1,51 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/mail"
 	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
 
 	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 )
 
 type AuthService struct {
-	users *repository.UserRepository
-	cfg   *config.Config
+	users    *repository.UserRepository
+	sessions *repository.SessionRepository
+	mfa      *repository.MFARepository
+	rdb      *redis.Client
+	hub      *ws.Hub
+	mailer   mail.Mailer
+	cfg      *config.Config
 }
 
-func NewAuthService(users *repository.UserRepository, cfg *config.Config) *AuthService {
-	return &AuthService{users: users, cfg: cfg}
+func NewAuthService(
+	users *repository.UserRepository,
+	sessions *repository.SessionRepository,
+	mfa *repository.MFARepository,
+	rdb *redis.Client,
+	hub *ws.Hub,
+	mailer mail.Mailer,
+	cfg *config.Config,
+) *AuthService {
+	return &AuthService{users: users, sessions: sessions, mfa: mfa, rdb: rdb, hub: hub, mailer: mailer, cfg: cfg}
 }
 
-func (s *AuthService) Register(req model.RegisterRequest) (*model.TokenResponse, error) {
+func (s *AuthService) Register(req model.RegisterRequest, info model.ClientInfo) (*model.TokenResponse, error) {
 	if taken, _ := s.users.EmailExists(req.Email); taken {
 		return nil, model.ErrEmailTaken
 	}
@@ -44,42 +67,263 @@ func (s *AuthService) Register(req model.RegisterRequest) (*model.TokenResponse,
 	if err := s.users.Create(user); err != nil {
 		return nil, fmt.Errorf("create user: %w", err)
 	}
-
-	token, err := s.generateToken(user.ID)
-	if err != nil {
-		return nil, err
+	if err := s.sendVerification(user); err != nil {
+		log.Printf("verification mail: user=%s: %v", user.ID, err)
 	}
 
-	userResp := user.ToResponse()
-	return &model.TokenResponse{AccessToken: token, User: userResp}, nil
+	return s.startSession(user, info)
 }
 
-func (s *AuthService) Login(req model.LoginRequest) (*model.TokenResponse, error) {
+// Login checks the password. Accounts with two-factor authentication get an
+// MFA challenge instead of tokens, to be completed with VerifyMFA.
+func (s *AuthService) Login(req model.LoginRequest, info model.ClientInfo) (*model.TokenResponse, *model.MFAChallenge, error) {
 	user, err := s.users.GetByEmail(req.Email)
 	if err != nil {
-		return nil, model.ErrInvalidCredentials
+		return nil, nil, model.ErrInvalidCredentials
 	}
 
 	match, err := argon2id.ComparePasswordAndHash(req.Password, user.PasswordHash)
 	if err != nil || !match {
-		return nil, model.ErrInvalidCredentials
+		return nil, nil, model.ErrInvalidCredentials
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := s.mfaChallenge(user.ID)
+		return nil, challenge, err
+	}
+
+	resp, err := s.startSession(user, info)
+	return resp, nil, err
+}
+
+// Reauthenticate confirms the user behind a sensitive change with their
+// password and, when two-factor authentication is on, a TOTP or recovery
+// code. Accounts registered through an OIDC provider have no password;
+// they confirm with the reauth token of a fresh provider login instead.
+func (s *AuthService) Reauthenticate(userID, password, code, reauthToken string) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" {
+		match, err := argon2id.ComparePasswordAndHash(password, user.PasswordHash)
+		if err != nil || !match {
+			return model.ErrInvalidPassword
+		}
+	} else if err := s.redeemReauthToken(userID, reauthToken); err != nil {
+		return err
 	}
+	if !user.TOTPEnabled {
+		return nil
+	}
+	if code == "" {
+		return model.ErrMFARequired
+	}
+	return s.checkSecondFactor(userID, code, true)
+}
 
-	token, err := s.generateToken(user.ID)
+// redeemReauthToken consumes a token issued by OIDCService.Reauth for userID.
+func (s *AuthService) redeemReauthToken(userID, token string) error {
+	if token == "" {
+		return model.ErrReauthRequired
+	}
+	owner, err := s.rdb.GetDel(context.Background(), reauthTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) || err == nil && owner != userID {
+		return model.ErrReauthRequired
+	}
+	return err
+}
+
+// ChangePassword sets a new password and signs out every session but the
+// current one.
+func (s *AuthService) ChangePassword(userID, sessionID string, req model.ChangePasswordRequest) error {
+	if err := s.Reauthenticate(userID, req.CurrentPassword, req.Code, req.ReauthToken); err != nil {
+		return err
+	}
+	hash, err := argon2id.CreateHash(req.NewPassword, argon2id.DefaultParams)
 	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	if err := s.users.UpdatePassword(userID, hash); err != nil {
+		return err
+	}
+	return s.RevokeOtherSessions(userID, sessionID)
+}
+
+// startSession creates a session for a fresh login and issues its first
+// token pair.
+func (s *AuthService) startSession(user *model.User, info model.ClientInfo) (*model.TokenResponse, error) {
+	refresh, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	session := &model.Session{
+		ID:               uuid.New().String(),
+		UserID:           user.ID,
+		RefreshTokenHash: hash,
+		UserAgent:        info.UserAgent,
+		IP:               info.IP,
+		ExpiresAt:        time.Now().Add(s.cfg.RefreshTokenExpiry),
+	}
+	if err := s.sessions.Create(session); err != nil {
 		return nil, err
 	}
+	return s.tokenResponse(user, session.ID, refresh)
+}
 
-	userResp := user.ToResponse()
-	return &model.TokenResponse{AccessToken: token, User: userResp}, nil
+// Refresh rotates the refresh token and issues a new access token. Presenting
+// a refresh token that was already rotated out means it was copied, so the
+// whole session is revoked.
+func (s *AuthService) Refresh(refreshToken string, info model.ClientInfo) (*model.TokenResponse, error) {
+	hash := hashToken(refreshToken)
+	session, err := s.sessions.GetByTokenHash(hash)
+	if errors.Is(err, model.ErrSessionNotFound) {
+		if reused, err := s.sessions.GetByPreviousTokenHash(hash); err == nil && reused.RevokedAt == nil {
+			log.Printf("refresh token reuse: user=%s session=%s", reused.UserID, reused.ID)
+			s.revoke(reused.ID)
+		}
+		return nil, model.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, model.ErrInvalidRefreshToken
+	}
+
+	refresh, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessions.Rotate(session.ID, hash, newHash, info, time.Now().Add(s.cfg.RefreshTokenExpiry)); err != nil {
+		if errors.Is(err, model.ErrSessionNotFound) {
+			return nil, model.ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.tokenResponse(user, session.ID, refresh)
 }
 
-func (s *AuthService) generateToken(userID string) (string, error) {
+// Logout ends the session the refresh token belongs to.
+func (s *AuthService) Logout(refreshToken string) error {
+	session, err := s.sessions.GetByTokenHash(hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, model.ErrSessionNotFound) {
+			return model.ErrInvalidRefreshToken
+		}
+		return err
+	}
+	return s.revoke(session.ID)
+}
+
+func (s *AuthService) ListSessions(userID, currentID string) ([]model.SessionResponse, error) {
+	sessions, err := s.sessions.GetActiveByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := []model.SessionResponse{}
+	for _, session := range sessions {
+		resp = append(resp, session.ToResponse(currentID))
+	}
+	return resp, nil
+}
+
+// RevokeSession ends one of the user's own sessions.
+func (s *AuthService) RevokeSession(userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return model.ErrSessionNotFound
+	}
+	session, err := s.sessions.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return model.ErrSessionNotFound
+	}
+	return s.revoke(sessionID)
+}
+
+// RevokeOtherSessions ends every session of the user except keepID.
+func (s *AuthService) RevokeOtherSessions(userID, keepID string) error {
+	ids, err := s.sessions.RevokeAllExcept(userID, keepID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s.markRevoked(id)
+	}
+	return nil
+}
+
+// IsRevoked reports whether the session behind an access token was revoked.
+// Revocations are mirrored to Redis for as long as an access token can live,
+// so AuthRequired does not hit Postgres on every request.
+func (s *AuthService) IsRevoked(sessionID string) (bool, error) {
+	n, err := s.rdb.Exists(context.Background(), revokedSessionKey(sessionID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *AuthService) revoke(sessionID string) error {
+	if err := s.sessions.Revoke(sessionID); err != nil {
+		return err
+	}
+	s.markRevoked(sessionID)
+	return nil
+}
+
+// markRevoked rejects the session's outstanding access tokens and closes
+// the gateway connections opened with them.
+func (s *AuthService) markRevoked(sessionID string) {
+	if err := s.rdb.Set(context.Background(), revokedSessionKey(sessionID), 1, s.cfg.JWTExpiry).Err(); err != nil {
+		log.Printf("revoke session %s: %v", sessionID, err)
+	}
+	s.hub.DisconnectSession(sessionID)
+}
+
+func (s *AuthService) tokenResponse(user *model.User, sessionID, refresh string) (*model.TokenResponse, error) {
+	token, err := s.generateToken(user.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.TokenResponse{
+		AccessToken:  token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(s.cfg.JWTExpiry.Seconds()),
+		User:         user.ToResponse(),
+	}, nil
+}
+
+func (s *AuthService) generateToken(userID, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
 		"sub": userID,
+		"sid": sessionID,
 		"exp": time.Now().Add(s.cfg.JWTExpiry).Unix(),
 		"iat": time.Now().Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(s.cfg.JWTSecret))
 }
+
+func revokedSessionKey(sessionID string) string { return "auth:revoked:" + sessionID }
+
+func newRefreshToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/mail"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testSessionID = "0a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d"
+	testTOTPKey   = "test-totp-key"
+)
+
+// newTestHub returns a hub that is not running; its broker just buffers.
+func newTestHub(db *sql.DB) *ws.Hub {
+	return ws.NewHub(
+		ws.NewMemoryBroker(),
+		nil,
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewConversationRepository(db),
+		repository.NewPresenceRepository(db),
+	)
+}
+
+// newTestAuthService returns an AuthService on a mocked database and an
+// in-process Redis.
+func newTestAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock, *redis.Client) {
+	t.Helper()
+	auth, _, mock, rdb := newTestAuthServiceDB(t)
+	return auth, mock, rdb
+}
+
+// newTestAuthServiceDB is newTestAuthService for tests that build further
+// repositories on the same database.
+func newTestAuthServiceDB(t *testing.T) (*AuthService, *sql.DB, sqlmock.Sqlmock, *redis.Client) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	cfg := &config.Config{
+		JWTSecret:          "test-secret",
+		JWTExpiry:          15 * time.Minute,
+		RefreshTokenExpiry: 24 * time.Hour,
+	}
+	auth := NewAuthService(
+		repository.NewUserRepository(db),
+		repository.NewSessionRepository(db),
+		repository.NewMFARepository(db, testTOTPKey),
+		rdb,
+		newTestHub(db),
+		mail.NewLogMailer("", "test@localhost", false),
+		cfg,
+	)
+	return auth, db, mock, rdb
+}
+
+var sessionRowColumns = []string{
+	"id", "user_id", "refresh_token_hash", "previous_token_hash", "user_agent",
+	"ip", "created_at", "last_used_at", "expires_at", "revoked_at",
+}
+
+func sessionRow(tokenHash string, expiresAt time.Time, revokedAt *time.Time) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(sessionRowColumns).
+		AddRow(testSessionID, testUserID, tokenHash, nil, "", "", now, now, expiresAt, revokedAt)
+}
+
+func userRow() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "username", "email", "password_hash", "avatar_url", "totp_enabled",
+		"email_verified_at", "deletion_scheduled_at", "bot", "created_at",
+	}).AddRow(testUserID, "alice", "alice@example.com", "", nil, false, nil, nil, false, time.Now())
+}
+
+// capture is a sqlmock argument that accepts any string and keeps it.
+type capture struct{ value string }
+
+func (c *capture) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	c.value = s
+	return ok
+}
+
+var (
+	bySessionToken  = regexp.QuoteMeta(`FROM sessions WHERE refresh_token_hash = $1`)
+	byPreviousToken = regexp.QuoteMeta(`FROM sessions WHERE previous_token_hash = $1`)
+	rotateSession   = regexp.QuoteMeta(`UPDATE sessions
+		SET refresh_token_hash = $3`)
+	revokeSession = regexp.QuoteMeta(`UPDATE sessions SET revoked_at = NOW()`)
+)
+
+func TestRefreshRotatesToken(t *testing.T) {
+	auth, mock, _ := newTestAuthService(t)
+	const old = "old-refresh-token"
+	newHash := &capture{}
+
+	mock.ExpectQuery(bySessionToken).WithArgs(hashToken(old)).
+		WillReturnRows(sessionRow(hashToken(old), time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(rotateSession).
+		WithArgs(testSessionID, hashToken(old), newHash, "agent", "10.0.0.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+
+	resp, err := auth.Refresh(old, model.ClientInfo{UserAgent: "agent", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RefreshToken == "" || resp.RefreshToken == old {
+		t.Fatalf("refresh token not rotated: %q", resp.RefreshToken)
+	}
+	if hashToken(resp.RefreshToken) != newHash.value {
+		t.Fatal("stored hash does not belong to the returned refresh token")
+	}
+	if resp.AccessToken == "" {
+		t.Fatal("no access token")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshReuseRevokesSession(t *testing.T) {
+	auth, mock, rdb := newTestAuthService(t)
+	const stolen = "rotated-out-token"
+
+	mock.ExpectQuery(bySessionToken).WithArgs(hashToken(stolen)).WillReturnRows(sqlmock.NewRows(sessionRowColumns))
+	mock.ExpectQuery(byPreviousToken).WithArgs(hashToken(stolen)).
+		WillReturnRows(sessionRow("current-hash", time.Now().Add(time.Hour), nil))
+	mock.ExpectExec(revokeSession).WithArgs(testSessionID).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := auth.Refresh(stolen, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidRefreshToken) {
+		t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := rdb.Exists(context.Background(), revokedSessionKey(testSessionID)).Result(); n != 1 {
+		t.Fatal("access tokens of the session not revoked")
+	}
+}
+
+func TestRefreshReuseOfRevokedSessionDoesNothing(t *testing.T) {
+	auth, mock, rdb := newTestAuthService(t)
+	const stolen = "rotated-out-token"
+	revokedAt := time.Now().Add(-time.Minute)
+
+	mock.ExpectQuery(bySessionToken).WithArgs(hashToken(stolen)).WillReturnRows(sqlmock.NewRows(sessionRowColumns))
+	mock.ExpectQuery(byPreviousToken).WithArgs(hashToken(stolen)).
+		WillReturnRows(sessionRow("current-hash", time.Now().Add(time.Hour), &revokedAt))
+
+	if _, err := auth.Refresh(stolen, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidRefreshToken) {
+		t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := rdb.Exists(context.Background(), revokedSessionKey(testSessionID)).Result(); n != 0 {
+		t.Fatal("revoked an already revoked session again")
+	}
+}
+
+func TestRefreshRejectsExpiredAndRevokedSessions(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Minute)
+	for name, rows := range map[string]*sqlmock.Rows{
+		"expired": sessionRow(hashToken("token"), time.Now().Add(-time.Minute), nil),
+		"revoked": sessionRow(hashToken("token"), time.Now().Add(time.Hour), &revokedAt),
+	} {
+		t.Run(name, func(t *testing.T) {
+			auth, mock, _ := newTestAuthService(t)
+			mock.ExpectQuery(bySessionToken).WithArgs(hashToken("token")).WillReturnRows(rows)
+
+			if _, err := auth.Refresh("token", model.ClientInfo{}); !errors.Is(err, model.ErrInvalidRefreshToken) {
+				t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRefreshLosesConcurrentRotation(t *testing.T) {
+	auth, mock, _ := newTestAuthService(t)
+	const token = "raced-token"
+
+	mock.ExpectQuery(bySessionToken).WithArgs(hashToken(token)).
+		WillReturnRows(sessionRow(hashToken(token), time.Now().Add(time.Hour), nil))
+	// Another request rotated the token between the lookup and the update.
+	mock.ExpectExec(rotateSession).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := auth.Refresh(token, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidRefreshToken) {
+		t.Fatalf("got %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	maxBotsPerUser = 10
+	// botTokenCacheTTL is how long a resolved bot token is kept in Redis, so
+	// AuthRequired does not hit Postgres on every bot request.
+	botTokenCacheTTL = 5 * time.Minute
+)
+
+// BotService manages bot accounts. A bot is a user without password or
+// email that belongs to a human owner and authenticates with a long-lived
+// token sent as "Authorization: Bot <token>".
+type BotService struct {
+	bots     *repository.BotRepository
+	users    *repository.UserRepository
+	accounts *AccountService
+	auth     *AuthService
+	rdb      *redis.Client
+}
+
+func NewBotService(
+	bots *repository.BotRepository,
+	users *repository.UserRepository,
+	accounts *AccountService,
+	auth *AuthService,
+	rdb *redis.Client,
+) *BotService {
+	return &BotService{bots: bots, users: users, accounts: accounts, auth: auth, rdb: rdb}
+}
+
+// Create registers a bot for ownerID. The response is the only place the
+// token is ever shown.
+func (s *BotService) Create(ownerID string, req model.CreateBotRequest) (*model.BotResponse, error) {
+	count, err := s.bots.CountByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxBotsPerUser {
+		return nil, model.ErrBotLimitReached
+	}
+	if taken, err := s.users.UsernameExists(req.Username); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, model.ErrUsernameTaken
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	user := &model.User{
+		ID:        uuid.New().String(),
+		Username:  req.Username,
+		AvatarURL: req.AvatarURL,
+	}
+	user.Email = user.ID + "@bots.invalid"
+	bot := &model.Bot{
+		UserID:    user.ID,
+		OwnerID:   ownerID,
+		TokenID:   uuid.New().String(),
+		TokenHash: hashToken(token),
+	}
+	if err := s.bots.Create(user, bot); err != nil {
+		return nil, err
+	}
+
+	resp := botResponse(user, bot)
+	resp.Token = token
+	return &resp, nil
+}
+
+func (s *BotService) List(ownerID string) ([]model.BotResponse, error) {
+	bots, err := s.bots.GetByOwnerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+	resp := []model.BotResponse{}
+	for i := range bots {
+		user, err := s.users.GetByID(bots[i].UserID)
+		if err != nil {
+			return nil, err
+		}
+		resp = append(resp, botResponse(user, &bots[i]))
+	}
+	return resp, nil
+}
+
+// RegenerateToken issues a new token. The old one is rejected right away and
+// the gateway connections opened with it are closed.
+func (s *BotService) RegenerateToken(ownerID, botID string) (*model.BotResponse, error) {
+	bot, err := s.owned(ownerID, botID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetByID(bot.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	old := *bot
+	bot.TokenID = uuid.New().String()
+	bot.TokenHash = hashToken(token)
+	if err := s.bots.UpdateToken(bot); err != nil {
+		return nil, err
+	}
+	s.auth.revokeBotToken(&old)
+
+	resp := botResponse(user, bot)
+	resp.Token = token
+	return &resp, nil
+}
+
+// Delete removes the bot right away, the same way a deleted account is
+// purged once its grace period is over.
+func (s *BotService) Delete(ownerID, botID string) error {
+	bot, err := s.owned(ownerID, botID)
+	if err != nil {
+		return err
+	}
+	s.auth.revokeBotToken(bot)
+	return s.accounts.purge(model.AccountDeletion{UserID: bot.UserID, GuildPolicy: model.GuildPolicyTransfer})
+}
+
+// AuthenticateBot resolves a bot token to the bot's user ID and the ID of
+// the token, which takes the place of the login session.
+func (s *BotService) AuthenticateBot(token string) (string, string, error) {
+	ctx := context.Background()
+	hash := hashToken(token)
+
+	cached, err := s.rdb.Get(ctx, botTokenKey(hash)).Result()
+	if err == nil {
+		if userID, tokenID, ok := strings.Cut(cached, ":"); ok {
+			return userID, tokenID, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+
+	bot, err := s.bots.GetByTokenHash(hash)
+	if errors.Is(err, model.ErrBotNotFound) {
+		return "", "", model.ErrInvalidBotToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.rdb.Set(ctx, botTokenKey(hash), bot.UserID+":"+bot.TokenID, botTokenCacheTTL).Err(); err != nil {
+		log.Printf("cache bot token: bot=%s: %v", bot.UserID, err)
+	}
+	return bot.UserID, bot.TokenID, nil
+}
+
+func (s *BotService) owned(ownerID, botID string) (*model.Bot, error) {
+	if _, err := uuid.Parse(botID); err != nil {
+		return nil, model.ErrBotNotFound
+	}
+	bot, err := s.bots.GetByUserID(botID)
+	if err != nil {
+		return nil, err
+	}
+	if bot.OwnerID != ownerID {
+		return nil, model.ErrBotNotFound
+	}
+	return bot, nil
+}
+
+// revokeBotToken drops the cached token and closes the gateway connections
+// opened with it.
+func (s *AuthService) revokeBotToken(bot *model.Bot) {
+	if err := s.rdb.Del(context.Background(), botTokenKey(bot.TokenHash)).Err(); err != nil {
+		log.Printf("revoke bot token: bot=%s: %v", bot.UserID, err)
+	}
+	s.markRevoked(bot.TokenID)
+}
+
+func botTokenKey(hash string) string { return "auth:bot:" + hash }
+
+func botResponse(user *model.User, bot *model.Bot) model.BotResponse {
+	return model.BotResponse{
+		User:           user.ToResponse(),
+		OwnerID:        bot.OwnerID,
+		TokenCreatedAt: bot.TokenCreatedAt,
+		CreatedAt:      bot.CreatedAt,
+	}
+}
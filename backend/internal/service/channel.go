@@ -3,6 +3,7 @@ package service
 import (
 	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
 
 	"github.com/google/uuid"
 )
@@ -10,16 +11,30 @@ import (
 type ChannelService struct {
 	channels *repository.ChannelRepository
 	guilds   *repository.GuildRepository
+	hub      *ws.Hub
+	guildMFA
 }
 
-func NewChannelService(channels *repository.ChannelRepository, guilds *repository.GuildRepository) *ChannelService {
-	return &ChannelService{channels: channels, guilds: guilds}
+func NewChannelService(
+	channels *repository.ChannelRepository,
+	guilds *repository.GuildRepository,
+	users *repository.UserRepository,
+	bots *repository.BotRepository,
+	hub *ws.Hub,
+) *ChannelService {
+	return &ChannelService{
+		channels: channels, guilds: guilds, hub: hub,
+		guildMFA: guildMFA{guilds: guilds, users: users, bots: bots},
+	}
 }
 
 func (s *ChannelService) Create(userID, guildID string, req model.CreateChannelRequest) (*model.Channel, error) {
 	if err := s.requireMember(guildID, userID, model.RoleOwner, model.RoleAdmin); err != nil {
 		return nil, err
 	}
+	if err := s.requireGuildMFA(guildID, userID); err != nil {
+		return nil, err
+	}
 
 	pos, _ := s.channels.GetNextPosition(guildID)
 
@@ -34,6 +49,8 @@ func (s *ChannelService) Create(userID, guildID string, req model.CreateChannelR
 	if err := s.channels.Create(ch); err != nil {
 		return nil, err
 	}
+
+	s.hub.BroadcastToGuild(guildID, ws.Event{Type: ws.EventChannelCreate, Data: ch})
 	return ch, nil
 }
 
@@ -52,6 +69,9 @@ func (s *ChannelService) Update(userID, channelID string, req model.UpdateChanne
 	if err := s.requireMember(ch.GuildID, userID, model.RoleOwner, model.RoleAdmin); err != nil {
 		return nil, err
 	}
+	if err := s.requireGuildMFA(ch.GuildID, userID); err != nil {
+		return nil, err
+	}
 	if req.Name != nil {
 		ch.Name = *req.Name
 	}
@@ -64,6 +84,8 @@ func (s *ChannelService) Update(userID, channelID string, req model.UpdateChanne
 	if err := s.channels.Update(ch); err != nil {
 		return nil, err
 	}
+
+	s.hub.BroadcastToGuild(ch.GuildID, ws.Event{Type: ws.EventChannelUpdate, Data: ch})
 	return ch, nil
 }
 
@@ -75,7 +97,18 @@ func (s *ChannelService) Delete(userID, channelID string) error {
 	if err := s.requireMember(ch.GuildID, userID, model.RoleOwner, model.RoleAdmin); err != nil {
 		return err
 	}
-	return s.channels.Delete(channelID)
+	if err := s.requireGuildMFA(ch.GuildID, userID); err != nil {
+		return err
+	}
+	if err := s.channels.Delete(channelID); err != nil {
+		return err
+	}
+
+	s.hub.BroadcastToGuild(ch.GuildID, ws.Event{
+		Type: ws.EventChannelDelete,
+		Data: ws.ChannelDeleteData{ID: channelID, GuildID: ch.GuildID},
+	})
+	return nil
 }
 
 func (s *ChannelService) GetByID(id string) (*model.Channel, error) {
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestChannelChangesBroadcastToGuild(t *testing.T) {
+	hub, db, mock, msgs := newPublishingHub(t)
+	channels := NewChannelService(repository.NewChannelRepository(db), repository.NewGuildRepository(db),
+		repository.NewUserRepository(db), repository.NewBotRepository(db), hub)
+	room := "guild:" + testGuildID
+
+	expectMemberRole(mock, testUserID, model.RoleAdmin)
+	expectGuild(mock)
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(position\), 0\) \+ 1 FROM channels`).WithArgs(testGuildID).
+		WillReturnRows(sqlmock.NewRows([]string{"pos"}).AddRow(2))
+	mock.ExpectExec(`INSERT INTO channels`).WillReturnResult(sqlmock.NewResult(0, 1))
+	ch, err := channels.Create(testUserID, testGuildID, model.CreateChannelRequest{Name: "news", Type: model.ChannelText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := expectPublished(t, msgs, room, ws.EventChannelCreate)
+	var created model.Channel
+	json.Unmarshal(p.Data, &created)
+	if created.ID != ch.ID || created.Position != 2 {
+		t.Fatalf("CHANNEL_CREATE %s", p.Data)
+	}
+
+	mock.ExpectQuery(`FROM channels WHERE id = \$1`).WithArgs(testChannelID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+			AddRow(testChannelID, testGuildID, "general", model.ChannelText, nil, 0, created.CreatedAt))
+	expectMemberRole(mock, testUserID, model.RoleAdmin)
+	expectGuild(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM reactions`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM messages`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM channels`).WithArgs(testChannelID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := channels.Delete(testUserID, testChannelID); err != nil {
+		t.Fatal(err)
+	}
+	p = expectPublished(t, msgs, room, ws.EventChannelDelete)
+	var deleted ws.ChannelDeleteData
+	json.Unmarshal(p.Data, &deleted)
+	if deleted.ID != testChannelID || deleted.GuildID != testGuildID {
+		t.Fatalf("CHANNEL_DELETE %s", p.Data)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
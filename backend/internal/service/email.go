@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"pwdh-aether/internal/mail"
+	"pwdh-aether/internal/middleware"
+	"pwdh-aether/internal/model"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	emailVerifyTokenType = "email_verify"
+	// mailThrottle limits how often a user can trigger verification or reset
+	// mails.
+	mailThrottle = time.Minute
+	mailTimeout  = 30 * time.Second
+)
+
+// SendVerification mails a fresh verification link to the user.
+func (s *AuthService) SendVerification(userID string) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return model.ErrEmailAlreadyVerified
+	}
+	if !s.claimMailSlot("verify", userID) {
+		return nil
+	}
+	return s.sendVerification(user)
+}
+
+func (s *AuthService) sendVerification(user *model.User) error {
+	token, err := s.verificationToken(user)
+	if err != nil {
+		return err
+	}
+	link := s.cfg.FrontendURL + "/verify-email?token=" + url.QueryEscape(token)
+	s.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "E-Mail-Adresse bestaetigen",
+		Body: fmt.Sprintf("Hallo %s,\n\nbitte bestaetige deine E-Mail-Adresse fuer PWDH-Aether:\n\n%s\n\n"+
+			"Der Link ist %s gueltig. Falls du dich nicht registriert hast, kannst du diese Mail ignorieren.\n",
+			user.Username, link, s.cfg.EmailVerificationExpiry),
+	})
+	return nil
+}
+
+// VerifyEmail checks a link from SendVerification. The token is signed over
+// the address it was sent to, so it stops working if the email changes.
+func (s *AuthService) VerifyEmail(token string) error {
+	claims, err := middleware.ParseTypedToken(s.cfg.JWTSecret, token, emailVerifyTokenType)
+	if err != nil {
+		return model.ErrInvalidVerifyToken
+	}
+	userID, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	if userID == "" || email == "" {
+		return model.ErrInvalidVerifyToken
+	}
+
+	ok, err := s.users.MarkEmailVerified(userID, email)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return model.ErrInvalidVerifyToken
+	}
+	return nil
+}
+
+// ForgotPassword mails a reset link if an account with the address exists.
+// It reports success either way so the endpoint does not reveal which
+// addresses are registered.
+func (s *AuthService) ForgotPassword(email string) error {
+	user, err := s.users.GetByEmail(email)
+	if errors.Is(err, model.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Bots have no mailbox and log in with their token only.
+	if user.Bot || !s.claimMailSlot("reset", user.ID) {
+		return nil
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	if err := s.rdb.Set(context.Background(), resetTokenKey(token), user.ID, s.cfg.PasswordResetExpiry).Err(); err != nil {
+		return err
+	}
+
+	link := s.cfg.FrontendURL + "/reset-password?token=" + url.QueryEscape(token)
+	s.sendMail(mail.Message{
+		To:      user.Email,
+		Subject: "Passwort zuruecksetzen",
+		Body: fmt.Sprintf("Hallo %s,\n\nueber diesen Link kannst du ein neues Passwort fuer PWDH-Aether setzen:\n\n%s\n\n"+
+			"Der Link ist %s gueltig und funktioniert nur einmal. Falls du das nicht angefordert hast, kannst du diese Mail ignorieren.\n",
+			user.Username, link, s.cfg.PasswordResetExpiry),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// signs out every session, since the old password may be known to someone
+// else.
+func (s *AuthService) ResetPassword(token, password string) error {
+	userID, err := s.rdb.GetDel(context.Background(), resetTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return model.ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+
+	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
+	if err := s.users.UpdatePassword(user.ID, hash); err != nil {
+		return err
+	}
+	// The link arrived in the mailbox, which proves the address as well.
+	if _, err := s.users.MarkEmailVerified(user.ID, user.Email); err != nil {
+		log.Printf("verify email on reset: user=%s: %v", user.ID, err)
+	}
+	return s.RevokeOtherSessions(user.ID, "")
+}
+
+func (s *AuthService) verificationToken(user *model.User) (string, error) {
+	claims := jwt.MapClaims{
+		"typ":   emailVerifyTokenType,
+		"sub":   user.ID,
+		"email": user.Email,
+		"exp":   time.Now().Add(s.cfg.EmailVerificationExpiry).Unix(),
+		"iat":   time.Now().Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.cfg.JWTSecret))
+}
+
+func (s *AuthService) claimMailSlot(kind, userID string) bool {
+	ok, err := s.rdb.SetNX(context.Background(), "auth:mail:"+kind+":"+userID, 1, mailThrottle).Result()
+	return err == nil && ok
+}
+
+// sendMail delivers in the background; a slow or unreachable relay must not
+// hold up the request.
+func (s *AuthService) sendMail(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("send mail to %s: %v", msg.To, err)
+		}
+	}()
+}
+
+func resetTokenKey(token string) string { return "auth:reset:" + hashToken(token) }
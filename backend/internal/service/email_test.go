@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/mail"
+	"pwdh-aether/internal/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// mailbox is a Mailer that hands every message to the test.
+type mailbox chan mail.Message
+
+func (m mailbox) Send(_ context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+func (m mailbox) next(t *testing.T) mail.Message {
+	t.Helper()
+	select {
+	case msg := <-m:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no mail sent")
+		return mail.Message{}
+	}
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+func newTestEmailService(t *testing.T) (*AuthService, sqlmock.Sqlmock, mailbox) {
+	t.Helper()
+	auth, mock, _ := newTestAuthService(t)
+	box := make(mailbox, 4)
+	auth.mailer = box
+	auth.cfg.FrontendURL = "http://localhost:3000"
+	auth.cfg.EmailVerificationExpiry = time.Hour
+	auth.cfg.PasswordResetExpiry = time.Hour
+	return auth, mock, box
+}
+
+func tokenFrom(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	m := linkToken.FindStringSubmatch(msg.Body)
+	if m == nil {
+		t.Fatalf("no link in %q", msg.Body)
+	}
+	return m[1]
+}
+
+func TestVerifyEmailChecksTheAddress(t *testing.T) {
+	auth, mock, box := newTestEmailService(t)
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+	if err := auth.SendVerification(testUserID); err != nil {
+		t.Fatal(err)
+	}
+	msg := box.next(t)
+	if msg.To != "alice@example.com" {
+		t.Fatalf("mail to %s", msg.To)
+	}
+	token := tokenFrom(t, msg)
+
+	mock.ExpectExec(`UPDATE users SET email_verified_at`).WithArgs(testUserID, "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := auth.VerifyEmail(token); err != nil {
+		t.Fatal(err)
+	}
+	// After a change of address the link matches no row any more.
+	mock.ExpectExec(`UPDATE users SET email_verified_at`).WithArgs(testUserID, "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := auth.VerifyEmail(token); !errors.Is(err, model.ErrInvalidVerifyToken) {
+		t.Fatalf("got %v, want ErrInvalidVerifyToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyEmailRejectsAccessTokens(t *testing.T) {
+	auth, mock, _ := newTestEmailService(t)
+	access, err := auth.generateToken(testUserID, testSessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.VerifyEmail(access); !errors.Is(err, model.ErrInvalidVerifyToken) {
+		t.Fatalf("got %v, want ErrInvalidVerifyToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetPasswordTokenIsSingleUse(t *testing.T) {
+	auth, mock, box := newTestEmailService(t)
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).WithArgs("alice@example.com").WillReturnRows(userRow())
+	if err := auth.ForgotPassword("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	token := tokenFrom(t, box.next(t))
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+	mock.ExpectExec(`UPDATE users SET password_hash`).WithArgs(testUserID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET email_verified_at`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE sessions SET revoked_at = NOW\(\)`).WithArgs(testUserID, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testSessionID))
+	if err := auth.ResetPassword(token, "new-password-123"); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := auth.IsRevoked(testSessionID); !revoked {
+		t.Fatal("sessions not signed out after the reset")
+	}
+
+	if err := auth.ResetPassword(token, "another-password"); !errors.Is(err, model.ErrInvalidResetToken) {
+		t.Fatalf("second use: got %v, want ErrInvalidResetToken", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForgotPasswordHidesUnknownAddresses(t *testing.T) {
+	auth, mock, box := newTestEmailService(t)
+	mock.ExpectQuery(`FROM users WHERE email = \$1`).WithArgs("nobody@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if err := auth.ForgotPassword("nobody@example.com"); err != nil {
+		t.Fatalf("got %v for an unknown address", err)
+	}
+	select {
+	case msg := <-box:
+		t.Fatalf("mail sent to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// exportCooldown limits how often a user can have an archive built;
+	// until it passes, the last one is handed out again.
+	exportCooldown = 24 * time.Hour
+	// exportStaleAfter gives up on a pending export whose node went away.
+	exportStaleAfter      = time.Hour
+	exportTimeout         = 30 * time.Minute
+	exportCleanupInterval = time.Hour
+	exportCleanupBatch    = 100
+)
+
+type ExportService struct {
+	exports    *repository.ExportRepository
+	users      *repository.UserRepository
+	lfg        *repository.LFGRepository
+	presence   *repository.PresenceRepository
+	sessions   *repository.SessionRepository
+	identities *repository.IdentityRepository
+	minio      *minio.Client
+	hub        *ws.Hub
+	cfg        *config.Config
+}
+
+func NewExportService(
+	exports *repository.ExportRepository,
+	users *repository.UserRepository,
+	lfg *repository.LFGRepository,
+	presence *repository.PresenceRepository,
+	sessions *repository.SessionRepository,
+	identities *repository.IdentityRepository,
+	minioClient *minio.Client,
+	hub *ws.Hub,
+	cfg *config.Config,
+) *ExportService {
+	return &ExportService{
+		exports: exports, users: users, lfg: lfg, presence: presence, sessions: sessions,
+		identities: identities, minio: minioClient, hub: hub, cfg: cfg,
+	}
+}
+
+// Request starts building an export in the background. The user is told
+// over the gateway once the download is ready.
+func (s *ExportService) Request(userID string) (*model.DataExport, error) {
+	latest, err := s.exports.GetLatestByUserID(userID)
+	if err != nil && !errors.Is(err, model.ErrNotFound) {
+		return nil, err
+	}
+	if latest != nil {
+		age := time.Since(latest.CreatedAt)
+		if latest.Status == model.ExportPending && age < exportStaleAfter {
+			return nil, model.ErrExportInProgress
+		}
+		if latest.Status == model.ExportReady && age < exportCooldown {
+			return nil, model.ErrExportCooldown
+		}
+	}
+
+	export, err := s.exports.Create(userID)
+	if err != nil {
+		return nil, err
+	}
+	go s.build(export)
+	return export, nil
+}
+
+// Latest returns the user's most recent export with a fresh download link.
+func (s *ExportService) Latest(userID string) (*model.DataExport, error) {
+	export, err := s.exports.GetLatestByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sign(context.Background(), export); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// RunCleanup removes archives past their retention until ctx is done.
+func (s *ExportService) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(exportCleanupInterval)
+	defer ticker.Stop()
+	for {
+		s.cleanup(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExportService) cleanup(ctx context.Context) {
+	expired, err := s.exports.GetExpired(exportCleanupBatch)
+	if err != nil {
+		log.Printf("export cleanup: %v", err)
+		return
+	}
+	for _, e := range expired {
+		if e.ObjectKey != nil {
+			if err := s.minio.RemoveObject(ctx, s.cfg.MinioBucket, *e.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("export cleanup %s: %v", e.ID, err)
+				continue
+			}
+		}
+		if err := s.exports.MarkExpired(e.ID); err != nil {
+			log.Printf("export cleanup %s: %v", e.ID, err)
+		}
+	}
+}
+
+// DeleteAll removes every export of a deleted account. The archives go first,
+// so a failure leaves the rows that point at them for the next attempt.
+func (s *ExportService) DeleteAll(ctx context.Context, userID string) error {
+	exports, err := s.exports.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if e.ObjectKey == nil {
+			continue
+		}
+		if err := s.minio.RemoveObject(ctx, s.cfg.MinioBucket, *e.ObjectKey, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("remove export %s: %w", e.ID, err)
+		}
+	}
+	return s.exports.DeleteByUserID(userID)
+}
+
+func (s *ExportService) build(export *model.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	if err := s.buildArchive(ctx, export); err != nil {
+		log.Printf("export %s: user=%s: %v", export.ID, export.UserID, err)
+		if err := s.exports.MarkFailed(export.ID); err != nil {
+			log.Printf("export %s: mark failed: %v", export.ID, err)
+		}
+		export.Status = model.ExportFailed
+	} else if err := s.sign(ctx, export); err != nil {
+		log.Printf("export %s: sign: %v", export.ID, err)
+	}
+
+	s.hub.BroadcastToUser(export.UserID, ws.Event{Type: ws.EventDataExportComplete, Data: export})
+}
+
+// buildArchive writes the ZIP to a temporary file, uploads it and marks the
+// export ready.
+func (s *ExportService) buildArchive(ctx context.Context, export *model.DataExport) error {
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	zw := zip.NewWriter(tmp)
+	if err := s.writeArchive(ctx, zw, export.UserID); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/%s/%s.zip", export.UserID, export.ID)
+	if _, err := s.minio.PutObject(ctx, s.cfg.MinioBucket, key, tmp, size,
+		minio.PutObjectOptions{ContentType: "application/zip"}); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.cfg.ExportRetention)
+	if err := s.exports.MarkReady(export.ID, key, size, expiresAt); err != nil {
+		return err
+	}
+	export.Status = model.ExportReady
+	export.ObjectKey = &key
+	export.SizeBytes = &size
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	return nil
+}
+
+func (s *ExportService) writeArchive(ctx context.Context, zw *zip.Writer, userID string) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	profile := struct {
+		model.UserResponse
+		TOTPEnabled bool `json:"totp_enabled"`
+	}{user.ToResponse(), user.TOTPEnabled}
+
+	presence, err := s.presence.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	presenceHistory, err := s.exports.GetPresenceHistory(userID)
+	if err != nil {
+		return err
+	}
+	guilds, err := s.exports.GetMemberships(userID)
+	if err != nil {
+		return err
+	}
+	messages, err := s.exports.GetMessages(userID)
+	if err != nil {
+		return err
+	}
+	dms, err := s.exports.GetDirectMessages(userID)
+	if err != nil {
+		return err
+	}
+	reactions, err := s.exports.GetReactions(userID)
+	if err != nil {
+		return err
+	}
+	clips, err := s.exports.GetSoundboardClips(userID)
+	if err != nil {
+		return err
+	}
+	lfgCreated, err := s.lfg.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+	lfgJoined, err := s.lfg.GetJoinedByUserID(userID)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.sessions.GetActiveByUserID(userID)
+	if err != nil {
+		return err
+	}
+	sessionList := []model.SessionResponse{}
+	for _, session := range sessions {
+		sessionList = append(sessionList, session.ToResponse(""))
+	}
+	identities, err := s.identities.GetByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"presence.json", map[string]interface{}{"current": presence, "history": presenceHistory}},
+		{"guilds.json", guilds},
+		{"messages.json", messages},
+		{"direct_messages.json", dms},
+		{"reactions.json", reactions},
+		{"lfg_posts.json", map[string]interface{}{"created": lfgCreated, "joined": lfgJoined}},
+		{"soundboard_clips.json", clips},
+		{"sessions.json", sessionList},
+		{"identities.json", identities},
+	}
+	for _, f := range files {
+		if err := writeJSON(zw, f.name, f.data); err != nil {
+			return err
+		}
+	}
+
+	// Attachments are only known by the URLs stored with the user's content.
+	var urls []string
+	if user.AvatarURL != nil {
+		urls = append(urls, *user.AvatarURL)
+	}
+	for _, m := range messages {
+		if m.AttachmentURL != nil {
+			urls = append(urls, *m.AttachmentURL)
+		}
+	}
+	for _, m := range dms {
+		if m.AttachmentURL != nil {
+			urls = append(urls, *m.AttachmentURL)
+		}
+	}
+	for _, c := range clips {
+		urls = append(urls, c.FileURL)
+	}
+	seen := make(map[string]bool)
+	for _, u := range urls {
+		object, ok := s.objectName(u)
+		if !ok || seen[object] {
+			continue
+		}
+		seen[object] = true
+		if err := s.copyObject(ctx, zw, object); err != nil {
+			log.Printf("export attachment %s: %v", object, err)
+		}
+	}
+	return nil
+}
+
+func (s *ExportService) copyObject(ctx context.Context, zw *zip.Writer, object string) error {
+	obj, err := s.minio.GetObject(ctx, s.cfg.MinioBucket, object, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	w, err := zw.Create("attachments/" + object)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, obj)
+	return err
+}
+
+// objectName maps a URL handed out by the upload endpoint back to its object
+// in the bucket. URLs pointing anywhere else are skipped.
+func (s *ExportService) objectName(raw string) (string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host != s.cfg.MinioEndpoint {
+		return "", false
+	}
+	prefix := "/" + s.cfg.MinioBucket + "/"
+	if !strings.HasPrefix(u.Path, prefix) {
+		return "", false
+	}
+	object := strings.TrimPrefix(u.Path, prefix)
+	if object == "" || strings.Contains(object, "/") || object != path.Clean(object) {
+		return "", false
+	}
+	return object, true
+}
+
+func (s *ExportService) sign(ctx context.Context, export *model.DataExport) error {
+	if export.Status != model.ExportReady || export.ObjectKey == nil {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("response-content-disposition", `attachment; filename="pwdh-aether-export.zip"`)
+	link, err := s.minio.PresignedGetObject(ctx, s.cfg.MinioBucket, *export.ObjectKey, s.cfg.ExportLinkExpiry, params)
+	if err != nil {
+		return fmt.Errorf("presign: %w", err)
+	}
+	export.DownloadURL = link.String()
+	return nil
+}
+
+func writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
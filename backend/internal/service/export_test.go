@@ -0,0 +1,61 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestExportObjectName(t *testing.T) {
+	s := &ExportService{cfg: &config.Config{MinioEndpoint: "minio:9000", MinioBucket: "aether"}}
+	for raw, want := range map[string]string{
+		"http://minio:9000/aether/abc.png":     "abc.png",
+		"http://minio:9000/aether/":            "",
+		"http://minio:9000/aether/a/b.png":     "",
+		"http://minio:9000/aether/../x.png":    "",
+		"http://minio:9000/other/abc.png":      "",
+		"http://elsewhere:9000/aether/abc.png": "",
+		"::not a url":                          "",
+	} {
+		got, ok := s.objectName(raw)
+		if got != want || ok != (want != "") {
+			t.Errorf("objectName(%q) = %q, %v, want %q", raw, got, ok, want)
+		}
+	}
+}
+
+func TestExportRequestIsThrottled(t *testing.T) {
+	for name, tc := range map[string]struct {
+		status string
+		age    time.Duration
+		want   error
+	}{
+		"pending": {model.ExportPending, time.Minute, model.ErrExportInProgress},
+		"ready":   {model.ExportReady, time.Hour, model.ErrExportCooldown},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			s := &ExportService{exports: repository.NewExportRepository(db)}
+			mock.ExpectQuery(`FROM data_exports WHERE user_id = \$1 ORDER BY created_at DESC`).WithArgs(testUserID).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "status", "object_key", "size_bytes", "created_at", "completed_at", "expires_at"}).
+					AddRow("1", testUserID, tc.status, nil, nil, time.Now().Add(-tc.age), nil, nil))
+
+			if _, err := s.Request(testUserID); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"pwdh-aether/internal/config"
 	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
 
 	"github.com/google/uuid"
 )
@@ -10,10 +12,24 @@ import (
 type GuildService struct {
 	guilds   *repository.GuildRepository
 	channels *repository.ChannelRepository
+	users    *repository.UserRepository
+	hub      *ws.Hub
+	cfg      *config.Config
+	guildMFA
 }
 
-func NewGuildService(guilds *repository.GuildRepository, channels *repository.ChannelRepository) *GuildService {
-	return &GuildService{guilds: guilds, channels: channels}
+func NewGuildService(
+	guilds *repository.GuildRepository,
+	channels *repository.ChannelRepository,
+	users *repository.UserRepository,
+	bots *repository.BotRepository,
+	hub *ws.Hub,
+	cfg *config.Config,
+) *GuildService {
+	return &GuildService{
+		guilds: guilds, channels: channels, users: users, hub: hub, cfg: cfg,
+		guildMFA: guildMFA{guilds: guilds, users: users, bots: bots},
+	}
 }
 
 func (s *GuildService) Create(userID string, req model.CreateGuildRequest) (*model.Guild, error) {
@@ -56,6 +72,22 @@ func (s *GuildService) Update(userID, guildID string, req model.UpdateGuildReque
 	if err != nil {
 		return nil, err
 	}
+	if err := s.requireMFA(guild, userID); err != nil {
+		return nil, err
+	}
+	if req.RequireMFA != nil && *req.RequireMFA != guild.RequireMFA {
+		// Only the owner decides, and cannot lock themselves out by
+		// requiring a second factor they do not have.
+		if guild.OwnerID != userID {
+			return nil, model.ErrNotAuthorized
+		}
+		if *req.RequireMFA {
+			if err := s.requireTOTP(userID); err != nil {
+				return nil, err
+			}
+		}
+		guild.RequireMFA = *req.RequireMFA
+	}
 	if req.Name != nil {
 		guild.Name = *req.Name
 	}
@@ -76,6 +108,9 @@ func (s *GuildService) Delete(userID, guildID string) error {
 	if guild.OwnerID != userID {
 		return model.ErrNotAuthorized
 	}
+	if err := s.requireMFA(guild, userID); err != nil {
+		return err
+	}
 	return s.guilds.Delete(guildID)
 }
 
@@ -87,9 +122,26 @@ func (s *GuildService) Join(userID, inviteCode string) (*model.Guild, error) {
 	if member, _ := s.guilds.IsMember(guild.ID, userID); member {
 		return nil, model.ErrAlreadyMember
 	}
+	if s.cfg.RequireVerifiedEmail {
+		user, err := s.users.GetByID(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user.EmailVerifiedAt == nil {
+			return nil, model.ErrEmailNotVerified
+		}
+	}
 	if err := s.guilds.AddMember(guild.ID, userID, model.RoleMember); err != nil {
 		return nil, err
 	}
+
+	s.hub.JoinUser(userID, "guild:"+guild.ID)
+	if member, err := s.guilds.GetMemberResponse(guild.ID, userID); err == nil {
+		s.hub.BroadcastToGuild(guild.ID, ws.Event{
+			Type: ws.EventMemberJoin,
+			Data: ws.MemberJoinData{GuildID: guild.ID, Member: *member},
+		})
+	}
 	return guild, nil
 }
 
@@ -101,7 +153,15 @@ func (s *GuildService) Leave(userID, guildID string) error {
 	if guild.OwnerID == userID {
 		return model.ErrNotAuthorized
 	}
-	return s.guilds.RemoveMember(guildID, userID)
+	if err := s.guilds.RemoveMember(guildID, userID); err != nil {
+		return err
+	}
+	s.hub.RevokeGuild(guildID, userID)
+	s.hub.BroadcastToGuild(guildID, ws.Event{
+		Type: ws.EventMemberLeave,
+		Data: ws.MemberLeaveData{GuildID: guildID, UserID: userID, Reason: ws.LeaveReasonLeft},
+	})
+	return nil
 }
 
 func (s *GuildService) GetMembers(guildID string) ([]model.MemberResponse, error) {
@@ -112,6 +172,9 @@ func (s *GuildService) KickMember(actorID, guildID, targetID string) error {
 	if err := s.requireRole(guildID, actorID, model.RoleOwner, model.RoleAdmin, model.RoleModerator); err != nil {
 		return err
 	}
+	if err := s.requireGuildMFA(guildID, actorID); err != nil {
+		return err
+	}
 	target, err := s.guilds.GetMember(guildID, targetID)
 	if err != nil {
 		return err
@@ -119,14 +182,46 @@ func (s *GuildService) KickMember(actorID, guildID, targetID string) error {
 	if target.Role == model.RoleOwner {
 		return model.ErrNotAuthorized
 	}
-	return s.guilds.RemoveMember(guildID, targetID)
+	if err := s.guilds.RemoveMember(guildID, targetID); err != nil {
+		return err
+	}
+	s.hub.RevokeGuild(guildID, targetID)
+	s.hub.BroadcastToUser(targetID, ws.Event{
+		Type: ws.EventGuildRemove,
+		Data: map[string]string{"guild_id": guildID, "reason": ws.LeaveReasonKicked},
+	})
+	s.hub.BroadcastToGuild(guildID, ws.Event{
+		Type: ws.EventMemberLeave,
+		Data: ws.MemberLeaveData{GuildID: guildID, UserID: targetID, Reason: ws.LeaveReasonKicked},
+	})
+	return nil
 }
 
 func (s *GuildService) UpdateMemberRole(actorID, guildID, targetID, role string) error {
 	if err := s.requireRole(guildID, actorID, model.RoleOwner, model.RoleAdmin); err != nil {
 		return err
 	}
-	return s.guilds.UpdateMemberRole(guildID, targetID, role)
+	guild, err := s.guilds.GetByID(guildID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireMFA(guild, actorID); err != nil {
+		return err
+	}
+	if role == model.RoleAdmin || role == model.RoleModerator {
+		if err := s.requireMFA(guild, targetID); err != nil {
+			return err
+		}
+	}
+	if err := s.guilds.UpdateMemberRole(guildID, targetID, role); err != nil {
+		return err
+	}
+
+	s.hub.BroadcastToGuild(guildID, ws.Event{
+		Type: ws.EventMemberUpdate,
+		Data: ws.MemberUpdateData{GuildID: guildID, UserID: targetID, Role: role},
+	})
+	return nil
 }
 
 func (s *GuildService) requireRole(guildID, userID string, roles ...string) error {
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+)
+
+// guildMFA enforces the RequireMFA setting of guilds. Every service that
+// lets members moderate a guild embeds it.
+type guildMFA struct {
+	guilds *repository.GuildRepository
+	users  *repository.UserRepository
+	bots   *repository.BotRepository
+}
+
+// requireGuildMFA is requireMFA for callers that have not loaded the guild.
+func (m guildMFA) requireGuildMFA(guildID, userID string) error {
+	guild, err := m.guilds.GetByID(guildID)
+	if err != nil {
+		return err
+	}
+	return m.requireMFA(guild, userID)
+}
+
+// requireMFA rejects moderation by users without two-factor authentication
+// in guilds whose owner requires it.
+func (m guildMFA) requireMFA(guild *model.Guild, userID string) error {
+	if !guild.RequireMFA {
+		return nil
+	}
+	return m.requireTOTP(userID)
+}
+
+// requireTOTP checks the user's two-factor authentication. Bots cannot
+// enroll, so their owner's counts instead.
+func (m guildMFA) requireTOTP(userID string) error {
+	user, err := m.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Bot {
+		bot, err := m.bots.GetByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if user, err = m.users.GetByID(bot.OwnerID); err != nil {
+			return err
+		}
+	}
+	if !user.TOTPEnabled {
+		return model.ErrMFARequired
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectMember(mock sqlmock.Sqlmock, role string) {
+	mock.ExpectQuery(`FROM members WHERE guild_id = \$1 AND user_id = \$2`).WithArgs(testGuildID, testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "guild_id", "role", "joined_at"}).
+			AddRow(testUserID, testGuildID, role, time.Now()))
+}
+
+func expectChannel(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM channels WHERE id = \$1`).WithArgs(testChannelID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+			AddRow(testChannelID, testGuildID, "general", model.ChannelText, nil, 0, time.Now()))
+}
+
+func newTestChannelService(t *testing.T) (*ChannelService, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewChannelService(
+		repository.NewChannelRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewUserRepository(db),
+		repository.NewBotRepository(db),
+		newTestHub(db),
+	), mock
+}
+
+func TestChannelChangesRequireGuildMFA(t *testing.T) {
+	name := "renamed"
+	for op, call := range map[string]func(*ChannelService) error{
+		"create": func(s *ChannelService) error {
+			_, err := s.Create(testUserID, testGuildID, model.CreateChannelRequest{Name: "new", Type: model.ChannelText})
+			return err
+		},
+		"update": func(s *ChannelService) error {
+			_, err := s.Update(testUserID, testChannelID, model.UpdateChannelRequest{Name: &name})
+			return err
+		},
+		"delete": func(s *ChannelService) error {
+			return s.Delete(testUserID, testChannelID)
+		},
+	} {
+		t.Run(op, func(t *testing.T) {
+			s, mock := newTestChannelService(t)
+			if op != "create" {
+				expectChannel(mock)
+			}
+			expectMember(mock, model.RoleAdmin)
+			expectGuildMFA(mock, true)
+			mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+
+			if err := call(s); !errors.Is(err, model.ErrMFARequired) {
+				t.Fatalf("got %v, want ErrMFARequired", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeletingOthersMessagesRequiresGuildMFA(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewMessageService(
+		repository.NewMessageRepository(db),
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewBotRepository(db),
+		newTestHub(db),
+	)
+	const messageID = "7e6d5c4b-3a29-4180-9f7e-6d5c4b3a2918"
+
+	mock.ExpectQuery(`FROM messages WHERE id = \$1`).WithArgs(messageID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel_id", "user_id", "content", "attachment_url", "created_at", "updated_at"}).
+			AddRow(messageID, testChannelID, "someone-else", "hi", nil, time.Now(), time.Now()))
+	expectChannel(mock)
+	expectMember(mock, model.RoleModerator)
+	expectGuildMFA(mock, true)
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+
+	if err := s.Delete(testUserID, messageID); !errors.Is(err, model.ErrMFARequired) {
+		t.Fatalf("got %v, want ErrMFARequired", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
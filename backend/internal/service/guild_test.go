@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/ws"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testUserID    = "0f3e2d1c-9b8a-4765-8f4e-3d2c1b0a9f8e"
+	testGuildID   = "5d1f6a0e-6c1b-4f8e-9a57-0f4c2d8b7e21"
+	testChannelID = "7e6d5c4b-3a29-4f18-8e07-d6c5b4a39281"
+	kickedUserID  = "2b3c4d5e-6f70-4a8b-9c0d-1e2f3a4b5c6d"
+)
+
+type published struct {
+	Room string
+	Type string          `json:"t"`
+	Data json.RawMessage `json:"d"`
+}
+
+// newPublishingHub returns a hub that publishes to an in-process Redis. Everything
+// it publishes is sent on the returned channel.
+func newPublishingHub(t *testing.T) (*ws.Hub, *sql.DB, sqlmock.Sqlmock, <-chan *redis.Message) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	pubsub := rdb.PSubscribe(context.Background(), "ws:*")
+	if _, err := pubsub.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pubsub.Close() })
+
+	hub := ws.NewHub(ws.NewRedisBroker(rdb), rdb, repository.NewUserRepository(db), repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db), repository.NewConversationRepository(db), repository.NewPresenceRepository(db))
+	return hub, db, mock, pubsub.Channel()
+}
+
+func nextPublished(t *testing.T, msgs <-chan *redis.Message) published {
+	t.Helper()
+	select {
+	case m := <-msgs:
+		p := published{Room: strings.TrimPrefix(m.Channel, "ws:")}
+		json.Unmarshal([]byte(m.Payload), &p)
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+		return published{}
+	}
+}
+
+func expectPublished(t *testing.T, msgs <-chan *redis.Message, room, eventType string) published {
+	t.Helper()
+	p := nextPublished(t, msgs)
+	if p.Room != room || p.Type != eventType {
+		t.Fatalf("published %s %s to %s, want %s to %s", p.Type, p.Data, p.Room, eventType, room)
+	}
+	return p
+}
+
+func newTestGuildService(t *testing.T) (*GuildService, sqlmock.Sqlmock, <-chan *redis.Message) {
+	t.Helper()
+	hub, db, mock, msgs := newPublishingHub(t)
+	return NewGuildService(repository.NewGuildRepository(db), repository.NewChannelRepository(db),
+		repository.NewUserRepository(db), repository.NewBotRepository(db), hub, &config.Config{}), mock, msgs
+}
+
+func expectRevoke(t *testing.T, mock sqlmock.Sqlmock, msgs <-chan *redis.Message, userID string) {
+	t.Helper()
+	p := nextPublished(t, msgs)
+	var msg struct {
+		Op      string   `json:"op"`
+		UserID  string   `json:"user_id"`
+		GuildID string   `json:"guild_id"`
+		Rooms   []string `json:"rooms"`
+	}
+	json.Unmarshal(p.Data, &msg)
+	if p.Room != "$control" || msg.Op != "REVOKE" || msg.UserID != userID || msg.GuildID != testGuildID || len(msg.Rooms) != 2 {
+		t.Fatalf("published %s to %s, want REVOKE of the guild and its channel for %s", p.Data, p.Room, userID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectGuildChannels(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM channels WHERE guild_id = \$1`).WithArgs(testGuildID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+			AddRow(testChannelID, testGuildID, "general", model.ChannelText, nil, 0, time.Now()))
+}
+
+// expectGuild expects testGuildID, owned by kickedUserID, to be loaded.
+func expectGuild(mock sqlmock.Sqlmock) {
+	expectGuildMFA(mock, false)
+}
+
+func expectGuildMFA(mock sqlmock.Sqlmock, requireMFA bool) {
+	mock.ExpectQuery(`FROM guilds WHERE id = \$1`).WithArgs(testGuildID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "icon_url", "owner_id", "invite_code", "require_mfa", "created_at"}).
+			AddRow(testGuildID, "guild", nil, kickedUserID, "invite", requireMFA, time.Now()))
+}
+
+func expectMemberRole(mock sqlmock.Sqlmock, userID, role string) {
+	mock.ExpectQuery(`FROM members WHERE guild_id = \$1 AND user_id = \$2`).WithArgs(testGuildID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "guild_id", "role", "joined_at"}).
+			AddRow(userID, testGuildID, role, time.Now()))
+}
+
+func expectMemberLeave(t *testing.T, msgs <-chan *redis.Message, userID, reason string) {
+	t.Helper()
+	p := expectPublished(t, msgs, "guild:"+testGuildID, ws.EventMemberLeave)
+	var data ws.MemberLeaveData
+	json.Unmarshal(p.Data, &data)
+	if data.UserID != userID || data.Reason != reason {
+		t.Fatalf("MEMBER_LEAVE %s, want %s with reason %s", p.Data, userID, reason)
+	}
+}
+
+func TestLeaveRevokesLiveSubscriptions(t *testing.T) {
+	guilds, mock, msgs := newTestGuildService(t)
+	expectGuild(mock)
+	mock.ExpectExec(`DELETE FROM members`).WithArgs(testGuildID, testUserID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGuildChannels(mock)
+
+	if err := guilds.Leave(testUserID, testGuildID); err != nil {
+		t.Fatal(err)
+	}
+	expectRevoke(t, mock, msgs, testUserID)
+	expectMemberLeave(t, msgs, testUserID, ws.LeaveReasonLeft)
+}
+
+func TestKickRevokesLiveSubscriptions(t *testing.T) {
+	guilds, mock, msgs := newTestGuildService(t)
+	expectMemberRole(mock, testUserID, model.RoleModerator)
+	expectGuild(mock)
+	expectMemberRole(mock, kickedUserID, model.RoleMember)
+	mock.ExpectExec(`DELETE FROM members`).WithArgs(testGuildID, kickedUserID).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGuildChannels(mock)
+
+	if err := guilds.KickMember(testUserID, testGuildID, kickedUserID); err != nil {
+		t.Fatal(err)
+	}
+	expectRevoke(t, mock, msgs, kickedUserID)
+	expectPublished(t, msgs, "user:"+kickedUserID, ws.EventGuildRemove)
+	expectMemberLeave(t, msgs, kickedUserID, ws.LeaveReasonKicked)
+}
+
+func TestUpdateMemberRoleBroadcastsToGuild(t *testing.T) {
+	guilds, mock, msgs := newTestGuildService(t)
+	expectMemberRole(mock, testUserID, model.RoleOwner)
+	expectGuild(mock)
+	mock.ExpectExec(`UPDATE members SET role`).WithArgs(testGuildID, kickedUserID, model.RoleModerator).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := guilds.UpdateMemberRole(testUserID, testGuildID, kickedUserID, model.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	p := expectPublished(t, msgs, "guild:"+testGuildID, ws.EventMemberUpdate)
+	var data ws.MemberUpdateData
+	json.Unmarshal(p.Data, &data)
+	if data.UserID != kickedUserID || data.Role != model.RoleModerator {
+		t.Fatalf("MEMBER_UPDATE %s", p.Data)
+	}
+}
+
+func TestModerationRequiresGuildMFA(t *testing.T) {
+	for name, call := range map[string]func(*GuildService) error{
+		"kick": func(s *GuildService) error { return s.KickMember(testUserID, testGuildID, kickedUserID) },
+		"role": func(s *GuildService) error {
+			return s.UpdateMemberRole(testUserID, testGuildID, kickedUserID, model.RoleMember)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			guilds, mock, _ := newTestGuildService(t)
+			expectMemberRole(mock, testUserID, model.RoleAdmin)
+			expectGuildMFA(mock, true)
+			mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+
+			if err := call(guilds); !errors.Is(err, model.ErrMFARequired) {
+				t.Fatalf("got %v, want ErrMFARequired", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"pwdh-aether/internal/model"
 	"pwdh-aether/internal/repository"
@@ -16,6 +18,7 @@ type MessageService struct {
 	guilds   *repository.GuildRepository
 	channels *repository.ChannelRepository
 	hub      *ws.Hub
+	guildMFA
 }
 
 func NewMessageService(
@@ -23,6 +26,7 @@ func NewMessageService(
 	users *repository.UserRepository,
 	guilds *repository.GuildRepository,
 	channels *repository.ChannelRepository,
+	bots *repository.BotRepository,
 	hub *ws.Hub,
 ) *MessageService {
 	return &MessageService{
@@ -31,10 +35,22 @@ func NewMessageService(
 		guilds:   guilds,
 		channels: channels,
 		hub:      hub,
+		guildMFA: guildMFA{guilds: guilds, users: users, bots: bots},
 	}
 }
 
+// validateContent is shared by the REST handlers and the gateway ops.
+func validateContent(content string) error {
+	if strings.TrimSpace(content) == "" || utf8.RuneCountInString(content) > model.MaxMessageLength {
+		return model.ErrInvalidContent
+	}
+	return nil
+}
+
 func (s *MessageService) Create(userID, channelID string, req model.CreateMessageRequest) (*model.MessageResponse, error) {
+	if err := validateContent(req.Content); err != nil {
+		return nil, err
+	}
 	ch, err := s.channels.GetByID(channelID)
 	if err != nil {
 		return nil, err
@@ -65,6 +81,7 @@ func (s *MessageService) Create(userID, channelID string, req model.CreateMessag
 		Reactions:     []model.Reaction{},
 	}
 
+	s.hub.StopTyping(userID, channelID)
 	s.hub.BroadcastToRoom(channelID, ws.Event{
 		Type:   ws.EventMessageCreate,
 		Data:   resp,
@@ -89,6 +106,9 @@ func (s *MessageService) GetByChannelID(userID, channelID string, before *time.T
 }
 
 func (s *MessageService) Update(userID, messageID, content string) (*model.MessageResponse, error) {
+	if err := validateContent(content); err != nil {
+		return nil, err
+	}
 	msg, err := s.messages.GetByID(messageID)
 	if err != nil {
 		return nil, err
@@ -139,6 +159,9 @@ func (s *MessageService) Delete(userID, messageID string) error {
 		if member.Role != model.RoleOwner && member.Role != model.RoleAdmin && member.Role != model.RoleModerator {
 			return model.ErrNotAuthorized
 		}
+		if err := s.requireGuildMFA(ch.GuildID, userID); err != nil {
+			return err
+		}
 	}
 	if err := s.messages.Delete(messageID); err != nil {
 		return err
@@ -153,10 +176,20 @@ func (s *MessageService) Delete(userID, messageID string) error {
 }
 
 func (s *MessageService) AddReaction(userID, messageID, emoji string) error {
-	_, err := s.messages.GetByID(messageID)
+	if strings.TrimSpace(emoji) == "" {
+		return model.ErrInvalidEmoji
+	}
+	msg, err := s.messages.GetByID(messageID)
+	if err != nil {
+		return err
+	}
+	ch, err := s.channels.GetByID(msg.ChannelID)
 	if err != nil {
 		return err
 	}
+	if member, _ := s.guilds.IsMember(ch.GuildID, userID); !member {
+		return model.ErrNotMember
+	}
 	return s.messages.AddReaction(messageID, userID, emoji)
 }
 
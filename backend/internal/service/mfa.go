@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	// mfaTicketTTL is how long a password-verified login waits for its
+	// second factor.
+	mfaTicketTTL         = 5 * time.Minute
+	mfaTicketMaxAttempts = 5
+	recoveryCodeCount    = 10
+	// totpReplayWindow covers the current step plus the accepted skew, so a
+	// code cannot be used twice while it is still valid.
+	totpReplayWindow = 90 * time.Second
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// VerifyMFA completes a login that was answered with an MFA challenge. The
+// code may be a TOTP code or one of the user's recovery codes.
+func (s *AuthService) VerifyMFA(ticket, code string, info model.ClientInfo) (*model.TokenResponse, error) {
+	ctx := context.Background()
+	key := mfaTicketKey(ticket)
+
+	attempts, err := s.rdb.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	userID, err := s.rdb.HGet(ctx, key, "user_id").Result()
+	if err != nil || userID == "" {
+		s.rdb.Del(ctx, key)
+		return nil, model.ErrInvalidMFATicket
+	}
+	if attempts > mfaTicketMaxAttempts {
+		s.rdb.Del(ctx, key)
+		return nil, model.ErrInvalidMFATicket
+	}
+
+	if err := s.checkSecondFactor(userID, code, true); err != nil {
+		return nil, err
+	}
+	s.rdb.Del(ctx, key)
+
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(user, info)
+}
+
+func (s *AuthService) MFAStatus(userID string) (*model.MFAStatusResponse, error) {
+	_, enabled, err := s.mfa.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	resp := &model.MFAStatusResponse{TOTPEnabled: enabled}
+	if enabled {
+		if resp.RecoveryCodesRemaining, err = s.mfa.CountRecoveryCodes(userID); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// EnrollTOTP generates a new secret for the user. It only takes effect once
+// confirmed with EnableTOTP.
+func (s *AuthService) EnrollTOTP(userID string) (*model.TOTPEnrollResponse, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, model.ErrMFAAlreadyEnabled
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.cfg.TOTPIssuer,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("generate totp secret: %w", err)
+	}
+	if err := s.mfa.SetPendingTOTP(userID, key.Secret()); err != nil {
+		return nil, err
+	}
+	return &model.TOTPEnrollResponse{Secret: key.Secret(), URI: key.URL()}, nil
+}
+
+// EnableTOTP confirms the pending secret with a code from the authenticator
+// and returns the recovery codes. They are only stored hashed and cannot be
+// shown again.
+func (s *AuthService) EnableTOTP(userID, code string) (*model.RecoveryCodesResponse, error) {
+	secret, enabled, err := s.mfa.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, model.ErrMFAAlreadyEnabled
+	}
+	if secret == nil {
+		return nil, model.ErrMFANotEnabled
+	}
+	if !s.validateTOTP(userID, *secret, code) {
+		return nil, model.ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.EnableTOTP(userID, hashes); err != nil {
+		return nil, err
+	}
+	return &model.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *AuthService) DisableTOTP(userID, code string) error {
+	if err := s.checkSecondFactor(userID, code, true); err != nil {
+		return err
+	}
+	return s.mfa.DisableTOTP(userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes. It requires a TOTP
+// code, since losing the old codes is the usual reason to call it.
+func (s *AuthService) RegenerateRecoveryCodes(userID, code string) (*model.RecoveryCodesResponse, error) {
+	if err := s.checkSecondFactor(userID, code, false); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return &model.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// checkSecondFactor verifies a TOTP code, falling back to the recovery codes
+// if allowed.
+func (s *AuthService) checkSecondFactor(userID, code string, allowRecovery bool) error {
+	secret, enabled, err := s.mfa.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled || secret == nil {
+		return model.ErrMFANotEnabled
+	}
+	if s.validateTOTP(userID, *secret, code) {
+		return nil
+	}
+	if allowRecovery {
+		used, err := s.mfa.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return err
+		}
+		if used {
+			return nil
+		}
+	}
+	return model.ErrInvalidMFACode
+}
+
+func (s *AuthService) validateTOTP(userID, secret, code string) bool {
+	code = strings.TrimSpace(code)
+	ok, err := totp.ValidateCustom(code, secret, time.Now().UTC(), totpOpts)
+	if err != nil || !ok {
+		return false
+	}
+	fresh, err := s.rdb.SetNX(context.Background(), "auth:totp:used:"+userID+":"+code, 1, totpReplayWindow).Result()
+	return err == nil && fresh
+}
+
+// mfaChallenge parks a password-verified login until the second factor
+// arrives.
+func (s *AuthService) mfaChallenge(userID string) (*model.MFAChallenge, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("generate mfa ticket: %w", err)
+	}
+	ticket := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+
+	ctx := context.Background()
+	key := mfaTicketKey(ticket)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "attempts", 0)
+	pipe.Expire(ctx, key, mfaTicketTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return &model.MFAChallenge{MFARequired: true, Ticket: ticket}, nil
+}
+
+func mfaTicketKey(ticket string) string { return "auth:mfa:" + ticket }
+
+// newRecoveryCodes returns codes formatted as xxxxx-xxxxx together with the
+// hashes that are stored.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(enc.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
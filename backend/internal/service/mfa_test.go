@@ -0,0 +1,150 @@
+package service
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// sealedArg is a sqlmock argument that accepts any []byte and keeps it.
+type sealedArg struct{ value []byte }
+
+func (a *sealedArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	a.value = b
+	return ok
+}
+
+// sealedTOTPSecret returns testTOTPSecret as the repository of
+// newTestAuthService stores it.
+func sealedTOTPSecret(t *testing.T) []byte {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var sealed sealedArg
+	mock.ExpectExec(`UPDATE users SET totp_secret_encrypted`).WithArgs(testUserID, &sealed).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := repository.NewMFARepository(db, testTOTPKey).SetPendingTOTP(testUserID, testTOTPSecret); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.value
+}
+
+func expectTOTP(t *testing.T, mock sqlmock.Sqlmock, enabled bool) {
+	t.Helper()
+	mock.ExpectQuery(`SELECT totp_secret_encrypted, totp_enabled FROM users WHERE id = \$1`).WithArgs(testUserID).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_secret_encrypted", "totp_enabled"}).AddRow(sealedTOTPSecret(t), enabled))
+}
+
+func currentCode(t *testing.T) string {
+	t.Helper()
+	code, err := totp.GenerateCode(testTOTPSecret, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestSecondFactorRejectsReplayedCode(t *testing.T) {
+	auth, mock, _ := newTestAuthService(t)
+	code := currentCode(t)
+
+	expectTOTP(t, mock, true)
+	if err := auth.checkSecondFactor(testUserID, code, false); err != nil {
+		t.Fatal(err)
+	}
+	expectTOTP(t, mock, true)
+	if err := auth.checkSecondFactor(testUserID, code, false); !errors.Is(err, model.ErrInvalidMFACode) {
+		t.Fatalf("replayed code: got %v, want ErrInvalidMFACode", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecondFactorFallsBackToRecoveryCode(t *testing.T) {
+	auth, mock, _ := newTestAuthService(t)
+
+	// Recovery codes are matched however the user typed them.
+	expectTOTP(t, mock, true)
+	mock.ExpectExec(`UPDATE recovery_codes SET used_at = NOW\(\)`).WithArgs(testUserID, hashToken("abcdefghij")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := auth.checkSecondFactor(testUserID, " ABCDE-fghij ", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// A used code no longer matches, and regenerating codes takes TOTP only.
+	expectTOTP(t, mock, true)
+	mock.ExpectExec(`UPDATE recovery_codes SET used_at = NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := auth.checkSecondFactor(testUserID, "abcde-fghij", true); !errors.Is(err, model.ErrInvalidMFACode) {
+		t.Fatalf("used code: got %v, want ErrInvalidMFACode", err)
+	}
+	expectTOTP(t, mock, true)
+	if err := auth.checkSecondFactor(testUserID, "abcde-fghij", false); !errors.Is(err, model.ErrInvalidMFACode) {
+		t.Fatalf("recovery code without fallback: got %v, want ErrInvalidMFACode", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyMFALimitsAttempts(t *testing.T) {
+	auth, mock, rdb := newTestAuthService(t)
+	challenge, err := auth.mfaChallenge(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < mfaTicketMaxAttempts; i++ {
+		expectTOTP(t, mock, true)
+		mock.ExpectExec(`UPDATE recovery_codes SET used_at = NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+		if _, err := auth.VerifyMFA(challenge.Ticket, "000000", model.ClientInfo{}); !errors.Is(err, model.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: got %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+	// Even the right code no longer helps once the ticket is used up.
+	if _, err := auth.VerifyMFA(challenge.Ticket, currentCode(t), model.ClientInfo{}); !errors.Is(err, model.ErrInvalidMFATicket) {
+		t.Fatalf("got %v, want ErrInvalidMFATicket", err)
+	}
+	if n := rdb.Exists(t.Context(), mfaTicketKey(challenge.Ticket)).Val(); n != 0 {
+		t.Fatal("exhausted ticket kept")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Fatalf("code %q not formatted as xxxxx-xxxxx", code)
+		}
+		if hashToken(normalizeRecoveryCode(code)) != hashes[i] {
+			t.Fatalf("hash of %q does not match", code)
+		}
+		if seen[code] {
+			t.Fatalf("code %q issued twice", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,456 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// OIDCStateTTL is how long the user has to finish the provider login.
+	OIDCStateTTL = 10 * time.Minute
+	oidcTimeout  = 15 * time.Second
+
+	// reauthMaxAge is how long ago the provider login behind a re-auth may
+	// have been, and how long the resulting reauth token stays valid.
+	reauthMaxAge = 5 * time.Minute
+
+	oidcModeLogin  = "login"
+	oidcModeLink   = "link"
+	oidcModeReauth = "reauth"
+)
+
+// oidcState is kept in Redis between the redirect to the provider and the
+// callback. It carries the PKCE verifier and nonce, the hash of the binding
+// cookie handed to the browser that started it, and for linking and
+// re-authentication the user who started it.
+type oidcState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	Binding  string `json:"binding"`
+	Mode     string `json:"mode"`
+	UserID   string `json:"user_id,omitempty"`
+}
+
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	AuthTime          int64  `json:"auth_time"`
+}
+
+type oidcProvider struct {
+	cfg      config.OIDCProvider
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// discover fetches the issuer metadata on first use, so a provider that is
+// down at startup does not keep the server from starting.
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.provider != nil {
+		return p.provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery %s: %w", p.cfg.ID, err)
+	}
+	p.provider = provider
+	return provider, nil
+}
+
+// OIDCService logs users in through external OpenID Connect providers with
+// the authorization code flow and PKCE, and links provider identities to
+// existing accounts.
+type OIDCService struct {
+	providers  map[string]*oidcProvider
+	users      *repository.UserRepository
+	identities *repository.IdentityRepository
+	auth       *AuthService
+	rdb        *redis.Client
+	cfg        *config.Config
+}
+
+func NewOIDCService(
+	users *repository.UserRepository,
+	identities *repository.IdentityRepository,
+	auth *AuthService,
+	rdb *redis.Client,
+	cfg *config.Config,
+) *OIDCService {
+	providers := make(map[string]*oidcProvider)
+	for _, p := range cfg.OIDCProviders {
+		providers[p.ID] = &oidcProvider{cfg: p}
+	}
+	return &OIDCService{providers: providers, users: users, identities: identities, auth: auth, rdb: rdb, cfg: cfg}
+}
+
+func (s *OIDCService) Providers() []model.OIDCProviderResponse {
+	resp := []model.OIDCProviderResponse{}
+	for _, p := range s.cfg.OIDCProviders {
+		resp = append(resp, model.OIDCProviderResponse{ID: p.ID, Name: p.Name})
+	}
+	return resp
+}
+
+// AuthorizeLogin returns the provider URL to send the browser to for login
+// or registration.
+func (s *OIDCService) AuthorizeLogin(providerID string) (*model.OIDCAuthorizeResponse, error) {
+	return s.authorize(providerID, oidcState{Mode: oidcModeLogin})
+}
+
+// AuthorizeLink is AuthorizeLogin for adding the provider to userID.
+func (s *OIDCService) AuthorizeLink(userID, providerID string) (*model.OIDCAuthorizeResponse, error) {
+	return s.authorize(providerID, oidcState{Mode: oidcModeLink, UserID: userID})
+}
+
+// AuthorizeReauth is AuthorizeLogin for confirming userID before a sensitive
+// change. The provider is asked to log the user in again rather than reuse
+// its own session.
+func (s *OIDCService) AuthorizeReauth(userID, providerID string) (*model.OIDCAuthorizeResponse, error) {
+	return s.authorize(providerID, oidcState{Mode: oidcModeReauth, UserID: userID},
+		oauth2.SetAuthURLParam("prompt", "login"), oauth2.SetAuthURLParam("max_age", "0"))
+}
+
+// authorize stores the state for the callback and returns the provider URL
+// together with the binding the handler sets as a cookie, so only the
+// browser that started the flow can finish it.
+func (s *OIDCService) authorize(providerID string, state oidcState, opts ...oauth2.AuthCodeOption) (*model.OIDCAuthorizeResponse, error) {
+	p, ok := s.providers[providerID]
+	if !ok {
+		return nil, model.ErrUnknownProvider
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if state.Nonce, err = randomToken(); err != nil {
+		return nil, err
+	}
+	binding, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	state.Binding = hashToken(binding)
+	state.Provider = providerID
+	state.Verifier = oauth2.GenerateVerifier()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rdb.Set(ctx, oidcStateKey(key), data, OIDCStateTTL).Err(); err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, oauth2.S256ChallengeOption(state.Verifier), oidc.Nonce(state.Nonce))
+	url := s.oauthConfig(p, provider).AuthCodeURL(key, opts...)
+	return &model.OIDCAuthorizeResponse{URL: url, Binding: binding}, nil
+}
+
+// Login finishes a provider login. A known identity logs into its account;
+// an unknown one registers a new account without a password. An unknown
+// identity whose email already belongs to an account is refused rather than
+// linked, since the provider may not have verified the address.
+func (s *OIDCService) Login(req model.OIDCCallbackRequest, info model.ClientInfo) (*model.TokenResponse, *model.MFAChallenge, error) {
+	state, subject, claims, err := s.exchange(req, oidcModeLogin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user *model.User
+	identity, err := s.identities.GetBySubject(state.Provider, subject)
+	switch {
+	case err == nil:
+		if user, err = s.users.GetByID(identity.UserID); err != nil {
+			return nil, nil, err
+		}
+	case errors.Is(err, model.ErrIdentityNotFound):
+		if user, err = s.register(state.Provider, subject, claims); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, err
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := s.auth.mfaChallenge(user.ID)
+		return nil, challenge, err
+	}
+	resp, err := s.auth.startSession(user, info)
+	return resp, nil, err
+}
+
+// Link finishes adding a provider identity to the account that started it.
+func (s *OIDCService) Link(userID string, req model.OIDCCallbackRequest) (*model.Identity, error) {
+	state, subject, claims, err := s.exchange(req, oidcModeLink)
+	if err != nil {
+		return nil, err
+	}
+	if state.UserID != userID {
+		return nil, model.ErrInvalidOIDCState
+	}
+	identity := &model.Identity{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Provider: state.Provider,
+		Subject:  subject,
+		Email:    optional(claims.Email),
+	}
+	if err := s.identities.Create(identity); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Reauth finishes a provider login that confirms userID. The identity must
+// belong to the account and the provider must report a login within
+// reauthMaxAge. The returned token stands in for the password of an account
+// without one, once, in ChangePassword or ScheduleDeletion.
+func (s *OIDCService) Reauth(userID string, req model.OIDCCallbackRequest) (*model.ReauthResponse, error) {
+	state, subject, claims, err := s.exchange(req, oidcModeReauth)
+	if err != nil {
+		return nil, err
+	}
+	if state.UserID != userID {
+		return nil, model.ErrInvalidOIDCState
+	}
+	identity, err := s.identities.GetBySubject(state.Provider, subject)
+	if errors.Is(err, model.ErrIdentityNotFound) || err == nil && identity.UserID != userID {
+		return nil, model.ErrOIDCLoginFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > reauthMaxAge {
+		log.Printf("oidc %s: reauth: stale auth_time %d for user=%s", state.Provider, claims.AuthTime, userID)
+		return nil, model.ErrReauthRequired
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.rdb.Set(context.Background(), reauthTokenKey(token), userID, reauthMaxAge).Err(); err != nil {
+		return nil, err
+	}
+	return &model.ReauthResponse{ReauthToken: token, ExpiresIn: int64(reauthMaxAge.Seconds())}, nil
+}
+
+func (s *OIDCService) Identities(userID string) ([]model.Identity, error) {
+	return s.identities.GetByUserID(userID)
+}
+
+// Unlink removes an identity unless the account would be left without a
+// password or another identity to log in with.
+func (s *OIDCService) Unlink(userID, identityID string) error {
+	if _, err := uuid.Parse(identityID); err != nil {
+		return model.ErrIdentityNotFound
+	}
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash == "" {
+		identities, err := s.identities.GetByUserID(userID)
+		if err != nil {
+			return err
+		}
+		if len(identities) <= 1 {
+			return model.ErrLastLoginMethod
+		}
+	}
+	return s.identities.Delete(identityID, userID)
+}
+
+// exchange redeems the state, checks it was started by the same browser,
+// trades the code for tokens with the PKCE verifier and verifies the ID
+// token. The state is gone afterwards, whether the exchange succeeded or not.
+func (s *OIDCService) exchange(req model.OIDCCallbackRequest, mode string) (*oidcState, string, *oidcClaims, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+	defer cancel()
+
+	data, err := s.rdb.GetDel(ctx, oidcStateKey(req.State)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, "", nil, model.ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var state oidcState
+	if err := json.Unmarshal(data, &state); err != nil || state.Mode != mode {
+		return nil, "", nil, model.ErrInvalidOIDCState
+	}
+	if req.Binding == "" || subtle.ConstantTimeCompare([]byte(hashToken(req.Binding)), []byte(state.Binding)) != 1 {
+		return nil, "", nil, model.ErrInvalidOIDCState
+	}
+	p, ok := s.providers[state.Provider]
+	if !ok {
+		return nil, "", nil, model.ErrUnknownProvider
+	}
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	token, err := s.oauthConfig(p, provider).Exchange(ctx, req.Code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		log.Printf("oidc %s: exchange: %v", state.Provider, err)
+		return nil, "", nil, model.ErrOIDCLoginFailed
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		log.Printf("oidc %s: no id_token in token response", state.Provider)
+		return nil, "", nil, model.ErrOIDCLoginFailed
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != state.Nonce {
+		log.Printf("oidc %s: verify id token: %v", state.Provider, err)
+		return nil, "", nil, model.ErrOIDCLoginFailed
+	}
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("oidc %s: claims: %v", state.Provider, err)
+		return nil, "", nil, model.ErrOIDCLoginFailed
+	}
+	return &state, idToken.Subject, &claims, nil
+}
+
+func (s *OIDCService) register(providerID, subject string, claims *oidcClaims) (*model.User, error) {
+	if claims.Email == "" {
+		return nil, model.ErrOIDCEmailRequired
+	}
+	if taken, err := s.users.EmailExists(claims.Email); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, model.ErrIdentityEmailInUse
+	}
+	username, err := s.uniqueUsername(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		ID:       uuid.New().String(),
+		Username: username,
+		Email:    claims.Email,
+	}
+	if err := s.users.Create(user); err != nil {
+		return nil, err
+	}
+	if err := s.identities.Create(&model.Identity{
+		ID:       uuid.New().String(),
+		UserID:   user.ID,
+		Provider: providerID,
+		Subject:  subject,
+		Email:    optional(claims.Email),
+	}); err != nil {
+		return nil, err
+	}
+
+	if claims.EmailVerified {
+		if _, err := s.users.MarkEmailVerified(user.ID, user.Email); err != nil {
+			log.Printf("oidc register: verify email: user=%s: %v", user.ID, err)
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	} else if err := s.auth.sendVerification(user); err != nil {
+		log.Printf("verification mail: user=%s: %v", user.ID, err)
+	}
+	return user, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// uniqueUsername derives a free username from the provider's claims.
+func (s *OIDCService) uniqueUsername(claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Name
+	}
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		taken, err := s.users.UsernameExists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		suffix, err := randomToken()
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + strings.ToLower(suffix[:6])
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+func (s *OIDCService) oauthConfig(p *oidcProvider, provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  s.cfg.OIDCRedirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+func oidcStateKey(state string) string { return "auth:oidc:" + hashToken(state) }
+
+func reauthTokenKey(token string) string { return "auth:reauth:" + hashToken(token) }
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/config"
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testProvider = "stub"
+	testClientID = "aether-test"
+	testSubject  = "provider-subject-1"
+)
+
+// stubIssuer is an OpenID provider with discovery, JWKS and a token endpoint.
+// approve stands in for the user logging in at the provider.
+type stubIssuer struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]stubGrant
+}
+
+type stubGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &stubIssuer{key: key, grants: make(map[string]stubGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                i.srv.URL,
+			"authorization_endpoint":                i.srv.URL + "/authorize",
+			"token_endpoint":                        i.srv.URL + "/token",
+			"jwks_uri":                              i.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "test", "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		i.mu.Lock()
+		grant, ok := i.grants[r.PostForm.Get("code")]
+		delete(i.grants, r.PostForm.Get("code"))
+		i.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "provider-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     grant.idToken,
+		})
+	})
+	i.srv = httptest.NewServer(mux)
+	t.Cleanup(i.srv.Close)
+	return i
+}
+
+// approve reads the authorize URL like the provider would and hands out a
+// code for an ID token with claims. Claims the test does not set get the
+// values a well-behaved provider would use.
+func (i *stubIssuer) approve(t *testing.T, authURL string, claims jwt.MapClaims) model.OIDCCallbackRequest {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	defaults := jwt.MapClaims{
+		"iss":   i.srv.URL,
+		"aud":   testClientID,
+		"sub":   testSubject,
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+		"email": "alice@example.com",
+	}
+	for k, v := range claims {
+		defaults[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, defaults)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.mu.Lock()
+	i.grants[code] = stubGrant{challenge: q.Get("code_challenge"), idToken: idToken}
+	i.mu.Unlock()
+	return model.OIDCCallbackRequest{Code: code, State: q.Get("state")}
+}
+
+func newTestOIDCService(t *testing.T) (*OIDCService, *AuthService, sqlmock.Sqlmock, *stubIssuer) {
+	t.Helper()
+	auth, db, mock, rdb := newTestAuthServiceDB(t)
+	issuer := newStubIssuer(t)
+	cfg := &config.Config{
+		OIDCRedirectURL: "http://localhost:3000/oauth/callback",
+		OIDCProviders: []config.OIDCProvider{{
+			ID: testProvider, Name: "Stub", Issuer: issuer.srv.URL,
+			ClientID: testClientID, ClientSecret: "secret", Scopes: []string{"openid", "email"},
+		}},
+	}
+	oidc := NewOIDCService(repository.NewUserRepository(db), repository.NewIdentityRepository(db), auth, rdb, cfg)
+	return oidc, auth, mock, issuer
+}
+
+func identityRow(userID string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "provider", "subject", "email", "created_at"}).
+		AddRow("5e4d3c2b-1a09-4f8e-9d7c-6b5a4f3e2d1c", userID, testProvider, testSubject, nil, time.Now())
+}
+
+// start runs authorize and approve, and returns the callback request with
+// the binding the browser would send back.
+func start(t *testing.T, issuer *stubIssuer, resp *model.OIDCAuthorizeResponse, err error, claims jwt.MapClaims) model.OIDCCallbackRequest {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Binding == "" {
+		t.Fatal("no binding for the cookie")
+	}
+	req := issuer.approve(t, resp.URL, claims)
+	req.Binding = resp.Binding
+	return req
+}
+
+func expectKnownIdentityLogin(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM user_identities`).WithArgs(testProvider, testSubject).WillReturnRows(identityRow(testUserID))
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+	mock.ExpectExec(`INSERT INTO sessions`).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestOIDCLoginWithKnownIdentity(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLogin(testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	expectKnownIdentityLogin(mock)
+	tokens, challenge, err := oidc.Login(req, model.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge != nil || tokens.AccessToken == "" || tokens.User.ID != testUserID {
+		t.Fatalf("got %+v / %+v, want tokens for %s", tokens, challenge, testUserID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLinkAddsIdentityToStartingUser(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLink(testUserID, testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	mock.ExpectQuery(`INSERT INTO user_identities`).
+		WithArgs(sqlmock.AnyArg(), testUserID, testProvider, testSubject, "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+	identity, err := oidc.Link(testUserID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != testUserID || identity.Subject != testSubject {
+		t.Fatalf("linked %+v", identity)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLinkRefusesOtherUser(t *testing.T) {
+	oidc, _, _, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLink(testUserID, testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	if _, err := oidc.Link("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", req); !errors.Is(err, model.ErrInvalidOIDCState) {
+		t.Fatalf("got %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCLoginRejectsNonceMismatch(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLogin(testProvider)
+	req := start(t, issuer, resp, err, jwt.MapClaims{"nonce": "someone-elses-nonce"})
+
+	if _, _, err := oidc.Login(req, model.ClientInfo{}); !errors.Is(err, model.ErrOIDCLoginFailed) {
+		t.Fatalf("got %v, want ErrOIDCLoginFailed", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCStateCannotBeReplayed(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLogin(testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	expectKnownIdentityLogin(mock)
+	if _, _, err := oidc.Login(req, model.ClientInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := oidc.Login(req, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidOIDCState) {
+		t.Fatalf("replay: got %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestOIDCLoginRefusesEmailOfExistingAccount(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLogin(testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	mock.ExpectQuery(`FROM user_identities`).WithArgs(testProvider, testSubject).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`FROM users WHERE`).WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	if _, _, err := oidc.Login(req, model.ClientInfo{}); !errors.Is(err, model.ErrIdentityEmailInUse) {
+		t.Fatalf("got %v, want ErrIdentityEmailInUse", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCStateIsBoundToStartingBrowser(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeLogin(testProvider)
+	req := start(t, issuer, resp, err, nil)
+
+	// An attacker's state posted from the victim's browser arrives with the
+	// victim's cookie, or none.
+	for _, binding := range []string{"", "victims-binding"} {
+		forged := req
+		forged.Binding = binding
+		if _, _, err := oidc.Login(forged, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidOIDCState) {
+			t.Fatalf("binding %q: got %v, want ErrInvalidOIDCState", binding, err)
+		}
+	}
+	// The failed attempt used the state up.
+	if _, _, err := oidc.Login(req, model.ClientInfo{}); !errors.Is(err, model.ErrInvalidOIDCState) {
+		t.Fatalf("got %v after a forged callback, want ErrInvalidOIDCState", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCReauthConfirmsAccountWithoutPassword(t *testing.T) {
+	oidc, auth, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeReauth(testUserID, testProvider)
+	if err == nil {
+		u, _ := url.Parse(resp.URL)
+		if q := u.Query(); q.Get("prompt") != "login" || q.Get("max_age") != "0" {
+			t.Fatalf("authorize URL %s does not force a new login", resp.URL)
+		}
+	}
+	req := start(t, issuer, resp, err, jwt.MapClaims{"auth_time": time.Now().Unix()})
+
+	mock.ExpectQuery(`FROM user_identities`).WithArgs(testProvider, testSubject).WillReturnRows(identityRow(testUserID))
+	reauth, err := oidc.Reauth(testUserID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+	if err := auth.Reauthenticate(testUserID, "", "", reauth.ReauthToken); err != nil {
+		t.Fatalf("reauth token refused: %v", err)
+	}
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+	if err := auth.Reauthenticate(testUserID, "", "", reauth.ReauthToken); !errors.Is(err, model.ErrReauthRequired) {
+		t.Fatalf("second use: got %v, want ErrReauthRequired", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCReauthRejectsStaleLogin(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeReauth(testUserID, testProvider)
+	req := start(t, issuer, resp, err, jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()})
+
+	mock.ExpectQuery(`FROM user_identities`).WithArgs(testProvider, testSubject).WillReturnRows(identityRow(testUserID))
+	if _, err := oidc.Reauth(testUserID, req); !errors.Is(err, model.ErrReauthRequired) {
+		t.Fatalf("got %v, want ErrReauthRequired", err)
+	}
+}
+
+func TestOIDCReauthRejectsIdentityOfOtherAccount(t *testing.T) {
+	oidc, _, mock, issuer := newTestOIDCService(t)
+	resp, err := oidc.AuthorizeReauth(testUserID, testProvider)
+	req := start(t, issuer, resp, err, jwt.MapClaims{"auth_time": time.Now().Unix()})
+
+	mock.ExpectQuery(`FROM user_identities`).WithArgs(testProvider, testSubject).
+		WillReturnRows(identityRow("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"))
+	if _, err := oidc.Reauth(testUserID, req); !errors.Is(err, model.ErrOIDCLoginFailed) {
+		t.Fatalf("got %v, want ErrOIDCLoginFailed", err)
+	}
+}
+
+func TestReauthenticateWithoutPasswordNeedsToken(t *testing.T) {
+	auth, mock, rdb := newTestAuthService(t)
+
+	// A token issued for someone else does not count either.
+	rdb.Set(t.Context(), reauthTokenKey("other-users-token"), "9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d", time.Minute)
+	for _, token := range []string{"", "other-users-token"} {
+		mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUserID).WillReturnRows(userRow())
+		if err := auth.Reauthenticate(testUserID, "", "", token); !errors.Is(err, model.ErrReauthRequired) {
+			t.Fatalf("token %q: got %v, want ErrReauthRequired", token, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// Room IDs are the bare channel ID for channel rooms and carry a prefix for
+// everything else.
+const (
+	guildRoomPrefix = "guild:"
+	dmRoomPrefix    = "dm:"
+	userRoomPrefix  = "user:"
+)
+
+const (
+	ErrCodeForbidden      = "FORBIDDEN"
+	ErrCodeNotFound       = "NOT_FOUND"
+	ErrCodeInvalidPayload = "INVALID_PAYLOAD"
+	ErrCodeInternal       = "INTERNAL"
+	ErrCodeLimitExceeded  = "LIMIT_EXCEEDED"
+)
+
+type ErrorData struct {
+	Op      string `json:"op"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RoomID  string `json:"room_id,omitempty"`
+	Nonce   string `json:"nonce,omitempty"`
+}
+
+// Authorize checks that userID may receive the events of roomID.
+func (h *Hub) Authorize(userID, roomID string) error {
+	switch {
+	case strings.HasPrefix(roomID, userRoomPrefix):
+		if strings.TrimPrefix(roomID, userRoomPrefix) != userID {
+			return model.ErrNotAuthorized
+		}
+		return nil
+
+	case strings.HasPrefix(roomID, guildRoomPrefix):
+		return h.requireGuildMember(strings.TrimPrefix(roomID, guildRoomPrefix), userID)
+
+	case strings.HasPrefix(roomID, dmRoomPrefix):
+		convID := strings.TrimPrefix(roomID, dmRoomPrefix)
+		if _, err := uuid.Parse(convID); err != nil {
+			return model.ErrConversationNotFound
+		}
+		member, err := h.convs.IsMember(convID, userID)
+		if err != nil {
+			return err
+		}
+		if !member {
+			return model.ErrNotConversationMember
+		}
+		return nil
+
+	default:
+		if _, err := uuid.Parse(roomID); err != nil {
+			return model.ErrChannelNotFound
+		}
+		ch, err := h.channels.GetByID(roomID)
+		if err != nil {
+			return err
+		}
+		return h.requireGuildMember(ch.GuildID, userID)
+	}
+}
+
+func (h *Hub) requireGuildMember(guildID, userID string) error {
+	if _, err := uuid.Parse(guildID); err != nil {
+		return model.ErrGuildNotFound
+	}
+	member, err := h.guilds.IsMember(guildID, userID)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return model.ErrNotMember
+	}
+	return nil
+}
+
+func errorData(op, roomID string, err error) ErrorData {
+	data := ErrorData{Op: op, RoomID: roomID, Message: err.Error()}
+	switch {
+	case errors.Is(err, model.ErrInvalidContent), errors.Is(err, model.ErrInvalidEmoji):
+		data.Code = ErrCodeInvalidPayload
+	case errors.Is(err, model.ErrNotMember), errors.Is(err, model.ErrNotConversationMember),
+		errors.Is(err, model.ErrNotAuthorized):
+		data.Code = ErrCodeForbidden
+	case errors.Is(err, model.ErrChannelNotFound), errors.Is(err, model.ErrGuildNotFound),
+		errors.Is(err, model.ErrConversationNotFound), errors.Is(err, model.ErrMessageNotFound):
+		data.Code = ErrCodeNotFound
+	default:
+		log.Printf("%s %s: %v", op, roomID, err)
+		data.Code = ErrCodeInternal
+		data.Message = "request failed"
+	}
+	return data
+}
@@ -0,0 +1,136 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testGuild   = "5d1f6a0e-6c1b-4f8e-9a57-0f4c2d8b7e21"
+	testChannel = "7e6d5c4b-3a29-4f18-8e07-d6c5b4a39281"
+	otherUser   = "1c2d3e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+)
+
+// awaitRedisSubscribers waits until n hubs sharing rdb have subscribed their
+// broker to each of roomIDs.
+func awaitRedisSubscribers(t *testing.T, rdb *redis.Client, n int64, roomIDs ...string) {
+	t.Helper()
+	for _, roomID := range roomIDs {
+		channel := redisChannelPrefix + roomID
+		waitFor(t, "redis subscription to "+roomID, func() bool {
+			counts, err := rdb.PubSubNumSub(context.Background(), channel).Result()
+			return err == nil && counts[channel] >= n
+		})
+	}
+}
+
+func expectIsMember(mock sqlmock.Sqlmock, member bool) {
+	mock.ExpectQuery(`FROM members WHERE guild_id = \$1 AND user_id = \$2`).WithArgs(testGuild, testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(member))
+}
+
+func channelRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+		AddRow(testChannel, testGuild, "general", "text", nil, 0, time.Now())
+}
+
+// expectOnlyUserEvent publishes a marker to the user's own room and checks
+// it is the next frame, i.e. nothing from the rooms under test came first.
+func expectOnlyUserEvent(t *testing.T, h *Hub, c *Client) {
+	t.Helper()
+	h.BroadcastToUser(testUser, Event{Type: EventGuildRemove, Data: map[string]string{"marker": "user"}})
+	if f := nextFrame(t, c); f.Type != EventGuildRemove {
+		t.Fatalf("got %s %s before the marker", f.Type, f.Data)
+	}
+}
+
+func TestSubscribeRejectsNonMember(t *testing.T) {
+	for op, room := range map[string]string{"SUBSCRIBE_GUILD": testRoom, "SUBSCRIBE": testChannel} {
+		t.Run(op, func(t *testing.T) {
+			h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+			c := connectUser(t, h, testUser)
+			if room == testChannel {
+				mock.ExpectQuery(`FROM channels WHERE id = \$1`).WithArgs(testChannel).WillReturnRows(channelRows())
+			}
+			expectIsMember(mock, false)
+
+			c.subscribe(op, room)
+			f := nextFrame(t, c)
+			var data ErrorData
+			json.Unmarshal(f.Data, &data)
+			if f.Type != EventError || data.Code != ErrCodeForbidden || data.Op != op || data.RoomID != room {
+				t.Fatalf("got %s %s, want a FORBIDDEN error for %s", f.Type, f.Data, room)
+			}
+			if inRoom(h, c, room) {
+				t.Fatal("non-member joined the room")
+			}
+
+			h.BroadcastToRoom(room, Event{Type: EventMessageCreate, Data: map[string]string{"content": "secret"}})
+			expectOnlyUserEvent(t, h, c)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSubscribeAdmitsMember(t *testing.T) {
+	h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+	c := connectUser(t, h, testUser)
+	expectIsMember(mock, true)
+
+	c.subscribe("SUBSCRIBE_GUILD", testRoom)
+	h.BroadcastToRoom(testRoom, Event{Type: EventMemberJoin, Data: map[string]string{"guild_id": testGuild}})
+	if f := nextFrame(t, c); f.Type != EventMemberJoin {
+		t.Fatalf("got %s %s, want the guild event", f.Type, f.Data)
+	}
+}
+
+func TestRevokeGuildUnsubscribesOnEveryNode(t *testing.T) {
+	rdb := newTestRedis(t)
+	nodeA, _ := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	nodeB, mockB := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	awaitRedisSubscribers(t, rdb, 2, controlRoom)
+
+	// The revoked member and another member on node A, both in the guild
+	// and its channel.
+	c := connectUser(t, nodeA, testUser)
+	other := connectUser(t, nodeA, otherUser)
+	for _, room := range []string{testRoom, testChannel} {
+		nodeA.Subscribe(c.session.Load(), room)
+		nodeA.Subscribe(other.session.Load(), room)
+	}
+	awaitRedisSubscribers(t, rdb, 1, userRoomPrefix+testUser, testRoom, testChannel)
+
+	// The kick or leave is handled on node B.
+	mockB.ExpectQuery(`FROM channels WHERE guild_id = \$1`).WithArgs(testGuild).WillReturnRows(channelRows())
+	nodeB.RevokeGuild(testGuild, testUser)
+
+	f := nextFrame(t, c)
+	var data struct {
+		GuildID string   `json:"guild_id"`
+		Rooms   []string `json:"rooms"`
+	}
+	json.Unmarshal(f.Data, &data)
+	if f.Type != EventSubscriptionRevoked || data.GuildID != testGuild || len(data.Rooms) != 2 {
+		t.Fatalf("got %s %s, want SUBSCRIPTION_REVOKED for the guild and its channel", f.Type, f.Data)
+	}
+	if inRoom(nodeA, c, testRoom) || inRoom(nodeA, c, testChannel) {
+		t.Fatal("revoked member still in the guild's rooms")
+	}
+	if !inRoom(nodeA, other, testRoom) || !inRoom(nodeA, other, testChannel) {
+		t.Fatal("revoke removed another member")
+	}
+
+	nodeB.BroadcastToRoom(testChannel, Event{Type: EventMessageCreate, Data: map[string]string{"content": "after"}})
+	nodeB.BroadcastToRoom(testRoom, Event{Type: EventMemberLeave, Data: map[string]string{"guild_id": testGuild}})
+	expectOnlyUserEvent(t, nodeB, c)
+	if err := mockB.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
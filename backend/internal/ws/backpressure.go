@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CloseSlowConsumer closes a connection that could not keep up with its
+// events. Nothing it missed is lost, so clients should reconnect and RESUME.
+const CloseSlowConsumer = 4009
+
+// backpressure holds the per-client state of the slow-consumer policy.
+type backpressure struct {
+	done      chan struct{}
+	closeOnce sync.Once
+	slow      atomic.Bool
+	// unsent counts the frames queued in send and not yet written, which
+	// includes the one a pump took off the queue and is writing.
+	unsent atomic.Int64
+}
+
+// GatewayStats is a point-in-time view of the hub for monitoring.
+type GatewayStats struct {
+	Connections   int               `json:"connections"`
+	Rooms         int               `json:"rooms"`
+	SlowConsumers uint64            `json:"slow_consumers"`
+	DroppedFrames map[string]uint64 `json:"dropped_frames"`
+}
+
+// enqueue hands a frame to the WritePump. A client whose send buffer is full
+// is marked as a slow consumer: nothing is queued for it any more and the
+// connection is closed with CloseSlowConsumer. recorded tells whether the
+// frame sits in a replay buffer, so a RESUME can still deliver it; only
+// frames that are not are counted as dropped, by the kind of roomID. Direct
+// replies pass "".
+func (c *Client) enqueue(roomID string, f outFrame, recorded bool) {
+	if !c.slow.Load() {
+		c.unsent.Add(1)
+		select {
+		case c.send <- f:
+			return
+		default:
+			c.unsent.Add(-1)
+		}
+	}
+
+	if !recorded {
+		c.hub.countDrop(roomID)
+	}
+	if c.slow.CompareAndSwap(false, true) {
+		c.hub.slowConsumers.Add(1)
+		log.Printf("WebSocket slow consumer: user=%s", c.UserID)
+		go c.closeWith(CloseSlowConsumer, "slow consumer")
+	}
+}
+
+// stop tells the WritePump that the client is unregistered. It is safe to
+// call more than once, and unlike closing send it cannot race with enqueue.
+func (c *Client) stop() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+// roomKind names the kind of a room for the drop counters, so they neither
+// grow with the number of rooms nor expose room IDs.
+func roomKind(roomID string) string {
+	switch {
+	case roomID == "":
+		return "direct"
+	case strings.HasPrefix(roomID, userRoomPrefix):
+		return "user"
+	case strings.HasPrefix(roomID, guildRoomPrefix):
+		return "guild"
+	case strings.HasPrefix(roomID, dmRoomPrefix):
+		return "dm"
+	default:
+		return "channel"
+	}
+}
+
+func (h *Hub) countDrop(roomID string) {
+	h.dropsMu.Lock()
+	h.drops[roomKind(roomID)]++
+	h.dropsMu.Unlock()
+}
+
+func (h *Hub) Stats() GatewayStats {
+	stats := GatewayStats{
+		SlowConsumers: h.slowConsumers.Load(),
+		DroppedFrames: make(map[string]uint64),
+	}
+	h.mu.RLock()
+	stats.Connections = len(h.clients)
+	stats.Rooms = len(h.rooms)
+	h.mu.RUnlock()
+
+	h.dropsMu.Lock()
+	for kind, n := range h.drops {
+		stats.DroppedFrames[kind] = n
+	}
+	h.dropsMu.Unlock()
+	return stats
+}
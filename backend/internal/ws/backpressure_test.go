@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"testing"
+)
+
+// overflow fills the send buffer of c and publishes n more frames to its
+// user room.
+func overflow(t *testing.T, h *Hub, c *Client, n int) {
+	t.Helper()
+	for i := 0; i < cap(c.send)+n; i++ {
+		publish(h, i)
+	}
+	waitFor(t, "slow consumer", c.slow.Load)
+}
+
+func TestSlowConsumerDropsAreCountedByRoomKind(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c, s := connectTestClient(t, h)
+
+	overflow(t, h, c, 3)
+	waitFor(t, "all frames", func() bool { return sessionSeq(s) == int64(cap(c.send)+3) })
+
+	stats := h.Stats()
+	if stats.SlowConsumers != 1 {
+		t.Fatalf("%d slow consumers, want 1", stats.SlowConsumers)
+	}
+	if len(stats.DroppedFrames) != 1 || stats.DroppedFrames["user"] != 3 {
+		t.Fatalf("dropped %v, want 3 user frames", stats.DroppedFrames)
+	}
+	select {
+	case <-c.hangup:
+	default:
+		t.Fatal("slow consumer not closed")
+	}
+}
+
+func TestRecordedFramesAreNotCountedAsDropped(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), newTestRedis(t))
+	c, s := connectTestClient(t, h)
+
+	overflow(t, h, c, 3)
+	waitFor(t, "all frames", func() bool { return sessionSeq(s) == int64(cap(c.send)+3) })
+
+	if dropped := h.Stats().DroppedFrames; len(dropped) != 0 {
+		t.Fatalf("counted %v as dropped although the replay buffer holds them", dropped)
+	}
+}
+
+func TestRoomKind(t *testing.T) {
+	for roomID, want := range map[string]string{
+		"":                         "direct",
+		userRoomPrefix + testUser:  "user",
+		guildRoomPrefix + testUser: "guild",
+		dmRoomPrefix + testUser:    "dm",
+		testUser:                   "channel",
+	} {
+		if got := roomKind(roomID); got != want {
+			t.Errorf("roomKind(%q) = %q, want %q", roomID, got, want)
+		}
+	}
+}
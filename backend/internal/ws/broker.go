@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+)
+
+// Broker carries published frames between hub instances. A hub receives the
+// frames of the control room and of every room it subscribed to, including
+// the ones it published itself, and fans them out to its local members.
+type Broker interface {
+	Publish(roomID string, data []byte) error
+	// Subscribe and Unsubscribe tell the broker which rooms this node has
+	// local members in. The hub calls them from a single goroutine. Frames
+	// published after Subscribe returned must reach this node.
+	Subscribe(roomID string) error
+	Unsubscribe(roomID string) error
+	// Run delivers incoming frames to handler until the broker is closed.
+	// The handler is always called from a single goroutine.
+	Run(handler func(roomID string, data []byte))
+	Close() error
+}
+
+var ErrBrokerClosed = errors.New("broker closed")
+
+type brokerMessage struct {
+	roomID string
+	data   []byte
+}
+
+// MemoryBroker delivers frames inside the process. It is meant for single
+// node deployments and tests and needs no external service.
+type MemoryBroker struct {
+	messages chan brokerMessage
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		messages: make(chan brokerMessage, 1024),
+		closed:   make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) Publish(roomID string, data []byte) error {
+	select {
+	case <-b.closed:
+		return ErrBrokerClosed
+	case b.messages <- brokerMessage{roomID: roomID, data: data}:
+		return nil
+	}
+}
+
+// Subscribe is a no-op: every frame published in this process is already
+// local, and the hub drops frames for rooms without members.
+func (b *MemoryBroker) Subscribe(roomID string) error { return nil }
+
+func (b *MemoryBroker) Unsubscribe(roomID string) error { return nil }
+
+func (b *MemoryBroker) Run(handler func(roomID string, data []byte)) {
+	for {
+		select {
+		case <-b.closed:
+			return
+		case msg := <-b.messages:
+			handler(msg.roomID, msg.data)
+		}
+	}
+}
+
+func (b *MemoryBroker) Close() error {
+	b.once.Do(func() { close(b.closed) })
+	return nil
+}
@@ -0,0 +1,89 @@
+package ws
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+const natsSubjectPrefix = "ws."
+
+// NATSBroker fans frames out through a NATS server. Room IDs never contain a
+// '.', so each one maps onto a single subject token below "ws.".
+type NATSBroker struct {
+	conn     *nats.Conn
+	messages chan *nats.Msg
+	subs     map[string]*nats.Subscription
+	mu       sync.Mutex
+}
+
+func NewNATSBroker(url string) (*NATSBroker, error) {
+	conn, err := nats.Connect(url, nats.Name("pwdh-aether-gateway"))
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	b := &NATSBroker{
+		conn:     conn,
+		messages: make(chan *nats.Msg, 1024),
+		subs:     make(map[string]*nats.Subscription),
+	}
+	if err := b.Subscribe(controlRoom); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe: %w", err)
+	}
+	return b, nil
+}
+
+func (b *NATSBroker) Publish(roomID string, data []byte) error {
+	return b.conn.Publish(natsSubject(roomID), data)
+}
+
+func (b *NATSBroker) Subscribe(roomID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[roomID]; ok {
+		return nil
+	}
+	sub, err := b.conn.ChanSubscribe(natsSubject(roomID), b.messages)
+	if err != nil {
+		return err
+	}
+	b.subs[roomID] = sub
+	// The server only routes to the subscription once it has processed it.
+	return b.conn.Flush()
+}
+
+func (b *NATSBroker) Unsubscribe(roomID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub, ok := b.subs[roomID]
+	if !ok {
+		return nil
+	}
+	delete(b.subs, roomID)
+	return sub.Unsubscribe()
+}
+
+func (b *NATSBroker) Run(handler func(roomID string, data []byte)) {
+	for msg := range b.messages {
+		handler(strings.TrimPrefix(msg.Subject, natsSubjectPrefix), msg.Data)
+	}
+}
+
+func (b *NATSBroker) Close() error {
+	b.mu.Lock()
+	for _, sub := range b.subs {
+		_ = sub.Unsubscribe()
+	}
+	b.subs = nil
+	b.mu.Unlock()
+	b.conn.Close()
+	close(b.messages)
+	return nil
+}
+
+func natsSubject(roomID string) string {
+	return natsSubjectPrefix + roomID
+}
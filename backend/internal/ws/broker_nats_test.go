@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"testing"
+
+	natstest "github.com/nats-io/nats-server/v2/test"
+)
+
+// newNATSHubs starts an embedded NATS server and two hubs that fan out
+// through it, as two gateway nodes would.
+func newNATSHubs(t *testing.T) (*Hub, *Hub) {
+	t.Helper()
+	srv := natstest.RunRandClientPortServer()
+	t.Cleanup(srv.Shutdown)
+
+	hubs := make([]*Hub, 2)
+	for i := range hubs {
+		broker, err := NewNATSBroker(srv.ClientURL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		hubs[i] = newTestHub(t, broker, nil)
+	}
+	return hubs[0], hubs[1]
+}
+
+func TestNATSFanOutAcrossHubs(t *testing.T) {
+	nodeA, nodeB := newNATSHubs(t)
+	onA, _ := connectTestClient(t, nodeA)
+	onB, _ := connectTestClient(t, nodeB)
+
+	publish(nodeB, 1)
+	expectEvent(t, onA, EventGuildRemove, 1)
+	expectEvent(t, onB, EventGuildRemove, 1)
+
+	publish(nodeA, 2)
+	expectEvent(t, onA, EventGuildRemove, 2)
+	expectEvent(t, onB, EventGuildRemove, 2)
+}
+
+func TestNATSControlRoomReachesOtherHub(t *testing.T) {
+	nodeA, nodeB := newNATSHubs(t)
+	c := NewClient(nodeA, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll, AuthSession: "login-1"})
+	nodeA.register <- c
+	s := nodeA.newSession(c)
+	nodeA.Subscribe(s, userRoomPrefix+testUser)
+	nodeA.awaitInterest()
+
+	// JOIN sent from B subscribes the session on A, which then receives
+	// the room's frames from B.
+	nodeB.JoinUser(testUser, testRoom)
+	waitFor(t, "join", func() bool { return s.inRoom(testRoom) })
+	nodeA.awaitInterest()
+	nodeB.BroadcastToRoom(testRoom, Event{Type: EventGuildRemove, Data: map[string]int{"n": 1}})
+	expectEvent(t, c, EventGuildRemove, 1)
+
+	nodeB.DisconnectSession("login-1")
+	waitFor(t, "disconnect", func() bool {
+		select {
+		case <-c.hangup:
+			return true
+		default:
+			return false
+		}
+	})
+	if !c.revoked.Load() {
+		t.Fatal("disconnected session left resumable")
+	}
+}
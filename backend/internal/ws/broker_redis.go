@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"context"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisChannelPrefix = "ws:"
+
+// RedisBroker fans frames out between backend instances through Redis
+// pub/sub, one channel per room. Each instance only subscribes to the rooms
+// it has local members in, so Redis does not push frames nobody here reads.
+type RedisBroker struct {
+	rdb    *redis.Client
+	pubsub *redis.PubSub
+}
+
+func NewRedisBroker(rdb *redis.Client) *RedisBroker {
+	return &RedisBroker{
+		rdb:    rdb,
+		pubsub: rdb.Subscribe(context.Background(), redisChannelPrefix+controlRoom),
+	}
+}
+
+func (b *RedisBroker) Publish(roomID string, data []byte) error {
+	return b.rdb.Publish(context.Background(), redisChannelPrefix+roomID, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(roomID string) error {
+	return b.pubsub.Subscribe(context.Background(), redisChannelPrefix+roomID)
+}
+
+func (b *RedisBroker) Unsubscribe(roomID string) error {
+	return b.pubsub.Unsubscribe(context.Background(), redisChannelPrefix+roomID)
+}
+
+func (b *RedisBroker) Run(handler func(roomID string, data []byte)) {
+	for msg := range b.pubsub.Channel() {
+		handler(strings.TrimPrefix(msg.Channel, redisChannelPrefix), []byte(msg.Payload))
+	}
+}
+
+func (b *RedisBroker) Close() error {
+	return b.pubsub.Close()
+}
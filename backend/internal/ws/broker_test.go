@@ -0,0 +1,46 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerDeliversInOrder(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	c, _ := connectTestClient(t, h)
+	for n := 1; n <= 3; n++ {
+		publish(h, n)
+	}
+	for n := int64(1); n <= 3; n++ {
+		expectEvent(t, c, EventGuildRemove, n)
+	}
+}
+
+func TestMemoryBrokerCloseStopsRun(t *testing.T) {
+	b := NewMemoryBroker()
+	stopped := make(chan struct{})
+	go func() {
+		b.Run(func(string, []byte) {})
+		close(stopped)
+	}()
+
+	b.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+}
+
+func TestRedisFanOutAcrossHubs(t *testing.T) {
+	rdb := newTestRedis(t)
+	nodeA, _ := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	nodeB, _ := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	onA := connectUser(t, nodeA, testUser)
+	onB := connectUser(t, nodeB, testUser)
+	awaitRedisSubscribers(t, rdb, 2, userRoomPrefix+testUser)
+
+	publish(nodeB, 1)
+	expectEvent(t, onA, EventGuildRemove, 1)
+	expectEvent(t, onB, EventGuildRemove, 1)
+}
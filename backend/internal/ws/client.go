@@ -3,24 +3,61 @@ package ws
 import (
 	"encoding/json"
 	"log"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 4096
+	writeWait = 10 * time.Second
+	// heartbeatInterval is announced in HELLO. A connection that sends
+	// neither a HEARTBEAT, any other frame nor a pong for pongWait is
+	// considered dead.
+	heartbeatInterval = 30 * time.Second
+	pongWait          = 2 * heartbeatInterval
+	pingPeriod        = (pongWait * 9) / 10
+	maxMessageSize    = 4096
+	// identifyTimeout is how long a new connection may take to send
+	// IDENTIFY or RESUME.
+	identifyTimeout = heartbeatInterval
 )
 
 type Client struct {
 	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
+	conn   *websocket.Conn // nil for SSE clients
+	send   chan outFrame
 	UserID string
-	rooms  map[string]bool
+	// connID identifies the connection in the presence sets.
+	connID string
+	// session is set by IDENTIFY or RESUME.
+	session atomic.Pointer[session]
+	codec   *codec
+	// authSession is the login session behind the connection's credentials.
+	authSession string
+	intents     Intents
+	// revoked is set when the login session was revoked; the gateway
+	// session then ends with the connection.
+	revoked atomic.Bool
+
+	opLimit        *tokenBucket
+	userLimit      *tokenBucket
+	heartbeatLimit *tokenBucket
+	invalidLimit   *tokenBucket
+
+	backpressure
+
+	// hangup is closed with the transport. It ends the stream of an SSE
+	// client and stops a replay to a connection that went away.
+	hangup     chan struct{}
+	hangupOnce sync.Once
+	// released is set once ServeWs returned and the WebSocket library may
+	// reuse conn, so other goroutines must no longer touch it.
+	released    bool
+	transportMu sync.Mutex
 }
 
 type ClientMessage struct {
@@ -36,13 +73,35 @@ type TypingData struct {
 	ChannelID string `json:"channel_id"`
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID string) *Client {
+type HelloData struct {
+	HeartbeatInterval int64 `json:"heartbeat_interval"`
+}
+
+// HeartbeatAckData carries the sequence number of the last frame the
+// session sent, so a client can tell whether it is missing any.
+type HeartbeatAckData struct {
+	Seq int64 `json:"seq"`
+}
+
+func NewClient(hub *Hub, conn *websocket.Conn, userID string, opts ConnectOptions) *Client {
 	return &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		UserID: userID,
-		rooms:  make(map[string]bool),
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan outFrame, 256),
+		UserID:  userID,
+		connID:  uuid.New().String(),
+		codec:   newCodec(opts),
+		intents: opts.Intents,
+
+		authSession: opts.AuthSession,
+
+		opLimit:        newConnLimit(opts.Bot),
+		userLimit:      hub.acquireUserLimit(userID, opts.Bot),
+		heartbeatLimit: newTokenBucket(heartbeatRate, heartbeatBurst),
+		invalidLimit:   newTokenBucket(1/invalidFrameRefill.Seconds(), invalidFrameBurst),
+
+		backpressure: backpressure{done: make(chan struct{})},
+		hangup:       make(chan struct{}),
 	}
 }
 
@@ -50,96 +109,271 @@ func (c *Client) ReadPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
+		c.hub.releaseUserLimit(c.UserID)
+		if c.session.Load() != nil {
+			c.hub.disconnect(c)
+		}
 	}()
 
 	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 
 	for {
-		_, rawMsg, err := c.conn.ReadMessage()
+		messageType, rawMsg, err := c.conn.ReadMessage()
 		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Printf("WebSocket heartbeat timeout: user=%s", c.UserID)
+			}
 			break
 		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
 		var msg ClientMessage
-		if err := json.Unmarshal(rawMsg, &msg); err != nil {
+		rawMsg, err = c.codec.decode(messageType, rawMsg)
+		if err == nil {
+			err = json.Unmarshal(rawMsg, &msg)
+		}
+		if err != nil {
+			if !c.invalidFrame("", "malformed frame") {
+				c.closeWith(CloseDecodeError, "too many invalid frames")
+				return
+			}
 			continue
 		}
 
+		if !c.allowOp(msg.Op) {
+			log.Printf("WebSocket rate limited: user=%s", c.UserID)
+			c.closeWith(CloseRateLimited, "rate limited")
+			return
+		}
+
+		if c.session.Load() == nil {
+			switch msg.Op {
+			case "HEARTBEAT", "IDENTIFY", "RESUME":
+			default:
+				c.closeWith(CloseNotIdentified, "identify or resume first")
+				return
+			}
+		}
+
 		switch msg.Op {
+		case "HEARTBEAT":
+			c.sendEvent(Event{Type: EventHeartbeatAck, Data: HeartbeatAckData{Seq: c.lastSeq()}})
+
+		case "IDENTIFY":
+			if c.session.Load() != nil {
+				if !c.invalidFrame(msg.Op, "already identified") {
+					c.closeWith(CloseDecodeError, "too many invalid frames")
+					return
+				}
+				continue
+			}
+			intents, err := parseIdentify(msg.Data)
+			if err != nil {
+				if !c.invalidFrame(msg.Op, err.Error()) {
+					c.closeWith(CloseDecodeError, "too many invalid frames")
+					return
+				}
+				continue
+			}
+			c.identify(intents)
+
+		case "RESUME":
+			var data ResumeData
+			if err := json.Unmarshal(msg.Data, &data); err != nil {
+				if !c.invalidFrame(msg.Op, "malformed payload") {
+					c.closeWith(CloseDecodeError, "too many invalid frames")
+					return
+				}
+				continue
+			}
+			replay, ok := c.resume(data)
+			if !ok {
+				continue
+			}
+			for _, f := range replay {
+				c.unsent.Add(1)
+				select {
+				case c.send <- f:
+				case <-c.hangup:
+					return
+				}
+			}
+			c.finishResume(len(replay))
+			c.hub.connect(c)
+
 		case "SUBSCRIBE":
 			var data SubscribeData
 			if err := json.Unmarshal(msg.Data, &data); err == nil && data.ChannelID != "" {
-				c.hub.Subscribe(c, data.ChannelID)
-				c.rooms[data.ChannelID] = true
+				c.subscribe(msg.Op, data.ChannelID)
 			}
 
 		case "UNSUBSCRIBE":
 			var data SubscribeData
 			if err := json.Unmarshal(msg.Data, &data); err == nil && data.ChannelID != "" {
-				c.hub.Unsubscribe(c, data.ChannelID)
-				delete(c.rooms, data.ChannelID)
+				c.hub.Unsubscribe(c.session.Load(), data.ChannelID)
 			}
 
 		case "TYPING":
 			var data TypingData
 			if err := json.Unmarshal(msg.Data, &data); err == nil && data.ChannelID != "" {
-				c.hub.BroadcastToRoom(data.ChannelID, Event{
-					Type: EventTypingStart,
-					Data: map[string]string{
-						"user_id":    c.UserID,
-						"channel_id": data.ChannelID,
-					},
-					RoomID: data.ChannelID,
-				})
+				c.startTyping(data.ChannelID)
 			}
 
+		case "TYPING_STOP":
+			var data TypingData
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.ChannelID != "" {
+				c.hub.StopTyping(c.UserID, data.ChannelID)
+			}
+
+		case "IDLE":
+			var data IdleData
+			if err := json.Unmarshal(msg.Data, &data); err == nil {
+				c.hub.setIdle(c, data.Idle)
+			}
+
+		case "VOICE_STATE_UPDATE":
+			var data VoiceStateData
+			if err := json.Unmarshal(msg.Data, &data); err == nil && data.GuildID != "" {
+				c.updateVoiceState(data)
+			}
+
+		case "MESSAGE_SEND", "MESSAGE_EDIT", "REACTION_ADD":
+			c.handleWrite(msg.Op, msg.Data)
+
 		case "SUBSCRIBE_GUILD":
 			var data struct {
 				GuildID string `json:"guild_id"`
 			}
 			if err := json.Unmarshal(msg.Data, &data); err == nil && data.GuildID != "" {
-				roomID := "guild:" + data.GuildID
-				c.hub.Subscribe(c, roomID)
-				c.rooms[roomID] = true
+				c.subscribe(msg.Op, guildRoomPrefix+data.GuildID)
+			}
+
+		default:
+			if !c.invalidFrame(msg.Op, "unknown op") {
+				c.closeWith(CloseDecodeError, "too many invalid frames")
+				return
 			}
 		}
 	}
 }
 
+// identify starts a new session on the connection: it joins the user's room,
+// counts the connection for presence and sends READY.
+func (c *Client) identify(intents Intents) {
+	c.intents = intents
+	s := c.hub.newSession(c)
+	c.hub.Subscribe(s, userRoomPrefix+c.UserID)
+	c.hub.connect(c)
+	if err := c.hub.sendReady(c); err != nil {
+		log.Printf("ready: user=%s: %v", c.UserID, err)
+	}
+}
+
+// subscribe joins roomID after checking membership, answering with an ERROR
+// frame when the user is not allowed in or the connection already holds
+// maxRoomsConn rooms.
+func (c *Client) subscribe(op, roomID string) {
+	s := c.session.Load()
+	if s.roomCount() >= maxRoomsConn && !s.inRoom(roomID) {
+		c.sendEvent(Event{Type: EventError, Data: ErrorData{
+			Op: op, Code: ErrCodeLimitExceeded, Message: "too many subscriptions", RoomID: roomID,
+		}})
+		return
+	}
+	if err := c.hub.Authorize(c.UserID, roomID); err != nil {
+		c.sendEvent(Event{Type: EventError, Data: errorData(op, roomID, err)})
+		return
+	}
+	c.hub.Subscribe(s, roomID)
+}
+
+// sendEvent queues an event for this connection only. Sequenced events go
+// through the session, so they are numbered and recorded like room events.
+func (c *Client) sendEvent(event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if s := c.session.Load(); s != nil && !unsequenced[event.Type] {
+		s.deliver("", newSharedFrame(data))
+		return
+	}
+	c.enqueue("", outFrame{data: data}, false)
+}
+
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		c.closeTransport()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case message := <-c.send:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
+			messageType, data, err := c.codec.encode(message)
+			if err != nil {
+				c.unsent.Add(-1)
+				log.Printf("encode frame: user=%s: %v", c.UserID, err)
+				continue
 			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			err = c.conn.WriteMessage(messageType, data)
+			c.unsent.Add(-1)
+			if err != nil {
 				return
 			}
 
 		case <-ticker.C:
+			if s := c.session.Load(); s != nil {
+				c.hub.refreshSession(s)
+				c.hub.trackConnection(c)
+			}
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-c.done:
+			return
 		}
 	}
 }
 
-func ServeWs(hub *Hub, conn *websocket.Conn, userID string) {
-	client := NewClient(hub, conn, userID)
+func ServeWs(hub *Hub, conn *websocket.Conn, userID string, opts ConnectOptions) {
+	if hub.draining.Load() {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server shutting down"), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	client := NewClient(hub, conn, userID, opts)
 	hub.register <- client
 
-	log.Printf("WebSocket connected: user=%s", userID)
+	log.Printf("WebSocket connected: user=%s encoding=%s compress=%s intents=%d", userID, opts.Encoding, opts.Compress, opts.Intents)
+
+	client.sendEvent(Event{
+		Type: EventHello,
+		Data: HelloData{HeartbeatInterval: heartbeatInterval.Milliseconds()},
+	})
+	identifyTimer := time.AfterFunc(identifyTimeout, func() {
+		if client.session.Load() == nil {
+			client.closeWith(CloseNotIdentified, "identify timeout")
+		}
+	})
+	defer identifyTimer.Stop()
 
-	go client.WritePump()
+	written := make(chan struct{})
+	go func() {
+		client.WritePump()
+		close(written)
+	}()
 	client.ReadPump()
+	<-written
+	client.release()
 }
@@ -0,0 +1,280 @@
+package ws
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/tinylib/msgp/msgp"
+)
+
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+	EncodingCBOR    = "cbor"
+
+	CompressZlibStream = "zlib-stream"
+)
+
+// ConnectOptions are negotiated through the query string of /ws. Intents
+// are sent with IDENTIFY on /ws and only come from the query string for the
+// SSE stream. AuthSession and Bot are not negotiated but set by the server
+// from the credentials the connection was authenticated with.
+type ConnectOptions struct {
+	Encoding    string
+	Compress    string
+	Intents     Intents
+	AuthSession string
+	Bot         bool
+}
+
+// ParseConnectOptions validates the encoding, compress and intents query
+// parameters. Empty values select plain JSON text frames and all intents.
+func ParseConnectOptions(encoding, compress, intents string) (ConnectOptions, error) {
+	opts := ConnectOptions{Encoding: encoding, Compress: compress}
+	switch opts.Encoding {
+	case "":
+		opts.Encoding = EncodingJSON
+	case EncodingJSON, EncodingMsgpack, EncodingCBOR:
+	default:
+		return opts, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	switch opts.Compress {
+	case "", CompressZlibStream:
+	default:
+		return opts, fmt.Errorf("unsupported compression %q", compress)
+	}
+	var err error
+	if opts.Intents, err = ParseIntents(intents); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+var cborDecMode, _ = cbor.DecOptions{
+	DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+}.DecMode()
+
+// codec turns the JSON frames produced by the hub into the wire format the
+// connection negotiated, and incoming frames back into JSON. With
+// zlib-stream every outgoing frame is written to one compression context
+// that lives as long as the connection and is flushed after each frame, so
+// the client must feed all binary frames into a single inflater.
+type codec struct {
+	encoding string
+	zbuf     *bytes.Buffer
+	zw       *zlib.Writer
+}
+
+func newCodec(opts ConnectOptions) *codec {
+	c := &codec{encoding: opts.Encoding}
+	if opts.Compress == CompressZlibStream {
+		c.zbuf = new(bytes.Buffer)
+		c.zw = zlib.NewWriter(c.zbuf)
+	}
+	return c
+}
+
+func (c *codec) encode(f outFrame) (int, []byte, error) {
+	messageType := websocket.TextMessage
+	data := f.data
+
+	if c.encoding != EncodingJSON {
+		var err error
+		if f.shared != nil {
+			data, err = f.shared.body(c.encoding).frame(c.encoding, f.seq)
+		} else {
+			data, err = encodeBody(c.encoding, f.data).frame(c.encoding, 0)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		messageType = websocket.BinaryMessage
+	}
+
+	if c.zw != nil {
+		c.zbuf.Reset()
+		if _, err := c.zw.Write(data); err != nil {
+			return 0, nil, err
+		}
+		if err := c.zw.Flush(); err != nil {
+			return 0, nil, err
+		}
+		data = append([]byte(nil), c.zbuf.Bytes()...)
+		messageType = websocket.BinaryMessage
+	}
+	return messageType, data, nil
+}
+
+// sharedFrame is a frame as published, before a session numbers it. A
+// fan-out hands the same sharedFrame to every session in the room, so each
+// binary encoding is computed once per frame instead of once per
+// connection. Compression stays per connection, as each has its own
+// context.
+type sharedFrame struct {
+	data   []byte
+	mu     sync.Mutex
+	bodies map[string]binaryBody
+}
+
+func newSharedFrame(data []byte) *sharedFrame {
+	return &sharedFrame{data: data}
+}
+
+func (f *sharedFrame) body(encoding string) binaryBody {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.bodies[encoding]
+	if !ok {
+		b = encodeBody(encoding, f.data)
+		if f.bodies == nil {
+			f.bodies = make(map[string]binaryBody, 1)
+		}
+		f.bodies[encoding] = b
+	}
+	return b
+}
+
+// binaryBody is a JSON object frame in msgpack or CBOR without its map
+// header, so a connection can add its sequence number as one more entry.
+type binaryBody struct {
+	entries []byte
+	n       int
+	err     error
+}
+
+func encodeBody(encoding string, frame []byte) binaryBody {
+	dec := json.NewDecoder(bytes.NewReader(frame))
+	dec.UseNumber()
+	var v map[string]interface{}
+	if err := dec.Decode(&v); err != nil {
+		return binaryBody{err: err}
+	}
+	b := binaryBody{n: len(v)}
+	for k, val := range v {
+		switch encoding {
+		case EncodingMsgpack:
+			b.entries = msgp.AppendString(b.entries, k)
+			b.entries, b.err = msgp.AppendIntf(b.entries, val)
+		case EncodingCBOR:
+			var data []byte
+			b.entries = appendCBORHead(b.entries, cborText, uint64(len(k)))
+			b.entries = append(b.entries, k...)
+			data, b.err = cbor.Marshal(normalizeNumbers(val))
+			b.entries = append(b.entries, data...)
+		}
+		if b.err != nil {
+			return b
+		}
+	}
+	return b
+}
+
+// frame completes the body with its map header and, unless seq is 0, the
+// "s" entry that stamp adds to JSON frames.
+func (b binaryBody) frame(encoding string, seq int64) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	n := b.n
+	if seq != 0 {
+		n++
+	}
+	out := make([]byte, 0, len(b.entries)+16)
+	switch encoding {
+	case EncodingMsgpack:
+		out = msgp.AppendMapHeader(out, uint32(n))
+		out = append(out, b.entries...)
+		if seq != 0 {
+			out = msgp.AppendString(out, "s")
+			out = msgp.AppendInt64(out, seq)
+		}
+	case EncodingCBOR:
+		out = appendCBORHead(out, cborMap, uint64(n))
+		out = append(out, b.entries...)
+		if seq != 0 {
+			out = appendCBORHead(out, cborText, 1)
+			out = append(out, 's')
+			out = appendCBORHead(out, cborUint, uint64(seq))
+		}
+	}
+	return out, nil
+}
+
+// CBOR major types, shifted into the initial byte.
+const (
+	cborUint = 0 << 5
+	cborText = 3 << 5
+	cborMap  = 5 << 5
+)
+
+// appendCBORHead appends the initial byte and argument of a CBOR data item
+// in the shortest form, as cbor.Marshal writes them.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+// decode converts a client frame to JSON. Clients never compress what they
+// send; binary frames are read in the negotiated encoding.
+func (c *codec) decode(messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.TextMessage || c.encoding == EncodingJSON {
+		return data, nil
+	}
+	switch c.encoding {
+	case EncodingMsgpack:
+		var buf bytes.Buffer
+		if _, err := msgp.UnmarshalAsJSON(&buf, data); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingCBOR:
+		var v interface{}
+		if err := cborDecMode.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+	return data, nil
+}
+
+// normalizeNumbers replaces json.Number values with int64 or float64 so CBOR
+// encodes them as numbers rather than strings.
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, val := range v {
+			v[k] = normalizeNumbers(val)
+		}
+		return v
+	case []interface{}:
+		for i, val := range v {
+			v[i] = normalizeNumbers(val)
+		}
+		return v
+	}
+	return v
+}
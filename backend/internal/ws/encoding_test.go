@@ -0,0 +1,274 @@
+package ws
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/tinylib/msgp/msgp"
+)
+
+var testEvent = Event{
+	Type: EventMessageCreate,
+	Data: map[string]interface{}{
+		"id":        "m1",
+		"content":   "grüß dich 👋",
+		"count":     3,
+		"big":       int64(1) << 40,
+		"negative":  -7,
+		"ratio":     0.25,
+		"pinned":    false,
+		"edited_at": nil,
+		"reactions": []interface{}{map[string]interface{}{"emoji": "👍", "count": 2}},
+	},
+	RoomID: "room",
+}
+
+// asJSON decodes a JSON frame generically, so frames can be compared
+// regardless of key order and number representation.
+func asJSON(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return v
+}
+
+func TestParseConnectOptions(t *testing.T) {
+	opts, err := ParseConnectOptions("", "", "")
+	if err != nil || opts.Encoding != EncodingJSON || opts.Compress != "" || opts.Intents != IntentsAll {
+		t.Fatalf("defaults: %+v, %v", opts, err)
+	}
+	if _, err := ParseConnectOptions(EncodingCBOR, CompressZlibStream, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConnectOptions("xml", "", ""); err == nil {
+		t.Fatal("accepted an unknown encoding")
+	}
+	if _, err := ParseConnectOptions(EncodingJSON, "gzip", ""); err == nil {
+		t.Fatal("accepted an unknown compression")
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	raw, _ := json.Marshal(testEvent)
+	for _, encoding := range []string{EncodingJSON, EncodingMsgpack, EncodingCBOR} {
+		for _, seq := range []int64{0, 1, 23, 24, 255, 70000, 1 << 33} {
+			c := newCodec(ConnectOptions{Encoding: encoding})
+			want := raw
+			if seq != 0 {
+				want = stamp(raw, seq)
+			}
+			for name, f := range map[string]outFrame{
+				"direct":  {data: want, seq: seq},
+				"fan-out": {data: want, seq: seq, shared: newSharedFrame(raw)},
+			} {
+				messageType, data, err := c.encode(f)
+				if err != nil {
+					t.Fatalf("%s s=%d %s: encode: %v", encoding, seq, name, err)
+				}
+				if (encoding == EncodingJSON) != (messageType == websocket.TextMessage) {
+					t.Fatalf("%s: message type %d", encoding, messageType)
+				}
+				got, err := c.decode(messageType, data)
+				if err != nil {
+					t.Fatalf("%s s=%d %s: decode: %v", encoding, seq, name, err)
+				}
+				if !reflect.DeepEqual(asJSON(t, got), asJSON(t, want)) {
+					t.Fatalf("%s s=%d %s: got %s, want %s", encoding, seq, name, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestCodecMatchesLibraries checks the hand-written map headers against
+// what the msgpack and CBOR libraries decode, not only against decode.
+func TestCodecMatchesLibraries(t *testing.T) {
+	raw, _ := json.Marshal(testEvent)
+	want := asJSON(t, stamp(raw, 300))
+
+	_, data, err := newCodec(ConnectOptions{Encoding: EncodingMsgpack}).encode(outFrame{data: stamp(raw, 300), seq: 300, shared: newSharedFrame(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, rest, err := msgp.ReadIntfBytes(data)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("msgpack: %v, %d bytes left", err, len(rest))
+	}
+	if got, _ := json.Marshal(v); !reflect.DeepEqual(asJSON(t, got), want) {
+		t.Fatalf("msgpack: got %s", got)
+	}
+
+	_, data, err = newCodec(ConnectOptions{Encoding: EncodingCBOR}).encode(outFrame{data: stamp(raw, 300), seq: 300, shared: newSharedFrame(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cbor.Wellformed(data); err != nil {
+		t.Fatalf("cbor: %v", err)
+	}
+	var m map[string]interface{}
+	if err := cborDecMode.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(m); !reflect.DeepEqual(asJSON(t, got), want) {
+		t.Fatalf("cbor: got %s", got)
+	}
+}
+
+// TestZlibStreamSharesContext feeds every frame of a connection into one
+// inflater, as clients must: only the first frame carries the zlib header,
+// repeated content shrinks through the shared window, and the stream
+// decodes to the frames in order.
+func TestZlibStreamSharesContext(t *testing.T) {
+	raw, _ := json.Marshal(testEvent)
+	for _, encoding := range []string{EncodingJSON, EncodingMsgpack, EncodingCBOR} {
+		t.Run(encoding, func(t *testing.T) {
+			c := newCodec(ConnectOptions{Encoding: encoding, Compress: CompressZlibStream})
+			var stream bytes.Buffer
+			var sizes []int
+			for seq := int64(1); seq <= 3; seq++ {
+				messageType, data, err := c.encode(outFrame{data: stamp(raw, seq), seq: seq, shared: newSharedFrame(raw)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if messageType != websocket.BinaryMessage {
+					t.Fatalf("frame %d: message type %d", seq, messageType)
+				}
+				if hasHeader := data[0] == 0x78; hasHeader != (seq == 1) {
+					t.Fatalf("frame %d starts with %#x", seq, data[0])
+				}
+				if !bytes.HasSuffix(data, []byte{0, 0, 0xff, 0xff}) {
+					t.Fatalf("frame %d is not flushed", seq)
+				}
+				sizes = append(sizes, len(data))
+				stream.Write(data)
+			}
+			if sizes[1] >= sizes[0] {
+				t.Fatalf("frame sizes %v: the second frame did not use the shared window", sizes)
+			}
+
+			zr, err := zlib.NewReader(&stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := streamDecoder(t, encoding, zr)
+			for seq := int64(1); seq <= 3; seq++ {
+				if got, want := next(), asJSON(t, stamp(raw, seq)); !reflect.DeepEqual(got, want) {
+					t.Fatalf("frame %d: got %v, want %v", seq, got, want)
+				}
+			}
+		})
+	}
+}
+
+// streamDecoder reads consecutive frames of encoding from r as JSON values.
+func streamDecoder(t *testing.T, encoding string, r io.Reader) func() interface{} {
+	var decode func() (interface{}, error)
+	switch encoding {
+	case EncodingJSON:
+		dec := json.NewDecoder(r)
+		decode = func() (interface{}, error) {
+			var v interface{}
+			return v, dec.Decode(&v)
+		}
+	case EncodingMsgpack:
+		mr := msgp.NewReader(r)
+		decode = func() (interface{}, error) { return mr.ReadIntf() }
+	case EncodingCBOR:
+		dec := cborDecMode.NewDecoder(r)
+		decode = func() (interface{}, error) {
+			var v interface{}
+			return v, dec.Decode(&v)
+		}
+	}
+	return func() interface{} {
+		t.Helper()
+		v, err := decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asJSON(t, data)
+	}
+}
+
+// TestFanOutEncodesOnce delivers one event to connections with different
+// encodings: all frames come from one sharedFrame, which ends up with a
+// single body per encoding, and each connection still gets its own
+// sequence number.
+func TestFanOutEncodesOnce(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	encodings := []string{EncodingMsgpack, EncodingMsgpack, EncodingCBOR, EncodingJSON}
+	var clients []*Client
+	for i, encoding := range encodings {
+		c := NewClient(h, nil, testUser, ConnectOptions{Encoding: encoding, Intents: IntentsAll})
+		h.register <- c
+		s := h.newSession(c)
+		h.Subscribe(s, userRoomPrefix+testUser)
+		// Offset the sessions, so a shared stamp would show.
+		for j := 0; j < i; j++ {
+			s.deliver("", newSharedFrame([]byte(`{"t":"NOOP"}`)))
+			<-c.send
+		}
+		clients = append(clients, c)
+	}
+	h.awaitInterest()
+
+	h.BroadcastToUser(testUser, testEvent)
+	raw, _ := json.Marshal(testEvent)
+	var shared *sharedFrame
+	for i, c := range clients {
+		var f outFrame
+		select {
+		case f = <-c.send:
+		case <-time.After(time.Second):
+			t.Fatal("no frame")
+		}
+		if f.shared == nil || (shared != nil && f.shared != shared) {
+			t.Fatalf("client %d: frame not shared", i)
+		}
+		shared = f.shared
+		messageType, data, err := c.codec.encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.codec.decode(messageType, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := stamp(raw, int64(i+1)); !reflect.DeepEqual(asJSON(t, got), asJSON(t, want)) {
+			t.Fatalf("client %d: got %s, want %s", i, got, want)
+		}
+	}
+	if len(shared.bodies) != 2 {
+		t.Fatalf("%d bodies encoded, want one each for msgpack and cbor", len(shared.bodies))
+	}
+}
+
+func TestCodecDecodesClientFrames(t *testing.T) {
+	op := map[string]interface{}{"op": "HEARTBEAT", "d": 42}
+	want, _ := json.Marshal(op)
+
+	msgpackFrame, _ := msgp.AppendIntf(nil, op)
+	cborFrame, _ := cbor.Marshal(op)
+	for encoding, frame := range map[string][]byte{EncodingMsgpack: msgpackFrame, EncodingCBOR: cborFrame} {
+		got, err := newCodec(ConnectOptions{Encoding: encoding}).decode(websocket.BinaryMessage, frame)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if !reflect.DeepEqual(asJSON(t, got), asJSON(t, want)) {
+			t.Fatalf("%s: got %s, want %s", encoding, got, want)
+		}
+	}
+}
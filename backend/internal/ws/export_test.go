@@ -0,0 +1,19 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// These let the tests in package ws_test drive gateway writes and typing
+// through the real services, which import this package.
+
+func (c *Client) HandleWrite(op string, raw json.RawMessage) { c.handleWrite(op, raw) }
+
+func (c *Client) NextFrame(t *testing.T) (string, json.RawMessage) {
+	t.Helper()
+	f := nextFrame(t, c)
+	return f.Type, f.Data
+}
+
+func (h *Hub) ClaimTyping(userID, channelID string) bool { return h.claimTyping(userID, channelID) }
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// dialGateway serves h on a local listener and opens a gateway connection
+// for testUser.
+func dialGateway(t *testing.T, h *Hub) *fastws.Conn {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		ServeWs(h, c, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads frames from conn until one of type want arrives.
+func readUntil(t *testing.T, conn *fastws.Conn, want string) testFrame {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read while waiting for %s: %v", want, err)
+		}
+		var f testFrame
+		json.Unmarshal(data, &f)
+		if f.Type == want {
+			return f
+		}
+	}
+}
+
+func TestHelloAnnouncesHeartbeatInterval(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	conn := dialGateway(t, h)
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f testFrame
+	json.Unmarshal(data, &f)
+	var hello HelloData
+	json.Unmarshal(f.Data, &hello)
+	if f.Type != EventHello || hello.HeartbeatInterval != heartbeatInterval.Milliseconds() {
+		t.Fatalf("first frame %s, want HELLO with the heartbeat interval", data)
+	}
+}
+
+func TestHeartbeatIsAcknowledged(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	conn := dialGateway(t, h)
+	readUntil(t, conn, EventHello)
+
+	if err := conn.WriteMessage(fastws.TextMessage, []byte(`{"op":"HEARTBEAT","d":42}`)); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing was sequenced before IDENTIFY, so the last seq is 0.
+	if f := readUntil(t, conn, EventHeartbeatAck); string(f.Data) != `{"seq":0}` {
+		t.Fatalf("HEARTBEAT_ACK carries %s, want seq 0", f.Data)
+	}
+}
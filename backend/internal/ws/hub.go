@@ -1,29 +1,69 @@
 package ws
 
 import (
-	"context"
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"pwdh-aether/internal/repository"
 
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	EventMessageCreate = "MESSAGE_CREATE"
-	EventMessageUpdate = "MESSAGE_UPDATE"
-	EventMessageDelete = "MESSAGE_DELETE"
-	EventTypingStart   = "TYPING_START"
-	EventChannelCreate = "CHANNEL_CREATE"
-	EventChannelUpdate = "CHANNEL_UPDATE"
-	EventChannelDelete = "CHANNEL_DELETE"
-	EventMemberJoin    = "MEMBER_JOIN"
-	EventMemberLeave   = "MEMBER_LEAVE"
-	EventPresenceUpdate = "PRESENCE_UPDATE"
-	EventVoiceStateUpdate = "VOICE_STATE_UPDATE"
-	EventLFGCreate     = "LFG_CREATE"
-	EventLFGUpdate     = "LFG_UPDATE"
-	EventLFGDelete     = "LFG_DELETE"
+	EventHello               = "HELLO"
+	EventHeartbeatAck        = "HEARTBEAT_ACK"
+	EventReady               = "READY"
+	EventResumed             = "RESUMED"
+	EventInvalidSession      = "INVALID_SESSION"
+	EventMessageCreate       = "MESSAGE_CREATE"
+	EventMessageUpdate       = "MESSAGE_UPDATE"
+	EventMessageDelete       = "MESSAGE_DELETE"
+	EventTypingStart         = "TYPING_START"
+	EventTypingStop          = "TYPING_STOP"
+	EventChannelCreate       = "CHANNEL_CREATE"
+	EventChannelUpdate       = "CHANNEL_UPDATE"
+	EventChannelDelete       = "CHANNEL_DELETE"
+	EventMemberJoin          = "MEMBER_JOIN"
+	EventMemberLeave         = "MEMBER_LEAVE"
+	EventMemberUpdate        = "MEMBER_UPDATE"
+	EventPresenceUpdate      = "PRESENCE_UPDATE"
+	EventVoiceStateUpdate    = "VOICE_STATE_UPDATE"
+	EventLFGCreate           = "LFG_CREATE"
+	EventLFGUpdate           = "LFG_UPDATE"
+	EventLFGDelete           = "LFG_DELETE"
+	EventSubscriptionRevoked = "SUBSCRIPTION_REVOKED"
+	EventConversationCreate  = "CONVERSATION_CREATE"
+	EventGuildRemove         = "GUILD_REMOVE"
+	EventDataExportComplete  = "DATA_EXPORT_COMPLETE"
+	EventReconnect           = "RECONNECT"
+	EventAck                 = "ACK"
+	EventError               = "ERROR"
+)
+
+// controlRoom is a reserved room used to fan hub-internal commands out to
+// every node. It never has client members.
+const controlRoom = "$control"
+
+// A room whose broker subscribe failed is retried after subscribeRetryMin,
+// doubling up to subscribeRetryMax while the broker keeps failing.
+const (
+	subscribeRetryMin = 250 * time.Millisecond
+	subscribeRetryMax = 30 * time.Second
+)
+
+const (
+	controlRevoke       = "REVOKE"
+	controlJoin         = "JOIN"
+	controlSessionJoin  = "SESSION_JOIN"
+	controlSessionLeave = "SESSION_LEAVE"
+	controlDisconnect   = "DISCONNECT"
+	// A node resuming a session held elsewhere sends SESSION_TAKEOVER; the
+	// holder answers SESSION_RELEASED with the last sequence number.
+	controlSessionTakeover = "SESSION_TAKEOVER"
+	controlSessionReleased = "SESSION_RELEASED"
 )
 
 type Event struct {
@@ -32,30 +72,119 @@ type Event struct {
 	RoomID string      `json:"-"`
 }
 
+type controlMessage struct {
+	Op        string   `json:"op"`
+	UserID    string   `json:"user_id"`
+	GuildID   string   `json:"guild_id,omitempty"`
+	SessionID string   `json:"session_id,omitempty"`
+	Rooms     []string `json:"rooms"`
+	// AuthSession selects connections by login session for DISCONNECT.
+	AuthSession string `json:"auth_session,omitempty"`
+	Nonce       string `json:"nonce,omitempty"`
+	Seq         int64  `json:"seq,omitempty"`
+}
+
+// interestNote names a room whose local membership changed. A note with done
+// set is a barrier that is closed once all earlier notes are applied.
+type interestNote struct {
+	roomID string
+	done   chan struct{}
+}
+
 type Hub struct {
 	clients    map[*Client]bool
-	rooms      map[string]map[*Client]bool
+	sessions   map[string]*session
+	rooms      map[string]map[*session]bool
 	register   chan *Client
 	unregister chan *Client
-	broadcast  chan Event
+	broker     Broker
+	interest   chan interestNote
+	records    chan recordOp
 	rdb        *redis.Client
+	users      *repository.UserRepository
+	guilds     *repository.GuildRepository
+	channels   *repository.ChannelRepository
+	convs      *repository.ConversationRepository
+	presence   *repository.PresenceRepository
+	writer     MessageWriter
+	draining   atomic.Bool
 	mu         sync.RWMutex
+
+	// Live connections per user and the end of their grace period after the
+	// last one dropped, only used when running without Redis.
+	localConns map[string]map[string]bool
+	localIdle  map[string]map[string]bool
+	localGrace map[string]time.Time
+	presenceMu sync.Mutex
+
+	// Last TYPING_START per channel and user, only used without Redis, and
+	// the clock they are taken from, which tests replace.
+	localTyping map[string]time.Time
+	typingMu    sync.Mutex
+	now         func() time.Time
+
+	userLimits map[string]*userLimit
+	limitsMu   sync.Mutex
+
+	// RESUMEs waiting for another node to release a session, by nonce.
+	takeovers  map[string]chan int64
+	takeoverMu sync.Mutex
+
+	slowConsumers atomic.Uint64
+	drops         map[string]uint64
+	dropsMu       sync.Mutex
 }
 
-func NewHub(rdb *redis.Client) *Hub {
+// NewHub creates a hub that fans events out through broker. rdb backs session
+// resume and presence and may be nil, in which case resume is unavailable and
+// presence is tracked for this node only.
+func NewHub(
+	broker Broker,
+	rdb *redis.Client,
+	users *repository.UserRepository,
+	guilds *repository.GuildRepository,
+	channels *repository.ChannelRepository,
+	convs *repository.ConversationRepository,
+	presence *repository.PresenceRepository,
+) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		rooms:      make(map[string]map[*Client]bool),
+		sessions:   make(map[string]*session),
+		rooms:      make(map[string]map[*session]bool),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
-		broadcast:  make(chan Event, 256),
+		broker:     broker,
+		interest:   make(chan interestNote, 1024),
+		records:    make(chan recordOp, recordQueueSize),
 		rdb:        rdb,
+		users:      users,
+		guilds:     guilds,
+		channels:   channels,
+		convs:      convs,
+		presence:   presence,
+		localConns: make(map[string]map[string]bool),
+		localIdle:  make(map[string]map[string]bool),
+		localGrace: make(map[string]time.Time),
+
+		localTyping: make(map[string]time.Time),
+		now:         time.Now,
+		userLimits:  make(map[string]*userLimit),
+		takeovers:   make(map[string]chan int64),
+		drops:       make(map[string]uint64),
 	}
 }
 
 func (h *Hub) Run() {
-	go h.subscribeRedis()
+	go h.broker.Run(h.dispatch)
+	go h.reconcileSubscriptions()
+	if h.rdb != nil {
+		go h.runRecorder()
+	}
 
+	expire := time.NewTicker(time.Minute)
+	defer expire.Stop()
+	sweep := time.NewTicker(presenceSweepInterval)
+	defer sweep.Stop()
 	for {
 		select {
 		case client := <-h.register:
@@ -65,113 +194,348 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				for roomID, members := range h.rooms {
-					delete(members, client)
-					if len(members) == 0 {
-						delete(h.rooms, roomID)
-					}
-				}
-			}
+			_, ok := h.clients[client]
+			delete(h.clients, client)
 			h.mu.Unlock()
-
-		case event := <-h.broadcast:
-			h.mu.RLock()
-			if members, ok := h.rooms[event.RoomID]; ok {
-				data, err := json.Marshal(event)
-				if err == nil {
-					for client := range members {
-						select {
-						case client.send <- data:
-						default:
-							close(client.send)
-							delete(members, client)
-						}
-					}
-				}
+			if ok {
+				client.stop()
+				h.detach(client)
 			}
-			h.mu.RUnlock()
+
+		case <-expire.C:
+			h.expireSessions()
+
+		case <-sweep.C:
+			go h.sweepPresence()
 		}
 	}
 }
 
-func (h *Hub) Subscribe(client *Client, roomID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if h.rooms[roomID] == nil {
-		h.rooms[roomID] = make(map[*Client]bool)
+// addMember and removeMember update the room index and must be called with
+// h.mu held. They report whether the room went from empty to non-empty or
+// back, i.e. whether the broker subscription for it has to change.
+func (h *Hub) addMember(s *session, roomID string) bool {
+	members := h.rooms[roomID]
+	created := members == nil
+	if created {
+		members = make(map[*session]bool)
+		h.rooms[roomID] = members
 	}
-	h.rooms[roomID][client] = true
+	members[s] = true
+	s.rooms[roomID] = true
+	return created
 }
 
-func (h *Hub) Unsubscribe(client *Client, roomID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if members, ok := h.rooms[roomID]; ok {
-		delete(members, client)
-		if len(members) == 0 {
-			delete(h.rooms, roomID)
+func (h *Hub) removeMember(s *session, roomID string) bool {
+	delete(s.rooms, roomID)
+	members, ok := h.rooms[roomID]
+	if !ok {
+		return false
+	}
+	delete(members, s)
+	if len(members) > 0 {
+		return false
+	}
+	delete(h.rooms, roomID)
+	return true
+}
+
+// noteInterest queues rooms whose local membership changed so the broker
+// only carries rooms this node has members in.
+func (h *Hub) noteInterest(roomIDs ...string) {
+	for _, roomID := range roomIDs {
+		h.interest <- interestNote{roomID: roomID}
+	}
+}
+
+// awaitInterest returns once the broker subscriptions reflect every change
+// noted before the call.
+func (h *Hub) awaitInterest() {
+	done := make(chan struct{})
+	h.interest <- interestNote{done: done}
+	<-done
+}
+
+// reconcileSubscriptions keeps the broker subscriptions in line with the
+// local rooms. Changes are applied one at a time from this goroutine, so a
+// room that empties and refills quickly always ends up subscribed. Rooms
+// whose subscribe failed stay pending and are retried with backoff until
+// they succeed or empty.
+func (h *Hub) reconcileSubscriptions() {
+	subscribed := make(map[string]bool)
+	failed := make(map[string]bool)
+	backoff := subscribeRetryMin
+	var retry <-chan time.Time
+
+	apply := func(roomID string) {
+		h.mu.RLock()
+		wanted := len(h.rooms[roomID]) > 0
+		h.mu.RUnlock()
+
+		switch {
+		case wanted && !subscribed[roomID]:
+			if err := h.broker.Subscribe(roomID); err != nil {
+				log.Printf("broker subscribe %s: %v", roomID, err)
+				failed[roomID] = true
+				return
+			}
+			subscribed[roomID] = true
+		case !wanted && subscribed[roomID]:
+			if err := h.broker.Unsubscribe(roomID); err != nil {
+				log.Printf("broker unsubscribe %s: %v", roomID, err)
+			}
+			delete(subscribed, roomID)
+		}
+		delete(failed, roomID)
+	}
+
+	for {
+		select {
+		case note, ok := <-h.interest:
+			if !ok {
+				return
+			}
+			if note.done != nil {
+				close(note.done)
+				continue
+			}
+			apply(note.roomID)
+		case <-retry:
+			retry = nil
+			for roomID := range failed {
+				apply(roomID)
+			}
+			if len(failed) > 0 {
+				backoff = min(2*backoff, subscribeRetryMax)
+			}
+		}
+
+		switch {
+		case len(failed) == 0:
+			backoff = subscribeRetryMin
+			retry = nil
+		case retry == nil:
+			retry = time.After(backoff)
 		}
 	}
 }
 
-func (h *Hub) BroadcastToRoom(roomID string, event Event) {
-	event.RoomID = roomID
+// dispatch handles a frame coming in from the broker. Frames for the control
+// room are applied to the hub, all others are fanned out to the local
+// sessions whose intents cover the event, connected or not. Clients that
+// cannot keep up are handled by the slow-consumer policy in enqueue.
+func (h *Hub) dispatch(roomID string, data []byte) {
+	if roomID == controlRoom {
+		var ctl struct {
+			Data controlMessage `json:"d"`
+		}
+		if err := json.Unmarshal(data, &ctl); err != nil {
+			log.Printf("control unmarshal: %v", err)
+			return
+		}
+		h.applyControl(ctl.Data)
+		return
+	}
 
-	if h.rdb != nil {
-		data, err := json.Marshal(event)
-		if err == nil {
-			h.rdb.Publish(context.Background(), "ws:"+roomID, data)
+	var required Intents
+	resolved := false
+	frame := newSharedFrame(data)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.rooms[roomID] {
+		if s.intents != IntentsAll {
+			if !resolved {
+				required = requiredIntent(roomID, frameType(data))
+				resolved = true
+			}
+			if !s.intents.Has(required) {
+				continue
+			}
 		}
-	} else {
-		h.broadcast <- event
+		s.deliver(roomID, frame)
 	}
 }
 
-func (h *Hub) subscribeRedis() {
-	if h.rdb == nil {
+func (h *Hub) Subscribe(s *session, roomID string) {
+	h.mu.Lock()
+	created := h.addMember(s, roomID)
+	h.mu.Unlock()
+
+	if created {
+		h.noteInterest(roomID)
+	}
+	h.rememberRoom(s, roomID)
+}
+
+func (h *Hub) Unsubscribe(s *session, roomID string) {
+	h.mu.Lock()
+	emptied := h.removeMember(s, roomID)
+	h.mu.Unlock()
+
+	if emptied {
+		h.noteInterest(roomID)
+	}
+	h.forgetRoom(s, roomID)
+}
+
+func (h *Hub) BroadcastToRoom(roomID string, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("broadcast %s: marshal: %v", roomID, err)
 		return
 	}
-	ctx := context.Background()
-	pubsub := h.rdb.PSubscribe(ctx, "ws:*")
-	defer pubsub.Close()
+	if err := h.broker.Publish(roomID, data); err != nil {
+		log.Printf("broadcast %s: publish: %v", roomID, err)
+	}
+}
+
+// BroadcastToUser delivers an event to every connection of userID, on any
+// node.
+func (h *Hub) BroadcastToUser(userID string, event Event) {
+	h.BroadcastToRoom(userRoomPrefix+userID, event)
+}
+
+// JoinUser subscribes every open connection of userID to roomID, e.g. after
+// the user was added to a conversation while already connected.
+func (h *Hub) JoinUser(userID, roomID string) {
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlJoin, UserID: userID, Rooms: []string{roomID}},
+	})
+}
+
+// RevokeGuild removes every connection of userID from the guild room and from
+// the rooms of all channels in that guild. Call it once the membership is gone.
+func (h *Hub) RevokeGuild(guildID, userID string) {
+	rooms := []string{guildRoomPrefix + guildID}
+	channels, err := h.channels.GetByGuildID(guildID)
+	if err != nil {
+		log.Printf("revoke guild %s: list channels: %v", guildID, err)
+	}
+	for _, ch := range channels {
+		rooms = append(rooms, ch.ID)
+	}
+
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlRevoke, UserID: userID, GuildID: guildID, Rooms: rooms},
+	})
+}
+
+// JoinSession subscribes the session with sessionID to roomID on whichever
+// node holds it. The caller must have authorized userID for the room; the
+// session is only touched if it belongs to userID.
+func (h *Hub) JoinSession(userID, sessionID, roomID string) {
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlSessionJoin, UserID: userID, SessionID: sessionID, Rooms: []string{roomID}},
+	})
+}
+
+// LeaveSession unsubscribes the session with sessionID from roomID.
+func (h *Hub) LeaveSession(userID, sessionID, roomID string) {
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlSessionLeave, UserID: userID, SessionID: sessionID, Rooms: []string{roomID}},
+	})
+}
+
+// DisconnectSession closes every connection, on any node, that was opened
+// with credentials of the given login session.
+func (h *Hub) DisconnectSession(authSession string) {
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlDisconnect, AuthSession: authSession},
+	})
+}
+
+// Draining reports whether Shutdown has started.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
 
-	ch := pubsub.Channel()
-	for msg := range ch {
-		var event Event
-		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
-			log.Printf("redis unmarshal: %v", err)
-			continue
+func (h *Hub) applyControl(msg controlMessage) {
+	switch msg.Op {
+	case controlDisconnect:
+		for _, client := range h.snapshotClients() {
+			if client.authSession != "" && client.authSession == msg.AuthSession {
+				client.revoked.Store(true)
+				go client.closeWith(CloseSessionRevoked, "session revoked")
+			}
 		}
-		roomID := msg.Channel[3:]
-		event.RoomID = roomID
 
+	case controlSessionJoin, controlSessionLeave:
 		h.mu.RLock()
-		if members, ok := h.rooms[roomID]; ok {
-			data := []byte(msg.Payload)
-			for client := range members {
-				select {
-				case client.send <- data:
-				default:
+		s := h.sessions[msg.SessionID]
+		h.mu.RUnlock()
+		if s == nil || s.userID != msg.UserID {
+			return
+		}
+		for _, roomID := range msg.Rooms {
+			if msg.Op == controlSessionLeave {
+				h.Unsubscribe(s, roomID)
+			} else if s.roomCount() < maxRoomsConn {
+				h.Subscribe(s, roomID)
+			}
+		}
+
+	case controlJoin:
+		var joined []*session
+		var created []string
+		h.mu.Lock()
+		for s := range h.rooms[userRoomPrefix+msg.UserID] {
+			for _, roomID := range msg.Rooms {
+				if h.addMember(s, roomID) {
+					created = append(created, roomID)
 				}
 			}
+			joined = append(joined, s)
 		}
-		h.mu.RUnlock()
-	}
-}
+		h.mu.Unlock()
 
-func (h *Hub) BroadcastToGuild(guildID string, event Event) {
-	roomID := "guild:" + guildID
-	event.RoomID = roomID
+		h.noteInterest(created...)
+		for _, s := range joined {
+			for _, roomID := range msg.Rooms {
+				h.rememberRoom(s, roomID)
+			}
+		}
 
-	if h.rdb != nil {
-		data, err := json.Marshal(event)
-		if err == nil {
-			h.rdb.Publish(context.Background(), "ws:"+roomID, data)
+	case controlRevoke:
+		data, err := json.Marshal(Event{
+			Type: EventSubscriptionRevoked,
+			Data: map[string]interface{}{"guild_id": msg.GuildID, "rooms": msg.Rooms},
+		})
+		if err != nil {
+			return
+		}
+		var emptied []string
+		h.mu.Lock()
+		affected := make(map[*session]bool)
+		for _, roomID := range msg.Rooms {
+			for s := range h.rooms[roomID] {
+				if s.userID != msg.UserID {
+					continue
+				}
+				if h.removeMember(s, roomID) {
+					emptied = append(emptied, roomID)
+				}
+				affected[s] = true
+			}
 		}
-	} else {
-		h.broadcast <- event
+		frame := newSharedFrame(data)
+		for s := range affected {
+			s.deliver("", frame)
+		}
+		h.mu.Unlock()
+
+		h.noteInterest(emptied...)
+
+	case controlSessionTakeover:
+		h.releaseSession(msg)
+
+	case controlSessionReleased:
+		h.sessionReleased(msg)
 	}
 }
+
+// BroadcastToGuild publishes to the guild room. Connections only receive it
+// if their intents cover the event type.
+func (h *Hub) BroadcastToGuild(guildID string, event Event) {
+	h.BroadcastToRoom(guildRoomPrefix+guildID, event)
+}
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingBroker is a MemoryBroker that remembers the rooms the hub
+// subscribed to and can fail a number of Subscribe calls. Like a real broker
+// it only delivers frames for rooms it holds a subscription for.
+type recordingBroker struct {
+	*MemoryBroker
+
+	mu         sync.Mutex
+	subscribed map[string]bool
+	calls      []string
+	failures   int
+}
+
+func newRecordingBroker() *recordingBroker {
+	return &recordingBroker{MemoryBroker: NewMemoryBroker(), subscribed: make(map[string]bool)}
+}
+
+func (b *recordingBroker) Subscribe(roomID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, "+"+roomID)
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("broker unavailable")
+	}
+	b.subscribed[roomID] = true
+	return nil
+}
+
+func (b *recordingBroker) Unsubscribe(roomID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, "-"+roomID)
+	delete(b.subscribed, roomID)
+	return nil
+}
+
+func (b *recordingBroker) Run(handler func(roomID string, data []byte)) {
+	b.MemoryBroker.Run(func(roomID string, data []byte) {
+		b.mu.Lock()
+		held := roomID == controlRoom || b.subscribed[roomID]
+		b.mu.Unlock()
+		if held {
+			handler(roomID, data)
+		}
+	})
+}
+
+func (b *recordingBroker) fail(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = n
+}
+
+func (b *recordingBroker) state() (map[string]bool, []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscribed := make(map[string]bool, len(b.subscribed))
+	for roomID := range b.subscribed {
+		subscribed[roomID] = true
+	}
+	return subscribed, append([]string(nil), b.calls...)
+}
+
+const testRoom = guildRoomPrefix + "5d1f6a0e-6c1b-4f8e-9a57-0f4c2d8b7e21"
+
+func TestReconcileSubscribesOncePerRoom(t *testing.T) {
+	broker := newRecordingBroker()
+	h := newTestHub(t, broker, nil)
+	a := h.newSession(NewClient(h, nil, testUser, ConnectOptions{}))
+	b := h.newSession(NewClient(h, nil, testUser, ConnectOptions{}))
+
+	h.Subscribe(a, testRoom)
+	h.Subscribe(b, testRoom)
+	h.awaitInterest()
+	if _, calls := broker.state(); len(calls) != 1 || calls[0] != "+"+testRoom {
+		t.Fatalf("broker calls %v, want one subscribe", calls)
+	}
+
+	h.Unsubscribe(a, testRoom)
+	h.awaitInterest()
+	if subscribed, _ := broker.state(); !subscribed[testRoom] {
+		t.Fatal("unsubscribed while a member is left")
+	}
+
+	h.Unsubscribe(b, testRoom)
+	h.awaitInterest()
+	subscribed, calls := broker.state()
+	if subscribed[testRoom] || len(calls) != 2 {
+		t.Fatalf("broker calls %v after the last member left", calls)
+	}
+}
+
+func TestReconcileEndsSubscribedWhenRoomRefills(t *testing.T) {
+	broker := newRecordingBroker()
+	h := newTestHub(t, broker, nil)
+	s := h.newSession(NewClient(h, nil, testUser, ConnectOptions{}))
+
+	for i := 0; i < 50; i++ {
+		h.Subscribe(s, testRoom)
+		h.Unsubscribe(s, testRoom)
+	}
+	h.Subscribe(s, testRoom)
+	h.awaitInterest()
+
+	if subscribed, calls := broker.state(); !subscribed[testRoom] {
+		t.Fatalf("room with a member left unsubscribed after %v", calls)
+	}
+}
+
+func TestReconcileRetriesFailedSubscribe(t *testing.T) {
+	broker := newRecordingBroker()
+	h := newTestHub(t, broker, nil)
+	c, s := connectTestClient(t, h)
+
+	broker.fail(1)
+	h.Subscribe(s, testRoom)
+	h.awaitInterest()
+	if subscribed, _ := broker.state(); subscribed[testRoom] {
+		t.Fatal("failed subscribe recorded as subscribed")
+	}
+	h.BroadcastToRoom(testRoom, Event{Type: EventGuildRemove, Data: map[string]int{"n": 1}})
+
+	// No membership change follows; the retry timer alone has to bring the
+	// room back.
+	waitFor(t, "subscribe retry", func() bool {
+		subscribed, _ := broker.state()
+		return subscribed[testRoom]
+	})
+	h.BroadcastToRoom(testRoom, Event{Type: EventGuildRemove, Data: map[string]int{"n": 2}})
+
+	f := nextFrame(t, c)
+	if f.Type != EventGuildRemove || string(f.Data) != `{"n":2}` {
+		t.Fatalf("got %s %s, want the event published after the broker recovered", f.Type, f.Data)
+	}
+	if _, calls := broker.state(); len(calls) != 3 {
+		t.Fatalf("broker calls %v, want the user room, the failed subscribe and one retry", calls)
+	}
+}
+
+func TestReconcileDropsRetryForEmptiedRoom(t *testing.T) {
+	broker := newRecordingBroker()
+	h := newTestHub(t, broker, nil)
+	s := h.newSession(NewClient(h, nil, testUser, ConnectOptions{}))
+
+	broker.fail(1)
+	h.Subscribe(s, testRoom)
+	h.awaitInterest()
+	h.Unsubscribe(s, testRoom)
+	h.awaitInterest()
+
+	time.Sleep(2 * subscribeRetryMin)
+	subscribed, calls := broker.state()
+	if subscribed[testRoom] || len(calls) != 1 {
+		t.Fatalf("subscribed %v after %v, want no retry for a room without members", subscribed, calls)
+	}
+}
+
+func TestRemoveSessionLeavesItsRooms(t *testing.T) {
+	broker := newRecordingBroker()
+	h := newTestHub(t, broker, nil)
+	c, s := connectTestClient(t, h)
+	h.Subscribe(s, testRoom)
+	h.awaitInterest()
+
+	h.unregister <- c
+	waitFor(t, "session drop", func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.sessions) == 0
+	})
+	h.awaitInterest()
+
+	if subscribed, calls := broker.state(); len(subscribed) != 0 {
+		t.Fatalf("still subscribed to %v after %v", subscribed, calls)
+	}
+}
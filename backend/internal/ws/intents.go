@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Intents is a bitfield of event categories a connection opts into. Events
+// outside every category (channel and member updates, user-targeted events,
+// replies to the client's own ops) are always delivered.
+type Intents uint32
+
+const (
+	IntentGuildMessages Intents = 1 << iota
+	IntentGuildPresences
+	IntentTyping
+	IntentLFG
+	IntentVoiceStates
+	IntentDirectMessages
+
+	IntentsAll = IntentGuildMessages | IntentGuildPresences | IntentTyping |
+		IntentLFG | IntentVoiceStates | IntentDirectMessages
+)
+
+// ParseIntents reads the intents query parameter of the SSE stream. An empty
+// value selects all intents so existing clients keep receiving everything.
+func ParseIntents(s string) (Intents, error) {
+	if s == "" {
+		return IntentsAll, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || Intents(n)&^IntentsAll != 0 {
+		return 0, fmt.Errorf("invalid intents %q", s)
+	}
+	return Intents(n), nil
+}
+
+// IdentifyData is the payload of IDENTIFY. Intents selects the event
+// categories of the new session; without it all of them are delivered.
+type IdentifyData struct {
+	Intents *Intents `json:"intents"`
+}
+
+// parseIdentify reads the intents from an IDENTIFY payload, which may be
+// missing entirely.
+func parseIdentify(raw json.RawMessage) (Intents, error) {
+	var data IdentifyData
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return 0, fmt.Errorf("malformed payload")
+		}
+	}
+	if data.Intents == nil {
+		return IntentsAll, nil
+	}
+	if *data.Intents&^IntentsAll != 0 {
+		return 0, fmt.Errorf("invalid intents %d", *data.Intents)
+	}
+	return *data.Intents, nil
+}
+
+func (i Intents) Has(required Intents) bool {
+	return i&required == required
+}
+
+// requiredIntent maps an event published to roomID to the intent a client
+// needs to receive it, or 0 if it is always delivered.
+func requiredIntent(roomID, eventType string) Intents {
+	switch eventType {
+	case EventMessageCreate, EventMessageUpdate, EventMessageDelete:
+		if strings.HasPrefix(roomID, dmRoomPrefix) {
+			return IntentDirectMessages
+		}
+		if strings.HasPrefix(roomID, userRoomPrefix) {
+			return 0
+		}
+		return IntentGuildMessages
+	case EventPresenceUpdate:
+		return IntentGuildPresences
+	case EventTypingStart, EventTypingStop:
+		return IntentTyping
+	case EventLFGCreate, EventLFGUpdate, EventLFGDelete:
+		return IntentLFG
+	case EventVoiceStateUpdate:
+		return IntentVoiceStates
+	}
+	return 0
+}
+
+// frameType extracts the event type of an encoded frame.
+func frameType(data []byte) string {
+	var head struct {
+		Type string `json:"t"`
+	}
+	json.Unmarshal(data, &head)
+	return head.Type
+}
@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseIdentify(t *testing.T) {
+	for _, tc := range []struct {
+		raw     string
+		want    Intents
+		wantErr bool
+	}{
+		{raw: "", want: IntentsAll},
+		{raw: "null", want: IntentsAll},
+		{raw: "{}", want: IntentsAll},
+		{raw: `{"intents":0}`, want: 0},
+		{raw: `{"intents":33}`, want: IntentGuildMessages | IntentDirectMessages},
+		{raw: `{"intents":64}`, wantErr: true},
+		{raw: `{"intents":-1}`, wantErr: true},
+		{raw: `{"intents":"1"}`, wantErr: true},
+	} {
+		got, err := parseIdentify(json.RawMessage(tc.raw))
+		if (err != nil) != tc.wantErr || (!tc.wantErr && got != tc.want) {
+			t.Errorf("parseIdentify(%q) = %d, %v", tc.raw, got, err)
+		}
+	}
+}
+
+func TestIdentifyAppliesIntents(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON})
+	h.register <- c
+	c.identify(IntentGuildMessages)
+	s := c.session.Load()
+	if s == nil || s.intents != IntentGuildMessages {
+		t.Fatal("session does not carry the identified intents")
+	}
+	h.Subscribe(s, testRoom)
+	h.awaitInterest()
+
+	h.BroadcastToRoom(testRoom, Event{Type: EventPresenceUpdate, Data: map[string]string{}})
+	h.BroadcastToRoom(testRoom, Event{Type: EventMessageCreate, Data: map[string]string{}})
+	for {
+		f := nextFrame(t, c)
+		if f.Type == EventPresenceUpdate {
+			t.Fatal("presence delivered without GUILD_PRESENCES")
+		}
+		if f.Type == EventMessageCreate {
+			return
+		}
+	}
+}
+
+func TestParseIntents(t *testing.T) {
+	for _, tc := range []struct {
+		raw     string
+		want    Intents
+		wantErr bool
+	}{
+		{raw: "", want: IntentsAll},
+		{raw: "0", want: 0},
+		{raw: "33", want: IntentGuildMessages | IntentDirectMessages},
+		{raw: "64", wantErr: true},
+		{raw: "-1", wantErr: true},
+		{raw: "all", wantErr: true},
+	} {
+		got, err := ParseIntents(tc.raw)
+		if (err != nil) != tc.wantErr || (!tc.wantErr && got != tc.want) {
+			t.Errorf("ParseIntents(%q) = %d, %v", tc.raw, got, err)
+		}
+	}
+}
+
+func TestIntentsFilterFanOut(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentGuildMessages})
+	h.register <- c
+	s := h.newSession(c)
+	dmRoom := dmRoomPrefix + testConversation
+	for _, room := range []string{userRoomPrefix + testUser, testRoom, dmRoom} {
+		h.Subscribe(s, room)
+	}
+
+	h.BroadcastToRoom(testRoom, Event{Type: EventPresenceUpdate, Data: map[string]string{}})
+	h.BroadcastToRoom(testRoom, Event{Type: EventTypingStart, Data: map[string]string{}})
+	h.BroadcastToRoom(testRoom, Event{Type: EventVoiceStateUpdate, Data: map[string]string{}})
+	h.BroadcastToRoom(dmRoom, Event{Type: EventMessageCreate, Data: map[string]string{"room": "dm"}})
+	h.BroadcastToRoom(testRoom, Event{Type: EventMessageCreate, Data: map[string]string{"room": "guild"}})
+	h.BroadcastToRoom(testRoom, Event{Type: EventMemberJoin, Data: map[string]string{}})
+
+	if f := nextFrame(t, c); f.Type != EventMessageCreate || string(f.Data) != `{"room":"guild"}` {
+		t.Fatalf("got %s %s, want the guild message first", f.Type, f.Data)
+	}
+	if f := nextFrame(t, c); f.Type != EventMemberJoin {
+		t.Fatalf("got %s, want MEMBER_JOIN, which needs no intent", f.Type)
+	}
+	expectOnlyUserEvent(t, h, c)
+}
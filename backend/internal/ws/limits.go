@@ -0,0 +1,175 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Close codes sent before the server drops a connection. Clients should not
+// reconnect in a tight loop after any of them, and not at all after
+// CloseSessionRevoked until the user logged in again.
+const (
+	CloseDecodeError    = 4002
+	CloseNotIdentified  = 4003
+	CloseSessionRevoked = 4004
+	CloseRateLimited    = 4008
+	// CloseSessionResumed closes a connection whose session was resumed on
+	// another connection.
+	CloseSessionResumed = 4010
+)
+
+const (
+	// Ops per second and burst per connection and, summed over all of a
+	// user's connections on this node, per user. HEARTBEAT is not counted
+	// here but has a small bucket of its own per connection. Bots get their
+	// own, higher limits.
+	connOpRate     = 2
+	connOpBurst    = 20
+	userOpRate     = 4
+	userOpBurst    = 40
+	botConnOpRate  = 10
+	botConnOpBurst = 50
+	botUserOpRate  = 20
+	botUserOpBurst = 100
+	heartbeatRate  = 0.2
+	heartbeatBurst = 3
+	maxRoomsConn   = 500
+	// A connection may send invalidFrameBurst undecodable frames or unknown
+	// ops; the allowance refills by one per invalidFrameRefill.
+	invalidFrameBurst  = 5
+	invalidFrameRefill = time.Minute
+)
+
+// tokenBucket allows rate events per second with bursts of up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type userLimit struct {
+	bucket *tokenBucket
+	conns  int
+}
+
+// newConnLimit returns the op bucket of a single connection.
+func newConnLimit(bot bool) *tokenBucket {
+	if bot {
+		return newTokenBucket(botConnOpRate, botConnOpBurst)
+	}
+	return newTokenBucket(connOpRate, connOpBurst)
+}
+
+// acquireUserLimit returns the op bucket shared by all connections of userID
+// on this node. Every call must be paired with releaseUserLimit.
+func (h *Hub) acquireUserLimit(userID string, bot bool) *tokenBucket {
+	h.limitsMu.Lock()
+	defer h.limitsMu.Unlock()
+	l := h.userLimits[userID]
+	if l == nil {
+		rate, burst := float64(userOpRate), float64(userOpBurst)
+		if bot {
+			rate, burst = botUserOpRate, botUserOpBurst
+		}
+		l = &userLimit{bucket: newTokenBucket(rate, burst)}
+		h.userLimits[userID] = l
+	}
+	l.conns++
+	return l.bucket
+}
+
+func (h *Hub) releaseUserLimit(userID string) {
+	h.limitsMu.Lock()
+	defer h.limitsMu.Unlock()
+	if l := h.userLimits[userID]; l != nil {
+		if l.conns--; l.conns <= 0 {
+			delete(h.userLimits, userID)
+		}
+	}
+}
+
+// allowOp charges op against the connection and user buckets, or against
+// the heartbeat bucket for HEARTBEAT.
+func (c *Client) allowOp(op string) bool {
+	if op == "HEARTBEAT" {
+		return c.heartbeatLimit.allow()
+	}
+	return c.opLimit.allow() && c.userLimit.allow()
+}
+
+// invalidFrame answers an undecodable frame or unknown op and reports whether
+// the connection is still within its allowance.
+func (c *Client) invalidFrame(op, message string) bool {
+	if !c.invalidLimit.allow() {
+		return false
+	}
+	c.sendEvent(Event{Type: EventError, Data: ErrorData{Op: op, Code: ErrCodeInvalidPayload, Message: message}})
+	return true
+}
+
+// closeWith sends a close frame with code and reason and closes the
+// connection, which ends ReadPump.
+func (c *Client) closeWith(code int, reason string) {
+	c.transportMu.Lock()
+	if c.conn != nil && !c.released {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	}
+	c.transportMu.Unlock()
+	c.closeTransport()
+}
+
+// hungUp reports whether the transport was closed.
+func (c *Client) hungUp() bool {
+	select {
+	case <-c.hangup:
+		return true
+	default:
+		return false
+	}
+}
+
+// closeTransport closes the underlying WebSocket, or ends the stream of an
+// SSE client.
+func (c *Client) closeTransport() {
+	c.transportMu.Lock()
+	if c.conn != nil && !c.released {
+		c.conn.Close()
+	}
+	c.transportMu.Unlock()
+	c.hangupOnce.Do(func() { close(c.hangup) })
+}
+
+// release hands the connection back to the WebSocket library once both
+// pumps are done with it; closing the client later only ends hangup.
+func (c *Client) release() {
+	c.transportMu.Lock()
+	c.released = true
+	c.transportMu.Unlock()
+	c.hangupOnce.Do(func() { close(c.hangup) })
+}
@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// spend calls allowOp n times and returns how many calls were allowed.
+func spend(c *Client, op string, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if c.allowOp(op) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	b := newTokenBucket(2, 3)
+	for i := 0; i < 3; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d within the burst was refused", i+1)
+		}
+	}
+	if b.allow() {
+		t.Fatal("call beyond the burst was allowed")
+	}
+
+	b.mu.Lock()
+	b.last = b.last.Add(-time.Second)
+	b.mu.Unlock()
+	got := 0
+	for b.allow() {
+		got++
+	}
+	if got != 2 {
+		t.Fatalf("one second refilled %d tokens, want 2", got)
+	}
+}
+
+func TestConnectionOpLimit(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{})
+
+	if got := spend(c, "TYPING", connOpBurst+5); got != connOpBurst {
+		t.Fatalf("allowed %d ops, want %d", got, connOpBurst)
+	}
+}
+
+func TestUserOpLimitIsSharedByConnections(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		clients = append(clients, NewClient(h, nil, testUser, ConnectOptions{}))
+	}
+
+	total := 0
+	for _, c := range clients {
+		total += spend(c, "TYPING", connOpBurst)
+	}
+	if total != userOpBurst {
+		t.Fatalf("allowed %d ops over three connections, want %d", total, userOpBurst)
+	}
+
+	for _, c := range clients {
+		h.releaseUserLimit(c.UserID)
+	}
+	h.limitsMu.Lock()
+	_, ok := h.userLimits[testUser]
+	h.limitsMu.Unlock()
+	if ok {
+		t.Fatal("user bucket kept after its last connection")
+	}
+}
+
+func TestBotOpLimits(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{Bot: true})
+
+	if got := spend(c, "MESSAGE_SEND", botConnOpBurst+5); got != botConnOpBurst {
+		t.Fatalf("allowed %d bot ops, want %d", got, botConnOpBurst)
+	}
+}
+
+func TestHeartbeatHasItsOwnBucket(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{})
+
+	spend(c, "TYPING", connOpBurst)
+	if c.allowOp("TYPING") {
+		t.Fatal("op allowed after the burst")
+	}
+	if got := spend(c, "HEARTBEAT", heartbeatBurst+5); got != heartbeatBurst {
+		t.Fatalf("allowed %d heartbeats, want %d", got, heartbeatBurst)
+	}
+}
+
+func TestInvalidFrameAllowance(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c := NewClient(h, nil, testUser, ConnectOptions{})
+
+	for i := 0; i < invalidFrameBurst; i++ {
+		if !c.invalidFrame("", "malformed frame") {
+			t.Fatalf("invalid frame %d ended the allowance", i+1)
+		}
+		if f := nextFrame(t, c); f.Type != EventError {
+			t.Fatalf("invalid frame answered with %s", f.Type)
+		}
+	}
+	if c.invalidFrame("", "malformed frame") {
+		t.Fatal("allowance not exhausted")
+	}
+}
+
+func TestHeartbeatAckCarriesLastSeq(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c, _ := connectTestClient(t, h)
+	if got := c.lastSeq(); got != 0 {
+		t.Fatalf("lastSeq %d before any frame", got)
+	}
+	publish(h, 1)
+	publish(h, 2)
+	expectEvent(t, c, EventGuildRemove, 1)
+	expectEvent(t, c, EventGuildRemove, 2)
+	if got := c.lastSeq(); got != 2 {
+		t.Fatalf("lastSeq %d, want 2", got)
+	}
+}
+
+func TestSubscribeRoomCap(t *testing.T) {
+	h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+	c, s := connectTestClient(t, h)
+	for i := 1; i < maxRoomsConn; i++ {
+		h.Subscribe(s, fmt.Sprintf("room-%d", i))
+	}
+
+	c.subscribe("SUBSCRIBE_GUILD", testRoom)
+	f := nextFrame(t, c)
+	var data ErrorData
+	json.Unmarshal(f.Data, &data)
+	if f.Type != EventError || data.Code != ErrCodeLimitExceeded || data.RoomID != testRoom {
+		t.Fatalf("got %s %s, want LIMIT_EXCEEDED", f.Type, f.Data)
+	}
+	if inRoom(h, c, testRoom) {
+		t.Fatal("joined a room beyond the cap")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
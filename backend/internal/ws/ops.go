@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+
+	"pwdh-aether/internal/model"
+)
+
+// MessageWriter performs the writes clients may send over the gateway. It is
+// implemented by service.MessageService, so the REST endpoints and the
+// gateway ops share validation, authorization and the resulting broadcasts.
+type MessageWriter interface {
+	Create(userID, channelID string, req model.CreateMessageRequest) (*model.MessageResponse, error)
+	Update(userID, messageID, content string) (*model.MessageResponse, error)
+	AddReaction(userID, messageID, emoji string) error
+}
+
+var errWritesUnavailable = errors.New("writes are not available on this gateway")
+
+// SetMessageWriter enables the MESSAGE_SEND, MESSAGE_EDIT and REACTION_ADD
+// ops. The writer depends on the hub for its broadcasts, so it is wired in
+// after construction.
+func (h *Hub) SetMessageWriter(w MessageWriter) {
+	h.writer = w
+}
+
+type MessageSendData struct {
+	Nonce         string  `json:"nonce"`
+	ChannelID     string  `json:"channel_id"`
+	Content       string  `json:"content"`
+	AttachmentURL *string `json:"attachment_url"`
+}
+
+type MessageEditData struct {
+	Nonce     string `json:"nonce"`
+	MessageID string `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type ReactionAddData struct {
+	Nonce     string `json:"nonce"`
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// AckData answers a successful write op. Data holds the created or updated
+// resource, if any.
+type AckData struct {
+	Op    string      `json:"op"`
+	Nonce string      `json:"nonce"`
+	Data  interface{} `json:"d,omitempty"`
+}
+
+// handleWrite runs a write op and answers with ACK or ERROR, echoing the
+// client's nonce either way.
+func (c *Client) handleWrite(op string, raw json.RawMessage) {
+	var nonce string
+	result, err := func() (interface{}, error) {
+		if c.hub.writer == nil {
+			return nil, errWritesUnavailable
+		}
+		switch op {
+		case "MESSAGE_SEND":
+			var data MessageSendData
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return nil, err
+			}
+			nonce = data.Nonce
+			return c.hub.writer.Create(c.UserID, data.ChannelID, model.CreateMessageRequest{
+				Content:       data.Content,
+				AttachmentURL: data.AttachmentURL,
+			})
+
+		case "MESSAGE_EDIT":
+			var data MessageEditData
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return nil, err
+			}
+			nonce = data.Nonce
+			return c.hub.writer.Update(c.UserID, data.MessageID, data.Content)
+
+		case "REACTION_ADD":
+			var data ReactionAddData
+			if err := json.Unmarshal(raw, &data); err != nil {
+				return nil, err
+			}
+			nonce = data.Nonce
+			return nil, c.hub.writer.AddReaction(c.UserID, data.MessageID, data.Emoji)
+		}
+		return nil, nil
+	}()
+
+	if err != nil {
+		var data ErrorData
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+			data = ErrorData{Op: op, Code: ErrCodeInvalidPayload, Message: "malformed payload"}
+		case errors.Is(err, errWritesUnavailable):
+			data = ErrorData{Op: op, Code: ErrCodeInternal, Message: err.Error()}
+		default:
+			data = errorData(op, "", err)
+		}
+		data.Nonce = nonce
+		c.sendEvent(Event{Type: EventError, Data: data})
+		return
+	}
+	c.sendEvent(Event{Type: EventAck, Data: AckData{Op: op, Nonce: nonce, Data: result}})
+}
@@ -0,0 +1,147 @@
+package ws_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"pwdh-aether/internal/model"
+	"pwdh-aether/internal/repository"
+	"pwdh-aether/internal/service"
+	"pwdh-aether/internal/ws"
+)
+
+const (
+	testUser    = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+	testGuild   = "5d1f6a0e-6c1b-4f8e-9a57-0f4c2d8b7e21"
+	testChannel = "7e6d5c4b-3a29-4f18-8e07-d6c5b4a39281"
+)
+
+// newServiceHub returns a hub, not running, whose writer is the
+// MessageService the REST handlers use, and a client of testUser on it.
+func newServiceHub(t *testing.T) (*ws.Hub, *ws.Client, *ws.MemoryBroker, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := ws.NewMemoryBroker()
+	t.Cleanup(func() {
+		broker.Close()
+		db.Close()
+	})
+	hub := ws.NewHub(
+		broker,
+		nil,
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewConversationRepository(db),
+		repository.NewPresenceRepository(db),
+	)
+	hub.SetMessageWriter(service.NewMessageService(
+		repository.NewMessageRepository(db),
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewBotRepository(db),
+		hub,
+	))
+	c := ws.NewClient(hub, nil, testUser, ws.ConnectOptions{Encoding: ws.EncodingJSON, Intents: ws.IntentsAll})
+	return hub, c, broker, mock
+}
+
+// TestWriteOpsShareRESTValidation runs gateway writes through the REST
+// MessageService: payloads REST rejects with ErrInvalidContent or
+// ErrInvalidEmoji come back as INVALID_PAYLOAD with the client's nonce,
+// before anything touches the database.
+func TestWriteOpsShareRESTValidation(t *testing.T) {
+	_, c, _, mock := newServiceHub(t)
+
+	tooLong := strings.Repeat("x", model.MaxMessageLength+1)
+	for _, tc := range []struct {
+		op, payload string
+	}{
+		{"MESSAGE_SEND", `{"nonce":"n1","channel_id":"c1","content":""}`},
+		{"MESSAGE_SEND", `{"nonce":"n2","channel_id":"c1","content":"  \n "}`},
+		{"MESSAGE_SEND", `{"nonce":"n3","channel_id":"c1","content":"` + tooLong + `"}`},
+		{"MESSAGE_EDIT", `{"nonce":"n4","message_id":"m1","content":""}`},
+		{"MESSAGE_EDIT", `{"nonce":"n5","message_id":"m1","content":"` + tooLong + `"}`},
+		{"REACTION_ADD", `{"nonce":"n6","message_id":"m1","emoji":" "}`},
+	} {
+		c.HandleWrite(tc.op, json.RawMessage(tc.payload))
+		typ, raw := c.NextFrame(t)
+		var got ws.ErrorData
+		json.Unmarshal(raw, &got)
+		var sent struct{ Nonce string }
+		json.Unmarshal([]byte(tc.payload), &sent)
+		if typ != ws.EventError || got.Op != tc.op || got.Code != ws.ErrCodeInvalidPayload || got.Nonce != sent.Nonce {
+			t.Fatalf("%s %s: got %s %s, want INVALID_PAYLOAD with nonce %s", tc.op, sent.Nonce, typ, raw, sent.Nonce)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMessageSendStopsTyping checks that a message ends its author's typing
+// indicator: TYPING_STOP reaches the channel before MESSAGE_CREATE, and only
+// if the author was typing.
+func TestMessageSendStopsTyping(t *testing.T) {
+	hub, c, broker, mock := newServiceHub(t)
+	events := make(chan string, 16)
+	go broker.Run(func(roomID string, data []byte) {
+		var frame struct {
+			Type string `json:"t"`
+		}
+		json.Unmarshal(data, &frame)
+		if roomID == testChannel {
+			events <- frame.Type
+		}
+	})
+	send := func(nonce string) {
+		mock.ExpectQuery(`FROM channels WHERE id = \$1`).WithArgs(testChannel).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+				AddRow(testChannel, testGuild, "general", "text", nil, 0, time.Now()))
+		mock.ExpectQuery(`FROM members WHERE guild_id = \$1 AND user_id = \$2`).WithArgs(testGuild, testUser).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectExec(`INSERT INTO messages`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUser).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "username", "email", "password_hash", "avatar_url", "totp_enabled",
+				"email_verified_at", "deletion_scheduled_at", "bot", "created_at",
+			}).AddRow(testUser, "alice", "alice@example.com", "", nil, false, nil, nil, false, time.Now()))
+		c.HandleWrite("MESSAGE_SEND", json.RawMessage(`{"nonce":"`+nonce+`","channel_id":"`+testChannel+`","content":"hi"}`))
+		if typ, raw := c.NextFrame(t); typ != ws.EventAck {
+			t.Fatalf("got %s %s, want ACK", typ, raw)
+		}
+	}
+	next := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event in the channel")
+			return ""
+		}
+	}
+
+	if !hub.ClaimTyping(testUser, testChannel) {
+		t.Fatal("typing throttled")
+	}
+	send("n1")
+	if first, second := next(), next(); first != ws.EventTypingStop || second != ws.EventMessageCreate {
+		t.Fatalf("got %s, %s, want TYPING_STOP, MESSAGE_CREATE", first, second)
+	}
+
+	send("n2")
+	if e := next(); e != ws.EventMessageCreate {
+		t.Fatalf("got %s without typing, want MESSAGE_CREATE", e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"pwdh-aether/internal/model"
+)
+
+// fakeWriter records the calls it gets and answers with err, if set.
+type fakeWriter struct {
+	calls []string
+	err   error
+}
+
+func (w *fakeWriter) Create(userID, channelID string, req model.CreateMessageRequest) (*model.MessageResponse, error) {
+	w.calls = append(w.calls, "create "+channelID+" "+req.Content)
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &model.MessageResponse{ID: "m1", ChannelID: channelID, Content: req.Content}, nil
+}
+
+func (w *fakeWriter) Update(userID, messageID, content string) (*model.MessageResponse, error) {
+	w.calls = append(w.calls, "update "+messageID+" "+content)
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &model.MessageResponse{ID: messageID, Content: content}, nil
+}
+
+func (w *fakeWriter) AddReaction(userID, messageID, emoji string) error {
+	w.calls = append(w.calls, "react "+messageID+" "+emoji)
+	return w.err
+}
+
+type testAck struct {
+	Op    string          `json:"op"`
+	Nonce string          `json:"nonce"`
+	Data  json.RawMessage `json:"d"`
+}
+
+func TestWriteOpsAckWithNonce(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	writer := &fakeWriter{}
+	h.SetMessageWriter(writer)
+	c := connectUser(t, h, testUser)
+
+	for op, payload := range map[string]string{
+		"MESSAGE_SEND": `{"nonce":"n-send","channel_id":"c1","content":"  hi  "}`,
+		"MESSAGE_EDIT": `{"nonce":"n-edit","message_id":"m1","content":"edited"}`,
+		"REACTION_ADD": `{"nonce":"n-react","message_id":"m1","emoji":"👍"}`,
+	} {
+		c.handleWrite(op, json.RawMessage(payload))
+		f := nextFrame(t, c)
+		var ack testAck
+		json.Unmarshal(f.Data, &ack)
+		var sent struct{ Nonce string }
+		json.Unmarshal([]byte(payload), &sent)
+		if f.Type != EventAck || ack.Op != op || ack.Nonce != sent.Nonce {
+			t.Fatalf("%s: got %s %s, want ACK with nonce %s", op, f.Type, f.Data, sent.Nonce)
+		}
+		if op == "REACTION_ADD" && ack.Data != nil {
+			t.Fatalf("%s: ACK carries %s", op, ack.Data)
+		}
+		if op != "REACTION_ADD" && ack.Data == nil {
+			t.Fatalf("%s: ACK without the resource", op)
+		}
+	}
+	// The payload reaches the writer untouched; validating it is the
+	// writer's job, as for REST.
+	for _, want := range []string{"create c1   hi  ", "update m1 edited", "react m1 👍"} {
+		found := false
+		for _, call := range writer.calls {
+			found = found || call == want
+		}
+		if !found {
+			t.Fatalf("writer calls %q, missing %q", writer.calls, want)
+		}
+	}
+}
+
+func TestWriteOpsErrorWithNonce(t *testing.T) {
+	for name, tc := range map[string]struct {
+		writer  MessageWriter
+		payload string
+		code    string
+		nonce   string
+	}{
+		"invalid content": {&fakeWriter{err: model.ErrInvalidContent}, `{"nonce":"n1","channel_id":"c1","content":""}`, ErrCodeInvalidPayload, "n1"},
+		"not a member":    {&fakeWriter{err: model.ErrNotMember}, `{"nonce":"n2","channel_id":"c1","content":"x"}`, ErrCodeForbidden, "n2"},
+		"unknown channel": {&fakeWriter{err: model.ErrChannelNotFound}, `{"nonce":"n3","channel_id":"c1","content":"x"}`, ErrCodeNotFound, "n3"},
+		"malformed":       {&fakeWriter{}, `{"nonce":"n4","content":5}`, ErrCodeInvalidPayload, ""},
+		"no writer":       {nil, `{"nonce":"n5","channel_id":"c1","content":"x"}`, ErrCodeInternal, ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+			if tc.writer != nil {
+				h.SetMessageWriter(tc.writer)
+			}
+			c := connectUser(t, h, testUser)
+
+			c.handleWrite("MESSAGE_SEND", json.RawMessage(tc.payload))
+			f := nextFrame(t, c)
+			var data ErrorData
+			json.Unmarshal(f.Data, &data)
+			if f.Type != EventError || data.Op != "MESSAGE_SEND" || data.Code != tc.code || data.Nonce != tc.nonce {
+				t.Fatalf("got %s %s, want ERROR %s with nonce %q", f.Type, f.Data, tc.code, tc.nonce)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"log"
+
+	"pwdh-aether/internal/model"
+)
+
+// Payloads of the guild events. All of them are sent to the guild:<id> room.
+//
+//	CHANNEL_CREATE, CHANNEL_UPDATE  model.Channel
+//	CHANNEL_DELETE                  ChannelDeleteData
+//	MEMBER_JOIN                     MemberJoinData
+//	MEMBER_LEAVE                    MemberLeaveData
+//	MEMBER_UPDATE                   MemberUpdateData
+//	PRESENCE_UPDATE                 model.UserPresence
+//	VOICE_STATE_UPDATE              VoiceStateData
+
+type ChannelDeleteData struct {
+	ID      string `json:"id"`
+	GuildID string `json:"guild_id"`
+}
+
+type MemberJoinData struct {
+	GuildID string               `json:"guild_id"`
+	Member  model.MemberResponse `json:"member"`
+}
+
+const (
+	LeaveReasonLeft   = "LEFT"
+	LeaveReasonKicked = "KICKED"
+	// LeaveReasonDeleted is sent when the member's account, or the guild
+	// with its owner's account, was deleted.
+	LeaveReasonDeleted = "ACCOUNT_DELETED"
+)
+
+type MemberLeaveData struct {
+	GuildID string `json:"guild_id"`
+	UserID  string `json:"user_id"`
+	Reason  string `json:"reason"`
+}
+
+type MemberUpdateData struct {
+	GuildID string `json:"guild_id"`
+	UserID  string `json:"user_id"`
+	Role    string `json:"role"`
+}
+
+// VoiceStateData is both the payload of the VOICE_STATE_UPDATE op a client
+// sends and of the event the guild receives. A null channel_id means the
+// user left voice.
+type VoiceStateData struct {
+	GuildID   string  `json:"guild_id"`
+	ChannelID *string `json:"channel_id"`
+	UserID    string  `json:"user_id"`
+	SelfMute  bool    `json:"self_mute"`
+	SelfDeaf  bool    `json:"self_deaf"`
+}
+
+// BroadcastPresence sends PRESENCE_UPDATE to every guild the user is in. The
+// manual status is left out, and so is the game of a user shown OFFLINE.
+func (h *Hub) BroadcastPresence(p model.UserPresence) {
+	p.ManualStatus = nil
+	if p.Status == model.StatusOffline {
+		p.GameName = nil
+		p.GameStartedAt = nil
+	}
+	guilds, err := h.guilds.GetByUserID(p.UserID)
+	if err != nil {
+		log.Printf("presence %s: list guilds: %v", p.UserID, err)
+		return
+	}
+	for _, g := range guilds {
+		h.BroadcastToGuild(g.ID, Event{Type: EventPresenceUpdate, Data: p})
+	}
+}
+
+// updateVoiceState validates a VOICE_STATE_UPDATE op and relays it to the
+// guild. Joining requires membership and a voice or video channel.
+func (c *Client) updateVoiceState(data VoiceStateData) {
+	if err := c.hub.requireGuildMember(data.GuildID, c.UserID); err != nil {
+		c.sendEvent(Event{Type: EventError, Data: errorData("VOICE_STATE_UPDATE", guildRoomPrefix+data.GuildID, err)})
+		return
+	}
+	if data.ChannelID != nil {
+		ch, err := c.hub.channels.GetByID(*data.ChannelID)
+		if err != nil || ch.GuildID != data.GuildID || ch.Type == model.ChannelText {
+			c.sendEvent(Event{Type: EventError, Data: ErrorData{
+				Op:      "VOICE_STATE_UPDATE",
+				Code:    ErrCodeInvalidPayload,
+				Message: "not a voice channel of this guild",
+			}})
+			return
+		}
+	}
+	data.UserID = c.UserID
+	c.hub.BroadcastToGuild(data.GuildID, Event{Type: EventVoiceStateUpdate, Data: data})
+}
@@ -0,0 +1,257 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// presenceGrace is how long a user stays ONLINE after their last
+	// connection dropped, so quick reconnects do not flap.
+	presenceGrace = 15 * time.Second
+	// presenceTTL bounds how long a connection counts as live without being
+	// refreshed, which cleans up after nodes that died without unregistering.
+	presenceTTL = 2 * pongWait
+	// presenceSweepInterval is how often users stored as online are checked
+	// against their live connections. This catches users whose node died
+	// before it could take them OFFLINE.
+	presenceSweepInterval = time.Minute
+)
+
+type IdleData struct {
+	Idle bool `json:"idle"`
+}
+
+func presenceConnsKey(userID string) string { return "presence:conns:" + userID }
+func presenceIdleKey(userID string) string  { return "presence:idle:" + userID }
+func presenceGraceKey(userID string) string { return "presence:grace:" + userID }
+
+// presenceSweepKey makes sure only one node sweeps per interval.
+const presenceSweepKey = "presence:sweep"
+
+// connect counts a new live connection for the user and flips them ONLINE if
+// it is their first one.
+func (h *Hub) connect(client *Client) {
+	h.trackConnection(client)
+	h.syncPresence(client.UserID)
+}
+
+// disconnect drops the connection from the live set. If it was the user's
+// last one they go OFFLINE once presenceGrace passes without a reconnect.
+func (h *Hub) disconnect(client *Client) {
+	if h.rdb == nil {
+		h.presenceMu.Lock()
+		delete(h.localConns[client.UserID], client.connID)
+		delete(h.localIdle[client.UserID], client.connID)
+		h.localGrace[client.UserID] = time.Now().Add(presenceGrace)
+		h.presenceMu.Unlock()
+	} else {
+		ctx := context.Background()
+		pipe := h.rdb.Pipeline()
+		pipe.ZRem(ctx, presenceConnsKey(client.UserID), client.connID)
+		pipe.SRem(ctx, presenceIdleKey(client.UserID), client.connID)
+		pipe.Set(ctx, presenceGraceKey(client.UserID), 1, presenceGrace)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("presence %s: remove connection: %v", client.UserID, err)
+		}
+	}
+
+	if live, _ := h.liveConnections(client.UserID); live > 0 {
+		h.syncPresence(client.UserID)
+		return
+	}
+	time.AfterFunc(presenceGrace, func() {
+		h.syncPresence(client.UserID)
+	})
+}
+
+// setIdle records whether this connection reports its user as idle.
+func (h *Hub) setIdle(client *Client, idle bool) {
+	if h.rdb == nil {
+		h.presenceMu.Lock()
+		if idle {
+			if h.localIdle[client.UserID] == nil {
+				h.localIdle[client.UserID] = make(map[string]bool)
+			}
+			h.localIdle[client.UserID][client.connID] = true
+		} else {
+			delete(h.localIdle[client.UserID], client.connID)
+		}
+		h.presenceMu.Unlock()
+	} else {
+		ctx := context.Background()
+		key := presenceIdleKey(client.UserID)
+		if idle {
+			pipe := h.rdb.Pipeline()
+			pipe.SAdd(ctx, key, client.connID)
+			pipe.Expire(ctx, key, presenceTTL)
+			_, _ = pipe.Exec(ctx)
+		} else {
+			h.rdb.SRem(ctx, key, client.connID)
+		}
+	}
+	h.syncPresence(client.UserID)
+}
+
+func (h *Hub) trackConnection(client *Client) {
+	if h.rdb == nil {
+		h.presenceMu.Lock()
+		if h.localConns[client.UserID] == nil {
+			h.localConns[client.UserID] = make(map[string]bool)
+		}
+		h.localConns[client.UserID][client.connID] = true
+		h.presenceMu.Unlock()
+		return
+	}
+	ctx := context.Background()
+	key := presenceConnsKey(client.UserID)
+	pipe := h.rdb.Pipeline()
+	pipe.ZAdd(ctx, key, redis.Z{
+		Score:  float64(time.Now().Add(presenceTTL).Unix()),
+		Member: client.connID,
+	})
+	pipe.Expire(ctx, key, presenceTTL)
+	pipe.Expire(ctx, presenceIdleKey(client.UserID), presenceTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("presence %s: track connection: %v", client.UserID, err)
+	}
+}
+
+// liveConnections returns how many connections the user has on all nodes and
+// how many of them are idle.
+func (h *Hub) liveConnections(userID string) (live, idle int) {
+	if h.rdb == nil {
+		h.presenceMu.Lock()
+		defer h.presenceMu.Unlock()
+		for id := range h.localConns[userID] {
+			live++
+			if h.localIdle[userID][id] {
+				idle++
+			}
+		}
+		return live, idle
+	}
+
+	ctx := context.Background()
+	key := presenceConnsKey(userID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	h.rdb.ZRemRangeByScore(ctx, key, "-inf", "("+now)
+	ids, err := h.rdb.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		log.Printf("presence %s: count connections: %v", userID, err)
+		return 0, 0
+	}
+	idleIDs, _ := h.rdb.SMembers(ctx, presenceIdleKey(userID)).Result()
+	idleSet := make(map[string]bool, len(idleIDs))
+	for _, id := range idleIDs {
+		idleSet[id] = true
+	}
+	for _, id := range ids {
+		live++
+		if idleSet[id] {
+			idle++
+		}
+	}
+	return live, idle
+}
+
+// inGrace reports whether the user lost their last connection less than
+// presenceGrace ago, on any node.
+func (h *Hub) inGrace(userID string) bool {
+	if h.rdb == nil {
+		h.presenceMu.Lock()
+		defer h.presenceMu.Unlock()
+		until, ok := h.localGrace[userID]
+		if ok && time.Now().After(until) {
+			delete(h.localGrace, userID)
+			return false
+		}
+		return ok
+	}
+	n, err := h.rdb.Exists(context.Background(), presenceGraceKey(userID)).Result()
+	return err == nil && n > 0
+}
+
+// sweepPresence takes users OFFLINE that are still stored as online although
+// none of their connections is live any more, e.g. because the node holding
+// them crashed and its grace timer never fired.
+func (h *Hub) sweepPresence() {
+	if h.rdb != nil {
+		locked, err := h.rdb.SetNX(context.Background(), presenceSweepKey, 1, presenceSweepInterval/2).Result()
+		if err != nil || !locked {
+			return
+		}
+	}
+	userIDs, err := h.presence.GetOnlineUserIDs()
+	if err != nil {
+		log.Printf("presence sweep: %v", err)
+		return
+	}
+	for _, userID := range userIDs {
+		if live, _ := h.liveConnections(userID); live == 0 && !h.inGrace(userID) {
+			h.syncPresence(userID)
+		}
+	}
+}
+
+// presenceStatus is the status others see for a user with live connections,
+// idle of them reporting inactivity, and the status they picked, if any.
+func presenceStatus(live, idle int, manual *string) string {
+	switch {
+	case live == 0:
+		return model.StatusOffline
+	case manual != nil && *manual == model.StatusInvisible:
+		return model.StatusOffline
+	case manual != nil:
+		return *manual
+	case idle == live:
+		return model.StatusIdle
+	}
+	return model.StatusOnline
+}
+
+// PresenceStatus returns the status others see for userID given the status
+// they picked, nil meaning none.
+func (h *Hub) PresenceStatus(userID string, manual *string) string {
+	live, idle := h.liveConnections(userID)
+	return presenceStatus(live, idle, manual)
+}
+
+// syncPresence derives the status from the live connections and the status
+// the user picked, and stores and broadcasts it when it changed.
+func (h *Hub) syncPresence(userID string) {
+	live, idle := h.liveConnections(userID)
+
+	current, err := h.presence.GetByUserID(userID)
+	if err != nil {
+		log.Printf("presence %s: load: %v", userID, err)
+		return
+	}
+
+	status := presenceStatus(live, idle, current.ManualStatus)
+	if status == current.Status {
+		return
+	}
+
+	if status == model.StatusOffline {
+		err = h.presence.SetOffline(userID)
+		current.GameName = nil
+		current.GameStartedAt = nil
+	} else {
+		current.Status = status
+		err = h.presence.Upsert(current)
+	}
+	if err != nil {
+		log.Printf("presence %s: store: %v", userID, err)
+		return
+	}
+	current.Status = status
+	current.UpdatedAt = time.Now()
+	h.BroadcastPresence(*current)
+}
@@ -0,0 +1,189 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/redis/go-redis/v9"
+)
+
+// forEachPresenceStore runs test against a hub without and with Redis,
+// passing a connection of otherUser that watches testGuild.
+func forEachPresenceStore(t *testing.T, test func(t *testing.T, h *Hub, mock sqlmock.Sqlmock, observer *Client)) {
+	for _, store := range []string{"local", "redis"} {
+		t.Run(store, func(t *testing.T) {
+			var h *Hub
+			var mock sqlmock.Sqlmock
+			if store == "redis" {
+				rdb := newTestRedis(t)
+				h, mock = newTestHubDB(t, NewRedisBroker(rdb), rdb)
+				observer := connectUser(t, h, otherUser)
+				h.Subscribe(observer.session.Load(), testRoom)
+				awaitRedisSubscribers(t, rdb, 1, userRoomPrefix+otherUser, testRoom)
+				test(t, h, mock, observer)
+			} else {
+				h, mock = newTestHubDB(t, NewMemoryBroker(), nil)
+				observer := connectUser(t, h, otherUser)
+				h.Subscribe(observer.session.Load(), testRoom)
+				test(t, h, mock, observer)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func expectPresence(mock sqlmock.Sqlmock, status string) {
+	expectManualPresence(mock, status, nil)
+}
+
+// expectManualPresence is expectPresence for a user who picked manual.
+func expectManualPresence(mock sqlmock.Sqlmock, status string, manual interface{}) {
+	mock.ExpectQuery(`FROM user_presence WHERE user_id = \$1`).WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status", "manual_status", "game_name", "game_started_at", "custom_status", "updated_at"}).
+			AddRow(testUser, status, manual, nil, nil, nil, time.Now()))
+}
+
+// expectStored expects status to be written and broadcast to testGuild.
+func expectStored(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectExec(`INSERT INTO user_presence`).WithArgs(testUser, status, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM guilds g JOIN members m`).WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "icon_url", "owner_id", "invite_code", "require_mfa", "created_at"}).
+			AddRow(testGuild, "guild", nil, otherUser, "invite", false, time.Now()))
+}
+
+func expectPresenceUpdate(t *testing.T, observer *Client, status string) {
+	t.Helper()
+	f := nextFrame(t, observer)
+	var p model.UserPresence
+	json.Unmarshal(f.Data, &p)
+	if f.Type != EventPresenceUpdate || p.UserID != testUser || p.Status != status {
+		t.Fatalf("got %s %s, want PRESENCE_UPDATE %s", f.Type, f.Data, status)
+	}
+}
+
+// expectNoPresenceUpdate checks that nothing reached the observer before a
+// marker sent to its own room.
+func expectNoPresenceUpdate(t *testing.T, h *Hub, observer *Client) {
+	t.Helper()
+	h.BroadcastToUser(otherUser, Event{Type: EventGuildRemove, Data: map[string]string{"marker": "user"}})
+	if f := nextFrame(t, observer); f.Type != EventGuildRemove {
+		t.Fatalf("got %s %s, want no presence change", f.Type, f.Data)
+	}
+}
+
+func TestPresenceAcrossDevices(t *testing.T) {
+	forEachPresenceStore(t, func(t *testing.T, h *Hub, mock sqlmock.Sqlmock, observer *Client) {
+		phone := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+		desktop := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+
+		expectPresence(mock, model.StatusOffline)
+		expectStored(mock, model.StatusOnline)
+		h.connect(phone)
+		expectPresenceUpdate(t, observer, model.StatusOnline)
+
+		expectPresence(mock, model.StatusOnline)
+		h.connect(desktop)
+		expectPresence(mock, model.StatusOnline)
+		h.setIdle(phone, true)
+		expectNoPresenceUpdate(t, h, observer)
+
+		// Only once every device is idle is the user.
+		expectPresence(mock, model.StatusOnline)
+		expectStored(mock, model.StatusIdle)
+		h.setIdle(desktop, true)
+		expectPresenceUpdate(t, observer, model.StatusIdle)
+
+		expectPresence(mock, model.StatusIdle)
+		h.disconnect(desktop)
+		expectNoPresenceUpdate(t, h, observer)
+
+		expectPresence(mock, model.StatusIdle)
+		expectStored(mock, model.StatusOnline)
+		h.setIdle(phone, false)
+		expectPresenceUpdate(t, observer, model.StatusOnline)
+	})
+}
+
+func TestPresenceKeepsDND(t *testing.T) {
+	forEachPresenceStore(t, func(t *testing.T, h *Hub, mock sqlmock.Sqlmock, observer *Client) {
+		c := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+		expectManualPresence(mock, model.StatusDND, model.StatusDND)
+		h.connect(c)
+		expectManualPresence(mock, model.StatusDND, model.StatusDND)
+		h.setIdle(c, true)
+		expectNoPresenceUpdate(t, h, observer)
+	})
+}
+
+func TestPresenceStatus(t *testing.T) {
+	status := func(s string) *string { return &s }
+	for _, tc := range []struct {
+		live, idle int
+		manual     *string
+		want       string
+	}{
+		{live: 0, want: model.StatusOffline},
+		{live: 2, idle: 1, want: model.StatusOnline},
+		{live: 2, idle: 2, want: model.StatusIdle},
+		{live: 1, manual: status(model.StatusDND), want: model.StatusDND},
+		{live: 1, idle: 1, manual: status(model.StatusDND), want: model.StatusDND},
+		{live: 1, manual: status(model.StatusIdle), want: model.StatusIdle},
+		{live: 1, manual: status(model.StatusInvisible), want: model.StatusOffline},
+		{live: 0, manual: status(model.StatusDND), want: model.StatusOffline},
+	} {
+		if got := presenceStatus(tc.live, tc.idle, tc.manual); got != tc.want {
+			manual := "<nil>"
+			if tc.manual != nil {
+				manual = *tc.manual
+			}
+			t.Errorf("presenceStatus(%d, %d, %s) = %s, want %s", tc.live, tc.idle, manual, got, tc.want)
+		}
+	}
+}
+
+func TestSweepPresenceTakesStaleUsersOffline(t *testing.T) {
+	rdb := newTestRedis(t)
+	h, mock := newTestHubDB(t, NewMemoryBroker(), rdb)
+	const (
+		stale     = "1a4b6c1e-6f0c-4c55-9b7e-0c2d6c4f1a01"
+		grace     = "1a4b6c1e-6f0c-4c55-9b7e-0c2d6c4f1a02"
+		connected = "1a4b6c1e-6f0c-4c55-9b7e-0c2d6c4f1a03"
+	)
+	ctx := context.Background()
+	rdb.Set(ctx, presenceGraceKey(grace), 1, presenceGrace)
+	rdb.ZAdd(ctx, presenceConnsKey(connected), redis.Z{
+		Score:  float64(time.Now().Add(presenceTTL).Unix()),
+		Member: "conn",
+	})
+
+	mock.ExpectQuery(`SELECT user_id FROM user_presence WHERE status <> 'OFFLINE'`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(stale).AddRow(grace).AddRow(connected))
+	mock.ExpectQuery(`SELECT user_id, status, manual_status`).WithArgs(stale).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status", "manual_status", "game_name", "game_started_at", "custom_status", "updated_at"}).
+			AddRow(stale, model.StatusOnline, nil, nil, nil, nil, time.Now()))
+	mock.ExpectExec(`UPDATE user_presence SET status = 'OFFLINE'`).WithArgs(stale).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM guilds`).WithArgs(stale).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	h.sweepPresence()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another node sweeping in the same interval does not query at all.
+	mock.ExpectQuery(`SELECT user_id FROM user_presence`).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	h.sweepPresence()
+	if err := mock.ExpectationsWereMet(); err == nil {
+		t.Fatal("second sweep within the interval ran")
+	}
+}
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"fmt"
+
+	"pwdh-aether/internal/model"
+)
+
+// ReadyData is the payload of the READY event that answers IDENTIFY. It
+// carries everything the client needs for its first render.
+type ReadyData struct {
+	SessionID     string                       `json:"session_id"`
+	User          model.UserResponse           `json:"user"`
+	Guilds        []ReadyGuild                 `json:"guilds"`
+	Conversations []model.ConversationResponse `json:"conversations"`
+	Presences     []model.UserPresence         `json:"presences"`
+}
+
+type ReadyGuild struct {
+	model.Guild
+	Channels []model.Channel `json:"channels"`
+}
+
+// sendReady builds the initial state for the client, subscribes it to its
+// guild and DM rooms and queues the READY event. Presences are only included
+// for connections with the GUILD_PRESENCES intent.
+func (h *Hub) sendReady(client *Client) error {
+	s := client.session.Load()
+	user, err := h.users.GetByID(client.UserID)
+	if err != nil {
+		return err
+	}
+
+	guilds, err := h.guilds.GetByUserID(client.UserID)
+	if err != nil {
+		return fmt.Errorf("guilds: %w", err)
+	}
+
+	ready := ReadyData{
+		SessionID:     s.id,
+		User:          user.ToResponse(),
+		Guilds:        []ReadyGuild{},
+		Conversations: []model.ConversationResponse{},
+		Presences:     []model.UserPresence{},
+	}
+
+	seen := make(map[string]bool)
+	for _, g := range guilds {
+		channels, err := h.channels.GetByGuildID(g.ID)
+		if err != nil {
+			return fmt.Errorf("channels: %w", err)
+		}
+		if channels == nil {
+			channels = []model.Channel{}
+		}
+		ready.Guilds = append(ready.Guilds, ReadyGuild{Guild: g, Channels: channels})
+		h.Subscribe(s, guildRoomPrefix+g.ID)
+
+		if !s.intents.Has(IntentGuildPresences) {
+			continue
+		}
+		presences, err := h.presence.GetByGuildID(g.ID)
+		if err != nil {
+			return fmt.Errorf("presences: %w", err)
+		}
+		for _, p := range presences {
+			if !seen[p.UserID] {
+				seen[p.UserID] = true
+				ready.Presences = append(ready.Presences, p)
+			}
+		}
+	}
+
+	convs, err := h.convs.GetByUserID(client.UserID)
+	if err != nil {
+		return fmt.Errorf("conversations: %w", err)
+	}
+	for _, conv := range convs {
+		members, _ := h.convs.GetMembers(conv.ID)
+		memberResponses := []model.UserResponse{}
+		for _, m := range members {
+			memberResponses = append(memberResponses, m.ToResponse())
+		}
+		ready.Conversations = append(ready.Conversations, model.ConversationResponse{
+			ID:        conv.ID,
+			IsGroup:   conv.IsGroup,
+			Name:      conv.Name,
+			Members:   memberResponses,
+			CreatedAt: conv.CreatedAt,
+		})
+		h.Subscribe(s, dmRoomPrefix+conv.ID)
+	}
+
+	client.sendEvent(Event{Type: EventReady, Data: ready})
+	return nil
+}
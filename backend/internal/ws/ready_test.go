@@ -0,0 +1,73 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testConversation = "3a4b5c6d-7e8f-4091-a2b3-c4d5e6f7a8b9"
+
+func TestReadyCarriesStateAndSubscribes(t *testing.T) {
+	h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+	c := connectUser(t, h, testUser)
+	now := time.Now()
+
+	mock.ExpectQuery(`FROM users WHERE id = \$1`).WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "username", "email", "password_hash", "avatar_url", "totp_enabled",
+			"email_verified_at", "deletion_scheduled_at", "bot", "created_at",
+		}).AddRow(testUser, "alice", "alice@example.com", "hash", nil, false, nil, nil, false, now))
+	mock.ExpectQuery(`FROM guilds g JOIN members m`).WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "icon_url", "owner_id", "invite_code", "require_mfa", "created_at"}).
+			AddRow(testGuild, "guild", nil, otherUser, "invite", false, now))
+	mock.ExpectQuery(`FROM channels WHERE guild_id = \$1`).WithArgs(testGuild).WillReturnRows(channelRows())
+	mock.ExpectQuery(`FROM user_presence up JOIN members m`).WithArgs(testGuild).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status", "game_name", "game_started_at", "custom_status", "updated_at"}).
+			AddRow(otherUser, "online", nil, nil, nil, now))
+	mock.ExpectQuery(`FROM conversations c JOIN conversation_members cm`).WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_group", "name", "created_at"}).
+			AddRow(testConversation, false, nil, now))
+	mock.ExpectQuery(`FROM conversation_members cm JOIN users u`).WithArgs(testConversation).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "avatar_url", "created_at"}).
+			AddRow(otherUser, "bob", "bob@example.com", nil, now))
+
+	if err := h.sendReady(c); err != nil {
+		t.Fatal(err)
+	}
+	f := nextFrame(t, c)
+	if f.Type != EventReady {
+		t.Fatalf("got %s, want READY", f.Type)
+	}
+	var ready struct {
+		User   struct{ ID string } `json:"user"`
+		Guilds []struct {
+			ID       string `json:"id"`
+			Channels []struct {
+				ID string `json:"id"`
+			} `json:"channels"`
+		} `json:"guilds"`
+		Conversations []struct {
+			ID string `json:"id"`
+		} `json:"conversations"`
+		Presences []struct {
+			UserID string `json:"user_id"`
+		} `json:"presences"`
+	}
+	if err := json.Unmarshal(f.Data, &ready); err != nil {
+		t.Fatal(err)
+	}
+	if ready.User.ID != testUser || len(ready.Guilds) != 1 || ready.Guilds[0].ID != testGuild ||
+		len(ready.Guilds[0].Channels) != 1 || len(ready.Conversations) != 1 ||
+		len(ready.Presences) != 1 || ready.Presences[0].UserID != otherUser {
+		t.Fatalf("unexpected READY %s", f.Data)
+	}
+	if !inRoom(h, c, testRoom) || !inRoom(h, c, dmRoomPrefix+testConversation) {
+		t.Fatal("READY did not subscribe the guild and DM rooms")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
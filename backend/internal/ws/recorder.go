@@ -0,0 +1,149 @@
+package ws
+
+import (
+	"context"
+	"log"
+)
+
+const (
+	// recordQueueSize bounds the frames waiting to be written to the replay
+	// buffers. A session whose frame does not fit can no longer be resumed.
+	recordQueueSize = 4096
+	// recordBatchSize is the most operations written in one pipeline.
+	recordBatchSize = 256
+)
+
+// recordOp is one change to the replay state in Redis: a frame appended to a
+// session's buffer, a refresh of its TTL or its deletion. An op with flushed
+// set only reports that everything queued before it was written.
+type recordOp struct {
+	session *session
+	frame   outFrame
+	end     bool
+	flushed chan struct{}
+}
+
+// record queues a numbered frame for the replay buffer of s without waiting
+// for Redis, and reports whether it fit into the queue.
+func (h *Hub) record(s *session, f outFrame) bool {
+	if h.rdb == nil {
+		return true
+	}
+	select {
+	case h.records <- recordOp{session: s, frame: f}:
+		return true
+	default:
+		log.Printf("session %s: replay queue full", s.id)
+		return false
+	}
+}
+
+// refreshSession keeps a connected session that receives no frames from
+// expiring in Redis.
+func (h *Hub) refreshSession(s *session) {
+	if h.rdb == nil || s == nil {
+		return
+	}
+	select {
+	case h.records <- recordOp{session: s}:
+	default:
+	}
+}
+
+// endSession deletes the replay state of s so it can no longer be resumed on
+// another node.
+func (h *Hub) endSession(s *session) {
+	if h.rdb == nil {
+		return
+	}
+	select {
+	case h.records <- recordOp{session: s, end: true}:
+	default:
+		log.Printf("session %s: replay queue full, left to expire", s.id)
+	}
+}
+
+// flushRecords waits until everything queued so far has been written.
+func (h *Hub) flushRecords() {
+	h.flushRecordsContext(context.Background())
+}
+
+// flushRecordsContext is flushRecords, but gives up once ctx is done.
+func (h *Hub) flushRecordsContext(ctx context.Context) error {
+	if h.rdb == nil {
+		return nil
+	}
+	flushed := make(chan struct{})
+	select {
+	case h.records <- recordOp{flushed: flushed}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runRecorder writes queued ops to Redis in batches, so a slow Redis delays
+// replay buffers rather than the connections.
+func (h *Hub) runRecorder() {
+	for op := range h.records {
+		batch := append(make([]recordOp, 0, recordBatchSize), op)
+	collect:
+		for len(batch) < recordBatchSize {
+			select {
+			case op := <-h.records:
+				batch = append(batch, op)
+			default:
+				break collect
+			}
+		}
+		h.writeRecords(batch)
+	}
+}
+
+func (h *Hub) writeRecords(batch []recordOp) {
+	ctx := context.Background()
+	pipe := h.rdb.Pipeline()
+	touched := make(map[string]bool)
+	var flushed []chan struct{}
+	for _, op := range batch {
+		switch {
+		case op.flushed != nil:
+			flushed = append(flushed, op.flushed)
+		case op.end:
+			pipe.Del(ctx, sessionKey(op.session.id), sessionBufferKey(op.session.id), sessionRoomsKey(op.session.id))
+			delete(touched, op.session.id)
+		default:
+			if op.frame.data != nil {
+				pipe.RPush(ctx, sessionBufferKey(op.session.id), op.frame.data)
+			}
+			touched[op.session.id] = true
+		}
+	}
+	for id := range touched {
+		pipe.LTrim(ctx, sessionBufferKey(id), -replayBufferSize, -1)
+		pipe.Expire(ctx, sessionKey(id), sessionTTL)
+		pipe.Expire(ctx, sessionBufferKey(id), sessionTTL)
+		pipe.Expire(ctx, sessionRoomsKey(id), sessionTTL)
+	}
+
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("replay buffer: write %d ops: %v", len(batch), err)
+			for _, op := range batch {
+				if op.frame.data != nil {
+					op.session.mu.Lock()
+					op.session.lost = true
+					op.session.mu.Unlock()
+				}
+			}
+		}
+	}
+	for _, ch := range flushed {
+		close(ch)
+	}
+}
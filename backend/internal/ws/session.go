@@ -0,0 +1,589 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	sessionTTL       = 5 * time.Minute
+	replayBufferSize = 500
+	// takeoverTimeout is how long a RESUME waits for the node holding the
+	// session to hand it over before answering INVALID_SESSION.
+	takeoverTimeout = 3 * time.Second
+)
+
+// Replies that only make sense on the connection they were sent to carry no
+// sequence number and are never buffered for replay.
+var unsequenced = map[string]bool{
+	EventHello:          true,
+	EventHeartbeatAck:   true,
+	EventAck:            true,
+	EventError:          true,
+	EventResumed:        true,
+	EventInvalidSession: true,
+	EventReconnect:      true,
+}
+
+type ResumeData struct {
+	SessionID string `json:"session_id"`
+	Seq       int64  `json:"seq"`
+}
+
+// session is the resumable part of a connection: its ID, sequence counter and
+// rooms. Frames are numbered and recorded for replay when they are fanned out
+// to the session, so a session keeps collecting what is published to its
+// rooms after its connection dropped, for up to sessionTTL or until a RESUME
+// attaches a new connection to it.
+type session struct {
+	id      string
+	userID  string
+	intents Intents
+	hub     *Hub
+	// rooms is guarded by hub.mu.
+	rooms map[string]bool
+
+	mu         sync.Mutex
+	seq        int64
+	client     *Client
+	detachedAt time.Time
+	// While a RESUME replays, frames for the session wait in pending and are
+	// numbered once the replay is out. After a takeover, pending may start
+	// with frames the previous node already recorded (overlap).
+	resuming bool
+	overlap  bool
+	pending  [][]byte
+	// lost is set once a frame could not be recorded, closed once the
+	// session ended or moved to another node.
+	lost   bool
+	closed bool
+}
+
+// outFrame is a frame queued for a connection with its sequence number, or 0
+// for unsequenced replies. shared is the frame it was stamped from, if it
+// came out of a fan-out.
+type outFrame struct {
+	data   []byte
+	seq    int64
+	shared *sharedFrame
+}
+
+func sessionKey(id string) string       { return "gateway:session:" + id }
+func sessionBufferKey(id string) string { return "gateway:session:" + id + ":buffer" }
+func sessionRoomsKey(id string) string  { return "gateway:session:" + id + ":rooms" }
+
+// resumable reports whether sessions outlive their connection. The replay
+// buffer lives in Redis, so without it there is nothing to resume.
+func (h *Hub) resumable() bool {
+	return h.rdb != nil
+}
+
+// newSession starts a session on client and records its owner and intents,
+// so a RESUME on another node can be checked against them.
+func (h *Hub) newSession(client *Client) *session {
+	s := &session{
+		id:      uuid.New().String(),
+		userID:  client.UserID,
+		intents: client.intents,
+		hub:     h,
+		rooms:   make(map[string]bool),
+		client:  client,
+	}
+	if h.rdb != nil {
+		ctx := context.Background()
+		pipe := h.rdb.TxPipeline()
+		pipe.HSet(ctx, sessionKey(s.id), "user_id", s.userID, "intents", uint32(s.intents))
+		pipe.Expire(ctx, sessionKey(s.id), sessionTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("session %s: create: %v", s.id, err)
+		}
+	}
+
+	h.mu.Lock()
+	h.sessions[s.id] = s
+	h.mu.Unlock()
+	client.session.Store(s)
+	return s
+}
+
+// deliver numbers a frame published to roomID ("" for events addressed to
+// the session directly), records it for replay and queues it for the
+// attached connection, if any.
+func (s *session) deliver(roomID string, frame *sharedFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+	case s.resuming:
+		if len(s.pending) < replayBufferSize {
+			s.pending = append(s.pending, frame.data)
+		} else {
+			s.lost = true
+		}
+	default:
+		s.emit(roomID, frame)
+	}
+}
+
+// emit must be called with s.mu held.
+func (s *session) emit(roomID string, frame *sharedFrame) {
+	s.seq++
+	f := outFrame{data: stamp(frame.data, s.seq), seq: s.seq, shared: frame}
+	if !s.hub.record(s, f) {
+		s.lost = true
+	}
+	if s.client != nil {
+		s.client.enqueue(roomID, f, s.hub.resumable() && !s.lost)
+	}
+}
+
+// lastSeq returns the sequence number of the last frame numbered for the
+// connection's session, or 0 before IDENTIFY.
+func (c *Client) lastSeq() int64 {
+	s := c.session.Load()
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// stamp adds the sequence number to an encoded event. Events are marshalled
+// from Event, so the frame is a JSON object and ends in '}'.
+func stamp(data []byte, seq int64) []byte {
+	out := make([]byte, 0, len(data)+24)
+	out = append(out, data[:len(data)-1]...)
+	out = append(out, `,"s":`...)
+	out = strconv.AppendInt(out, seq, 10)
+	return append(out, '}')
+}
+
+// unstamp is the inverse of stamp.
+func unstamp(data []byte, seq int64) []byte {
+	suffix := len(`,"s":}`) + len(strconv.FormatInt(seq, 10))
+	if len(data) <= suffix {
+		return data
+	}
+	out := make([]byte, 0, len(data)-suffix+1)
+	out = append(out, data[:len(data)-suffix]...)
+	return append(out, '}')
+}
+
+// detach is called once client's connection is gone. Its session keeps
+// recording for a RESUME unless it could not be resumed anyway.
+func (h *Hub) detach(client *Client) {
+	s := client.session.Load()
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.client != client {
+		s.mu.Unlock()
+		return
+	}
+	s.client = nil
+	s.detachedAt = time.Now()
+	if s.resuming {
+		// The connection died during its replay. What arrived meanwhile
+		// is numbered now, so a later RESUME finds it in the buffer.
+		s.resuming = false
+		for _, data := range s.pending {
+			s.emit("", newSharedFrame(data))
+		}
+		s.pending = nil
+	}
+	keep := h.resumable() && !s.lost && !s.closed && !client.revoked.Load()
+	if !keep {
+		s.closed = true
+	}
+	s.mu.Unlock()
+
+	if !keep {
+		h.dropSession(s)
+	}
+}
+
+// dropSession ends a session for good: it leaves its rooms and its replay
+// state is deleted.
+func (h *Hub) dropSession(s *session) {
+	h.mu.Lock()
+	emptied := h.removeSession(s)
+	h.mu.Unlock()
+	h.noteInterest(emptied...)
+
+	s.mu.Lock()
+	s.closed = true
+	s.client = nil
+	s.pending = nil
+	s.mu.Unlock()
+	h.endSession(s)
+}
+
+// removeSession takes s out of the session and room indexes and must be
+// called with h.mu held. It returns the rooms that became empty.
+func (h *Hub) removeSession(s *session) []string {
+	if h.sessions[s.id] == s {
+		delete(h.sessions, s.id)
+	}
+	var emptied []string
+	for roomID := range s.rooms {
+		if h.removeMember(s, roomID) {
+			emptied = append(emptied, roomID)
+		}
+	}
+	return emptied
+}
+
+// expireSessions drops sessions that were detached for longer than
+// sessionTTL without being resumed.
+func (h *Hub) expireSessions() {
+	var expired []*session
+	var emptied []string
+	h.mu.Lock()
+	for _, s := range h.sessions {
+		s.mu.Lock()
+		if s.client == nil && !s.resuming && time.Since(s.detachedAt) > sessionTTL {
+			s.closed = true
+			expired = append(expired, s)
+		}
+		s.mu.Unlock()
+	}
+	for _, s := range expired {
+		emptied = append(emptied, h.removeSession(s)...)
+	}
+	h.mu.Unlock()
+
+	h.noteInterest(emptied...)
+	for _, s := range expired {
+		h.endSession(s)
+	}
+}
+
+func (h *Hub) rememberRoom(s *session, roomID string) {
+	if h.rdb == nil {
+		return
+	}
+	ctx := context.Background()
+	pipe := h.rdb.Pipeline()
+	pipe.SAdd(ctx, sessionRoomsKey(s.id), roomID)
+	pipe.Expire(ctx, sessionRoomsKey(s.id), sessionTTL)
+	_, _ = pipe.Exec(ctx)
+}
+
+func (h *Hub) forgetRoom(s *session, roomID string) {
+	if h.rdb == nil {
+		return
+	}
+	h.rdb.SRem(context.Background(), sessionRoomsKey(s.id), roomID)
+}
+
+// resume continues the session named in data on this connection: same
+// session ID, same sequence counter. It returns the frames the client missed
+// after data.Seq, which the caller sends before calling finishResume; frames
+// published meanwhile wait in the session and follow the replay. A session
+// held by another node is taken over from it first. If the session is gone,
+// belongs to someone else or its buffer no longer reaches back to data.Seq,
+// the client gets INVALID_SESSION and may IDENTIFY instead.
+func (c *Client) resume(data ResumeData) ([]outFrame, bool) {
+	h := c.hub
+	if !h.resumable() || data.SessionID == "" || c.session.Load() != nil {
+		c.invalidSession(data.SessionID)
+		return nil, false
+	}
+
+	s, last, held := h.attachLocal(c, data.SessionID)
+	if !held {
+		s, last = h.takeOver(c, data.SessionID)
+	}
+	if s == nil {
+		c.invalidSession(data.SessionID)
+		return nil, false
+	}
+	c.session.Store(s)
+
+	if held {
+		// A node handing a session over writes its frames before it
+		// answers; here they may still be queued.
+		h.flushRecords()
+	}
+	buffered, err := h.loadBuffer(s.id)
+	replay, ok := missedFrames(buffered, data.Seq, last)
+	if err != nil || !ok {
+		c.session.Store(nil)
+		h.dropSession(s)
+		c.invalidSession(data.SessionID)
+		return nil, false
+	}
+
+	s.mu.Lock()
+	s.seq = last
+	if s.overlap {
+		s.pending = trimOverlap(s.pending, buffered)
+		s.overlap = false
+	}
+	s.mu.Unlock()
+	return replay, true
+}
+
+// finishResume is called once the replayed frames are queued. It confirms
+// the resume with RESUMED and releases the frames that arrived meanwhile.
+func (c *Client) finishResume(replayed int) {
+	s := c.session.Load()
+	data, err := json.Marshal(Event{
+		Type: EventResumed,
+		Data: map[string]interface{}{"session_id": s.id, "replayed": replayed},
+	})
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != c {
+		return
+	}
+	c.enqueue("", outFrame{data: data}, false)
+	for _, data := range s.pending {
+		s.emit("", newSharedFrame(data))
+	}
+	s.pending = nil
+	s.resuming = false
+}
+
+// attachLocal moves a session held by this node onto client, closing the
+// connection it was attached to, if any. held reports whether this node
+// holds the session at all; last is the sequence number the replay has to
+// reach.
+func (h *Hub) attachLocal(client *Client, id string) (s *session, last int64, held bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s = h.sessions[id]
+	if s == nil {
+		return nil, 0, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.userID != client.UserID || s.closed || s.lost || s.resuming {
+		return nil, 0, true
+	}
+	if old := s.client; old != nil {
+		go old.closeWith(CloseSessionResumed, "session resumed elsewhere")
+	}
+	s.client = client
+	s.resuming = true
+	return s, s.seq, true
+}
+
+// takeOver asks the node holding session id to hand it over and attaches it
+// to client. The session's rooms are joined before asking, so whatever is
+// published while the other node lets go reaches this one too; resume drops
+// the frames the other node still recorded. It returns nil if the session
+// does not exist, belongs to someone else or no node answered in time.
+func (h *Hub) takeOver(client *Client, id string) (*session, int64) {
+	ctx := context.Background()
+	fields, err := h.rdb.HMGet(ctx, sessionKey(id), "user_id", "intents").Result()
+	if err != nil || fields[0] != client.UserID {
+		return nil, 0
+	}
+	intents := IntentsAll
+	if v, ok := fields[1].(string); ok {
+		if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+			intents = Intents(n)
+		}
+	}
+	rooms, err := h.rdb.SMembers(ctx, sessionRoomsKey(id)).Result()
+	if err != nil {
+		return nil, 0
+	}
+	var allowed []string
+	for _, roomID := range rooms {
+		if err := h.Authorize(client.UserID, roomID); err == nil {
+			allowed = append(allowed, roomID)
+		}
+	}
+
+	s := &session{
+		id:       id,
+		userID:   client.UserID,
+		intents:  intents,
+		hub:      h,
+		rooms:    make(map[string]bool),
+		client:   client,
+		resuming: true,
+		overlap:  true,
+	}
+	var created []string
+	h.mu.Lock()
+	if h.sessions[id] != nil {
+		h.mu.Unlock()
+		return nil, 0
+	}
+	h.sessions[id] = s
+	for _, roomID := range allowed {
+		if h.addMember(s, roomID) {
+			created = append(created, roomID)
+		}
+	}
+	h.mu.Unlock()
+	h.noteInterest(created...)
+	h.awaitInterest()
+
+	nonce := uuid.New().String()
+	released := make(chan int64, 1)
+	h.takeoverMu.Lock()
+	h.takeovers[nonce] = released
+	h.takeoverMu.Unlock()
+	defer func() {
+		h.takeoverMu.Lock()
+		delete(h.takeovers, nonce)
+		h.takeoverMu.Unlock()
+	}()
+
+	h.BroadcastToRoom(controlRoom, Event{
+		Data: controlMessage{Op: controlSessionTakeover, UserID: client.UserID, SessionID: id, Nonce: nonce},
+	})
+	select {
+	case last := <-released:
+		return s, last
+	case <-time.After(takeoverTimeout):
+		h.dropSession(s)
+		return nil, 0
+	}
+}
+
+// releaseSession hands a session held here to the node that asked for it.
+// The session stops at this point of the broker stream; once its frames are
+// written, the final sequence number goes back to the new holder.
+func (h *Hub) releaseSession(msg controlMessage) {
+	h.takeoverMu.Lock()
+	_, mine := h.takeovers[msg.Nonce]
+	h.takeoverMu.Unlock()
+	if mine {
+		return
+	}
+
+	h.mu.Lock()
+	s := h.sessions[msg.SessionID]
+	if s == nil || s.userID != msg.UserID {
+		h.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	if s.resuming {
+		s.mu.Unlock()
+		h.mu.Unlock()
+		return
+	}
+	s.closed = true
+	last, old, lost := s.seq, s.client, s.lost
+	s.client = nil
+	s.mu.Unlock()
+	emptied := h.removeSession(s)
+	h.mu.Unlock()
+	h.noteInterest(emptied...)
+
+	if old != nil {
+		go old.closeWith(CloseSessionResumed, "session resumed elsewhere")
+	}
+	if lost {
+		// The buffer has gaps; the new holder times out and the client
+		// starts over with IDENTIFY.
+		return
+	}
+	go func() {
+		h.flushRecords()
+		h.BroadcastToRoom(controlRoom, Event{
+			Data: controlMessage{Op: controlSessionReleased, SessionID: s.id, Nonce: msg.Nonce, Seq: last},
+		})
+	}()
+}
+
+// sessionReleased passes the final sequence number of a released session to
+// the RESUME waiting for it.
+func (h *Hub) sessionReleased(msg controlMessage) {
+	h.takeoverMu.Lock()
+	released := h.takeovers[msg.Nonce]
+	h.takeoverMu.Unlock()
+	if released != nil {
+		select {
+		case released <- msg.Seq:
+		default:
+		}
+	}
+}
+
+func (h *Hub) loadBuffer(sessionID string) ([]string, error) {
+	return h.rdb.LRange(context.Background(), sessionBufferKey(sessionID), 0, -1).Result()
+}
+
+// missedFrames picks the frames after seq up to last from a replay buffer. It
+// fails if the buffer does not cover that range, e.g. because older frames
+// were trimmed.
+func missedFrames(buffered []string, seq, last int64) ([]outFrame, bool) {
+	if seq > last {
+		return nil, false
+	}
+	var missed []outFrame
+	next := seq + 1
+	for _, raw := range buffered {
+		var f struct {
+			Seq int64 `json:"s"`
+		}
+		if err := json.Unmarshal([]byte(raw), &f); err != nil {
+			return nil, false
+		}
+		if f.Seq <= seq || f.Seq > last {
+			continue
+		}
+		if f.Seq != next {
+			return nil, false
+		}
+		missed = append(missed, outFrame{data: []byte(raw), seq: f.Seq})
+		next++
+	}
+	return missed, next == last+1
+}
+
+// trimOverlap drops the frames at the start of pending that the previous
+// holder of a session had already recorded, i.e. the longest prefix of
+// pending that matches the end of the buffer.
+func trimOverlap(pending [][]byte, buffered []string) [][]byte {
+	tail := make([][]byte, len(buffered))
+	for i, raw := range buffered {
+		var f struct {
+			Seq int64 `json:"s"`
+		}
+		_ = json.Unmarshal([]byte(raw), &f)
+		tail[i] = unstamp([]byte(raw), f.Seq)
+	}
+
+	for n := min(len(pending), len(tail)); n > 0; n-- {
+		match := true
+		for i := 0; i < n; i++ {
+			if !bytes.Equal(pending[i], tail[len(tail)-n+i]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return pending[n:]
+		}
+	}
+	return pending
+}
+
+func (c *Client) invalidSession(sessionID string) {
+	c.sendEvent(Event{
+		Type: EventInvalidSession,
+		Data: map[string]string{"session_id": sessionID},
+	})
+}
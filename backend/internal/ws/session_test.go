@@ -0,0 +1,266 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testUser = "0b7c7e0e-4a43-4c39-9d55-2f1a4d0c8a11"
+
+// newTestHub starts a hub on broker. Its repositories sit on a database
+// without expectations, so every query fails. rdb may be nil.
+func newTestHub(t *testing.T, broker Broker, rdb *redis.Client) *Hub {
+	t.Helper()
+	h, _ := newTestHubDB(t, broker, rdb)
+	return h
+}
+
+// newTestHubDB is newTestHub for tests that set up expectations on the
+// database.
+func newTestHubDB(t *testing.T, broker Broker, rdb *redis.Client) (*Hub, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHub(
+		broker,
+		rdb,
+		repository.NewUserRepository(db),
+		repository.NewGuildRepository(db),
+		repository.NewChannelRepository(db),
+		repository.NewConversationRepository(db),
+		repository.NewPresenceRepository(db),
+	)
+	go h.Run()
+	t.Cleanup(func() {
+		broker.Close()
+		db.Close()
+	})
+	return h, mock
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// connectTestClient registers a client with a fresh session in the user's
+// room, like IDENTIFY minus presence and READY.
+func connectTestClient(t *testing.T, h *Hub) (*Client, *session) {
+	t.Helper()
+	c := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+	h.register <- c
+	s := h.newSession(c)
+	h.Subscribe(s, userRoomPrefix+testUser)
+	h.awaitInterest()
+	return c, s
+}
+
+type testFrame struct {
+	Type string          `json:"t"`
+	Data json.RawMessage `json:"d"`
+	Seq  int64           `json:"s"`
+}
+
+func nextFrame(t *testing.T, c *Client) testFrame {
+	t.Helper()
+	select {
+	case f := <-c.send:
+		var decoded testFrame
+		if err := json.Unmarshal(f.data, &decoded); err != nil {
+			t.Fatalf("decode %s: %v", f.data, err)
+		}
+		if decoded.Seq != f.seq {
+			t.Fatalf("frame %s queued with seq %d", f.data, f.seq)
+		}
+		return decoded
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame")
+		return testFrame{}
+	}
+}
+
+func publish(h *Hub, n int) {
+	h.BroadcastToUser(testUser, Event{Type: EventGuildRemove, Data: map[string]int{"n": n}})
+}
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func sessionSeq(s *session) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+func expectEvent(t *testing.T, c *Client, eventType string, seq int64) testFrame {
+	t.Helper()
+	f := nextFrame(t, c)
+	if f.Type != eventType || f.Seq != seq {
+		t.Fatalf("got %s s=%d, want %s s=%d", f.Type, f.Seq, eventType, seq)
+	}
+	return f
+}
+
+func TestResumeReplaysFramesPublishedWhileDisconnected(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), newTestRedis(t))
+	c1, s := connectTestClient(t, h)
+
+	publish(h, 1)
+	expectEvent(t, c1, EventGuildRemove, 1)
+
+	h.unregister <- c1
+	waitFor(t, "detach", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.client == nil
+	})
+
+	publish(h, 2)
+	publish(h, 3)
+	waitFor(t, "frames for the detached session", func() bool { return sessionSeq(s) == 3 })
+
+	c2 := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON})
+	h.register <- c2
+	replay, ok := c2.resume(ResumeData{SessionID: s.id, Seq: 1})
+	if !ok {
+		t.Fatal("resume failed")
+	}
+	if got := c2.session.Load(); got != s {
+		t.Fatal("resume did not continue the old session")
+	}
+	if len(replay) != 2 || replay[0].seq != 2 || replay[1].seq != 3 {
+		t.Fatalf("replayed %+v, want s=2 and s=3", replay)
+	}
+
+	// Published during the replay: held back, then numbered after it.
+	publish(h, 4)
+	waitFor(t, "pending frame", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.pending) == 1
+	})
+	for _, f := range replay {
+		c2.send <- f
+	}
+	c2.finishResume(len(replay))
+
+	expectEvent(t, c2, EventGuildRemove, 2)
+	expectEvent(t, c2, EventGuildRemove, 3)
+	resumed := expectEvent(t, c2, EventResumed, 0)
+	var data struct {
+		SessionID string `json:"session_id"`
+		Replayed  int    `json:"replayed"`
+	}
+	json.Unmarshal(resumed.Data, &data)
+	if data.SessionID != s.id || data.Replayed != 2 {
+		t.Fatalf("RESUMED %s", resumed.Data)
+	}
+	expectEvent(t, c2, EventGuildRemove, 4)
+
+	publish(h, 5)
+	expectEvent(t, c2, EventGuildRemove, 5)
+}
+
+func TestResumeFailsWhenBufferWasTrimmed(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), newTestRedis(t))
+	c1, s := connectTestClient(t, h)
+	h.unregister <- c1
+
+	for i := 1; i <= replayBufferSize+1; i++ {
+		publish(h, i)
+	}
+	waitFor(t, "frames", func() bool { return sessionSeq(s) == replayBufferSize+1 })
+
+	c2 := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON})
+	h.register <- c2
+	if _, ok := c2.resume(ResumeData{SessionID: s.id, Seq: 0}); ok {
+		t.Fatal("resumed past a trimmed buffer")
+	}
+	expectEvent(t, c2, EventInvalidSession, 0)
+
+	// The session is gone for good, also for a seq the buffer covers.
+	c3 := NewClient(h, nil, testUser, ConnectOptions{Encoding: EncodingJSON})
+	h.register <- c3
+	if _, ok := c3.resume(ResumeData{SessionID: s.id, Seq: replayBufferSize}); ok {
+		t.Fatal("resumed a dropped session")
+	}
+}
+
+func TestResumeTakesSessionOverFromAnotherNode(t *testing.T) {
+	rdb := newTestRedis(t)
+	nodeA := newTestHub(t, NewRedisBroker(rdb), rdb)
+	nodeB := newTestHub(t, NewRedisBroker(rdb), rdb)
+	c1, s := connectTestClient(t, nodeA)
+
+	publish(nodeA, 1)
+	expectEvent(t, c1, EventGuildRemove, 1)
+	nodeA.unregister <- c1
+	publish(nodeB, 2)
+	waitFor(t, "frame for the detached session", func() bool { return sessionSeq(s) == 2 })
+
+	c2 := NewClient(nodeB, nil, testUser, ConnectOptions{Encoding: EncodingJSON})
+	nodeB.register <- c2
+	replay, ok := c2.resume(ResumeData{SessionID: s.id, Seq: 1})
+	if !ok {
+		t.Fatal("resume failed")
+	}
+	if len(replay) != 1 || replay[0].seq != 2 {
+		t.Fatalf("replayed %+v, want s=2", replay)
+	}
+	for _, f := range replay {
+		c2.send <- f
+	}
+	c2.finishResume(len(replay))
+	expectEvent(t, c2, EventGuildRemove, 2)
+	expectEvent(t, c2, EventResumed, 0)
+
+	nodeA.mu.RLock()
+	_, held := nodeA.sessions[s.id]
+	nodeA.mu.RUnlock()
+	if held {
+		t.Fatal("node A still holds the session")
+	}
+
+	publish(nodeA, 3)
+	expectEvent(t, c2, EventGuildRemove, 3)
+}
+
+func TestTrimOverlap(t *testing.T) {
+	raw := func(n int) []byte {
+		data, _ := json.Marshal(Event{Type: EventGuildRemove, Data: map[string]int{"n": n}})
+		return data
+	}
+	var buffered []string
+	for i := 1; i <= 4; i++ {
+		buffered = append(buffered, string(stamp(raw(i), int64(i))))
+	}
+
+	pending := trimOverlap([][]byte{raw(3), raw(4), raw(5)}, buffered)
+	if len(pending) != 1 || string(pending[0]) != string(raw(5)) {
+		t.Fatalf("kept %q, want only frame 5", pending)
+	}
+	if pending := trimOverlap([][]byte{raw(5)}, buffered); len(pending) != 1 {
+		t.Fatalf("dropped a frame without overlap")
+	}
+}
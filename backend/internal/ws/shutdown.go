@@ -0,0 +1,103 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// reconnectSpread is the window RECONNECT delays are drawn from, so clients
+// of a draining node do not all come back at the same moment.
+const reconnectSpread = 10 * time.Second
+
+// ReconnectData tells the client how long to wait, in milliseconds, before
+// reconnecting and resuming its session.
+type ReconnectData struct {
+	Delay int64 `json:"delay"`
+}
+
+// Shutdown drains the hub before the process exits. New connections are
+// refused, every client gets a RECONNECT with a jittered delay, send queues
+// are flushed and connections are closed with a going-away code. The
+// sessions keep recording until a RESUME on another node takes them over,
+// so Shutdown returns once all clients are gone, every session was taken
+// over and the recorder has written everything to Redis, or ctx is done.
+// Sessions left then can no longer be resumed.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+
+	clients := h.snapshotClients()
+	log.Printf("Draining gateway: %d connections", len(clients))
+	for _, client := range clients {
+		delay := time.Duration(rand.Int63n(int64(reconnectSpread)))
+		client.sendEvent(Event{Type: EventReconnect, Data: ReconnectData{Delay: delay.Milliseconds()}})
+	}
+
+	h.waitFlushed(ctx, clients)
+
+	// Clients that registered while the RECONNECTs went out are closed too.
+	for _, client := range h.snapshotClients() {
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+	// What the sessions recorded up to now must be in Redis before their
+	// clients resume on another node.
+	if err := h.flushRecordsContext(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mu.RLock()
+		clients, sessions := len(h.clients), len(h.sessions)
+		h.mu.RUnlock()
+		if clients == 0 && sessions == 0 {
+			return h.flushRecordsContext(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			if clients == 0 {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Hub) snapshotClients() []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// waitFlushed waits until the WritePumps have written everything queued for
+// clients that are still connected, or ctx is done.
+func (h *Hub) waitFlushed(ctx context.Context, clients []*Client) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		pending := false
+		for _, client := range clients {
+			if client.unsent.Load() > 0 && !client.hungUp() {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
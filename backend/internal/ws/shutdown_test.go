@@ -0,0 +1,180 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// drain empties c's send queue in place of a WritePump until the test ends.
+func drain(t *testing.T, c *Client) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case <-c.send:
+				c.unsent.Add(-1)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+func shutdownAsync(h *Hub, timeout time.Duration) <-chan error {
+	returned := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		returned <- h.Shutdown(ctx)
+	}()
+	return returned
+}
+
+func TestShutdownReconnectsAndCloses(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		ServeWs(h, c, testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	url := "ws://" + ln.Addr().String() + "/ws"
+
+	conn, _, err := fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	read := func() testFrame {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var f testFrame
+		json.Unmarshal(data, &f)
+		return f
+	}
+	if f := read(); f.Type != EventHello {
+		t.Fatalf("got %s, want HELLO", f.Type)
+	}
+
+	returned := shutdownAsync(h, 5*time.Second)
+
+	f := read()
+	var data ReconnectData
+	json.Unmarshal(f.Data, &data)
+	if f.Type != EventReconnect || data.Delay < 0 || data.Delay >= reconnectSpread.Milliseconds() {
+		t.Fatalf("got %s %s, want RECONNECT within %s", f.Type, f.Data, reconnectSpread)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *fastws.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != fastws.CloseGoingAway {
+		t.Fatalf("got %v, want close %d", err, fastws.CloseGoingAway)
+	}
+
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return after the last client left")
+	}
+
+	// A draining node turns new connections away.
+	late, _, err := fastws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = late.ReadMessage()
+	if !errors.As(err, &closeErr) || closeErr.Code != fastws.CloseTryAgainLater {
+		t.Fatalf("got %v, want close %d", err, fastws.CloseTryAgainLater)
+	}
+}
+
+func TestShutdownReturnsAtDeadline(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), nil)
+	c, _ := connectTestClient(t, h)
+	drain(t, c)
+
+	// The client never leaves, as if its connection hung.
+	const deadline = 200 * time.Millisecond
+	start := time.Now()
+	select {
+	case err := <-shutdownAsync(h, deadline):
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	if elapsed := time.Since(start); elapsed > deadline+150*time.Millisecond {
+		t.Fatalf("Shutdown returned after %s, deadline %s", elapsed, deadline)
+	}
+}
+
+func TestShutdownFlushesRecorder(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	h := newTestHub(t, NewMemoryBroker(), rdb)
+	c, s := connectTestClient(t, h)
+	drain(t, c)
+	h.flushRecords()
+
+	// Redis stalls, so the recorder falls behind with the frames and with
+	// deleting the session, which ends since it was revoked.
+	mr.Lock()
+	publish(h, 1)
+	publish(h, 2)
+	waitFor(t, "frames for the session", func() bool { return sessionSeq(s) == 2 })
+	c.revoked.Store(true)
+
+	returned := shutdownAsync(h, 5*time.Second)
+	<-c.hangup
+	h.unregister <- c
+	waitFor(t, "the session to end", func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.sessions) == 0
+	})
+
+	select {
+	case err := <-returned:
+		mr.Unlock()
+		t.Fatalf("Shutdown returned %v before the recorder caught up", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	mr.Unlock()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	if mr.Exists(sessionKey(s.id)) || mr.Exists(sessionBufferKey(s.id)) {
+		t.Fatal("replay state of the ended session left in Redis")
+	}
+}
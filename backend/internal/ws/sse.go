@@ -0,0 +1,113 @@
+package ws
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseLastEventID splits an SSE event ID of the form "<session_id>:<seq>"
+// as sent back by EventSource in the Last-Event-ID header.
+func ParseLastEventID(id string) (ResumeData, bool) {
+	sessionID, seq, ok := strings.Cut(id, ":")
+	if !ok || sessionID == "" {
+		return ResumeData{}, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || n < 0 {
+		return ResumeData{}, false
+	}
+	return ResumeData{SessionID: sessionID, Seq: n}, true
+}
+
+// ServeSSE streams the gateway to w as Server-Sent Events. The client joins
+// the same rooms as a WebSocket connection and gets the same frames, one per
+// data line; sequenced frames carry "<session_id>:<s>" as event ID. With
+// resume set, the session is continued like a RESUME before the stream
+// starts; otherwise, or if that fails, a new session starts with READY.
+// Room subscriptions are changed through JoinSession and LeaveSession.
+func ServeSSE(hub *Hub, w *bufio.Writer, userID string, opts ConnectOptions, resume *ResumeData) {
+	client := NewClient(hub, nil, userID, opts)
+	hub.register <- client
+
+	log.Printf("SSE connected: user=%s", userID)
+
+	if client.startStream(w, resume) {
+		client.streamPump(w)
+	}
+
+	hub.unregister <- client
+	hub.releaseUserLimit(userID)
+	if client.session.Load() != nil {
+		hub.disconnect(client)
+	}
+}
+
+// startStream sends the retry hint and either the replay of a resumed
+// session or, for a new one, READY. It reports whether the client is still
+// there. The retry hint is jittered so clients of a restarting node spread
+// their reconnects.
+func (c *Client) startStream(w *bufio.Writer, resume *ResumeData) bool {
+	retry := time.Second + time.Duration(rand.Int63n(int64(reconnectSpread)))
+	fmt.Fprintf(w, "retry: %d\n\n", retry.Milliseconds())
+	if err := w.Flush(); err != nil {
+		return false
+	}
+
+	if resume != nil {
+		if replay, ok := c.resume(*resume); ok {
+			for _, f := range replay {
+				if err := c.writeEvent(w, f); err != nil {
+					return false
+				}
+			}
+			c.finishResume(len(replay))
+			c.hub.connect(c)
+			return true
+		}
+	}
+	// SSE has no IDENTIFY, the intents come from the query string.
+	c.identify(c.intents)
+	return true
+}
+
+// streamPump is the SSE counterpart of WritePump. Comment lines keep proxies
+// from timing the stream out and reveal a client that went away.
+func (c *Client) streamPump(w *bufio.Writer) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-c.send:
+			err := c.writeEvent(w, message)
+			c.unsent.Add(-1)
+			if err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.hub.refreshSession(c.session.Load())
+			c.hub.trackConnection(c)
+			fmt.Fprint(w, ": ping\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+		case <-c.hangup:
+			return
+		}
+	}
+}
+
+func (c *Client) writeEvent(w *bufio.Writer, f outFrame) error {
+	if f.seq != 0 {
+		fmt.Fprintf(w, "id: %s:%d\n", c.session.Load().id, f.seq)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", f.data)
+	return w.Flush()
+}
@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next SSE event from r, skipping the retry hint and
+// comments, and returns its id and data lines.
+func readEvent(t *testing.T, r *bufio.Reader) (id, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestParseLastEventID(t *testing.T) {
+	for id, want := range map[string]*ResumeData{
+		"abc:12": {SessionID: "abc", Seq: 12},
+		"abc:0":  {SessionID: "abc", Seq: 0},
+		"abc":    nil,
+		":12":    nil,
+		"abc:-1": nil,
+		"abc:x":  nil,
+		"":       nil,
+	} {
+		got, ok := ParseLastEventID(id)
+		if ok != (want != nil) || (ok && got != *want) {
+			t.Errorf("ParseLastEventID(%q) = %v, %v, want %v", id, got, ok, want)
+		}
+	}
+}
+
+func TestSSEStreamsFramesAndSessionRooms(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeSSE(h, bufio.NewWriter(pw), testUser, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll}, nil)
+	}()
+	r := bufio.NewReader(pr)
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "retry: ") {
+		t.Fatalf("stream starts with %q, %v, want the retry hint", line, err)
+	}
+	waitFor(t, "identify", func() bool {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		return len(h.rooms[userRoomPrefix+testUser]) > 0
+	})
+	h.awaitInterest()
+
+	publish(h, 1)
+	id, data := readEvent(t, r)
+	sessionID, seq, _ := strings.Cut(id, ":")
+	if sessionID == "" || seq != "1" || !strings.Contains(data, `"t":"GUILD_REMOVE"`) {
+		t.Fatalf("got id=%q data=%s, want the first sequenced frame", id, data)
+	}
+
+	// Rooms are joined and left through the session, as the REST
+	// subscription endpoints do.
+	h.JoinSession(testUser, sessionID, testChannel)
+	h.BroadcastToRoom(testChannel, Event{Type: EventMessageCreate, Data: map[string]string{"content": "hi"}})
+	if id, data := readEvent(t, r); id != sessionID+":2" || !strings.Contains(data, `"t":"MESSAGE_CREATE"`) {
+		t.Fatalf("got id=%q data=%s, want the channel's message", id, data)
+	}
+	h.LeaveSession(testUser, sessionID, testChannel)
+	h.BroadcastToRoom(testChannel, Event{Type: EventMessageCreate, Data: map[string]string{"content": "after"}})
+	publish(h, 3)
+	if _, data := readEvent(t, r); !strings.Contains(data, `"t":"GUILD_REMOVE"`) {
+		t.Fatalf("got %s after leaving the channel", data)
+	}
+
+	pr.Close()
+	publish(h, 4)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end after the reader went away")
+	}
+}
+
+func TestSSEResumeContinuesSession(t *testing.T) {
+	h := newTestHub(t, NewMemoryBroker(), newTestRedis(t))
+	c1, s := connectTestClient(t, h)
+	publish(h, 1)
+	expectEvent(t, c1, EventGuildRemove, 1)
+	h.unregister <- c1
+	publish(h, 2)
+	publish(h, 3)
+	waitFor(t, "frames for the detached session", func() bool { return sessionSeq(s) == 3 })
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeSSE(h, bufio.NewWriter(pw), testUser, ConnectOptions{Encoding: EncodingJSON}, &ResumeData{SessionID: s.id, Seq: 1})
+	}()
+	r := bufio.NewReader(pr)
+
+	for _, want := range []string{s.id + ":2", s.id + ":3"} {
+		if id, data := readEvent(t, r); id != want || !strings.Contains(data, `"t":"GUILD_REMOVE"`) {
+			t.Fatalf("replayed id=%q data=%s, want id %q", id, data, want)
+		}
+	}
+	if id, data := readEvent(t, r); id != "" || !strings.Contains(data, `"t":"RESUMED"`) {
+		t.Fatalf("got id=%q data=%s, want RESUMED", id, data)
+	}
+
+	publish(h, 4)
+	if id, _ := readEvent(t, r); id != s.id+":4" {
+		t.Fatalf("live frame has id %q, want %s:4", id, s.id)
+	}
+
+	// A drop now leaves frame 5 in the buffer of the continued session.
+	pr.Close()
+	publish(h, 5)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream did not end")
+	}
+	waitFor(t, "frame 5", func() bool { return sessionSeq(s) == 5 })
+	h.flushRecords()
+	buffered, err := h.loadBuffer(s.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay, ok := missedFrames(buffered, 4, 5); !ok || len(replay) != 1 {
+		t.Fatalf("frame 5 not resumable: %v", buffered)
+	}
+}
@@ -0,0 +1,130 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// typingThrottle is the minimum gap between two TYPING_START broadcasts
+	// for the same user and channel. Clients should resend TYPING at about
+	// this rate while the user keeps typing.
+	typingThrottle = 5 * time.Second
+	// typingTimeout is how long a TYPING_START keeps a user marked as typing
+	// if neither TYPING_STOP nor a message follows.
+	typingTimeout = 10 * time.Second
+)
+
+// TypingEventData is the payload of TYPING_START and TYPING_STOP.
+type TypingEventData struct {
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+}
+
+func typingKey(channelID, userID string) string { return "typing:" + channelID + ":" + userID }
+func typingThrottleKey(channelID, userID string) string {
+	return typingKey(channelID, userID) + ":throttle"
+}
+
+// startTyping broadcasts TYPING_START for a channel the client's user can
+// read, at most once per typingThrottle across all of their connections.
+func (c *Client) startTyping(channelID string) {
+	if !c.hub.claimTyping(c.UserID, channelID) {
+		return
+	}
+	if !c.session.Load().inRoom(channelID) {
+		if err := c.hub.Authorize(c.UserID, channelID); err != nil {
+			c.hub.clearTyping(c.UserID, channelID)
+			c.sendEvent(Event{Type: EventError, Data: errorData("TYPING", channelID, err)})
+			return
+		}
+	}
+	c.hub.BroadcastToRoom(channelID, Event{
+		Type:   EventTypingStart,
+		Data:   TypingEventData{UserID: c.UserID, ChannelID: channelID},
+		RoomID: channelID,
+	})
+}
+
+func (s *session) inRoom(roomID string) bool {
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return s.rooms[roomID]
+}
+
+func (s *session) roomCount() int {
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return len(s.rooms)
+}
+
+// StopTyping ends the typing indicator of userID in channelID, e.g. because
+// they sent a message. TYPING_STOP is only broadcast if the user was
+// actually marked as typing.
+func (h *Hub) StopTyping(userID, channelID string) {
+	if !h.clearTyping(userID, channelID) {
+		return
+	}
+	h.BroadcastToRoom(channelID, Event{
+		Type:   EventTypingStop,
+		Data:   TypingEventData{UserID: userID, ChannelID: channelID},
+		RoomID: channelID,
+	})
+}
+
+// claimTyping marks the user as typing and reports whether a TYPING_START
+// may be broadcast now.
+func (h *Hub) claimTyping(userID, channelID string) bool {
+	if h.rdb == nil {
+		key := typingKey(channelID, userID)
+		now := h.now()
+		h.typingMu.Lock()
+		defer h.typingMu.Unlock()
+		if last, ok := h.localTyping[key]; ok && now.Sub(last) < typingThrottle {
+			return false
+		}
+		h.localTyping[key] = now
+		if len(h.localTyping) > 1024 {
+			for k, last := range h.localTyping {
+				if now.Sub(last) >= typingTimeout {
+					delete(h.localTyping, k)
+				}
+			}
+		}
+		return true
+	}
+
+	ctx := context.Background()
+	ok, err := h.rdb.SetNX(ctx, typingThrottleKey(channelID, userID), 1, typingThrottle).Result()
+	if err != nil {
+		log.Printf("typing %s: throttle: %v", userID, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := h.rdb.Set(ctx, typingKey(channelID, userID), 1, typingTimeout).Err(); err != nil {
+		log.Printf("typing %s: mark: %v", userID, err)
+	}
+	return true
+}
+
+// clearTyping removes the typing mark and reports whether one was active.
+func (h *Hub) clearTyping(userID, channelID string) bool {
+	if h.rdb == nil {
+		key := typingKey(channelID, userID)
+		h.typingMu.Lock()
+		defer h.typingMu.Unlock()
+		last, ok := h.localTyping[key]
+		delete(h.localTyping, key)
+		return ok && h.now().Sub(last) < typingTimeout
+	}
+
+	n, err := h.rdb.Del(context.Background(), typingKey(channelID, userID), typingThrottleKey(channelID, userID)).Result()
+	if err != nil {
+		log.Printf("typing %s: clear: %v", userID, err)
+		return false
+	}
+	return n > 0
+}
@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTypingHub returns a hub without or with Redis, a client in testChannel
+// and a function that moves the clock the typing marks expire by: the hub's
+// own clock without Redis, miniredis' clock for the key TTLs with it.
+func newTypingHub(t *testing.T, withRedis bool) (*Hub, *Client, func(time.Duration)) {
+	t.Helper()
+	var h *Hub
+	var advance func(time.Duration)
+	if withRedis {
+		mr := miniredis.RunT(t)
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		h, _ = newTestHubDB(t, NewMemoryBroker(), rdb)
+		advance = mr.FastForward
+	} else {
+		h, _ = newTestHubDB(t, NewMemoryBroker(), nil)
+		now := time.Now()
+		h.now = func() time.Time { return now }
+		advance = func(d time.Duration) { now = now.Add(d) }
+	}
+	c := connectUser(t, h, testUser)
+	h.Subscribe(c.session.Load(), testChannel)
+	return h, c, advance
+}
+
+func expectTyping(t *testing.T, c *Client, eventType string) {
+	t.Helper()
+	f := nextFrame(t, c)
+	var data TypingEventData
+	json.Unmarshal(f.Data, &data)
+	if f.Type != eventType || data.UserID != testUser || data.ChannelID != testChannel {
+		t.Fatalf("got %s %s, want %s", f.Type, f.Data, eventType)
+	}
+}
+
+func forEachTypingStore(t *testing.T, test func(t *testing.T, h *Hub, c *Client, advance func(time.Duration))) {
+	for name, withRedis := range map[string]bool{"local": false, "redis": true} {
+		t.Run(name, func(t *testing.T) {
+			h, c, advance := newTypingHub(t, withRedis)
+			test(t, h, c, advance)
+		})
+	}
+}
+
+func TestTypingThrottle(t *testing.T) {
+	forEachTypingStore(t, func(t *testing.T, h *Hub, c *Client, advance func(time.Duration)) {
+		c.startTyping(testChannel)
+		expectTyping(t, c, EventTypingStart)
+
+		advance(typingThrottle - time.Second)
+		c.startTyping(testChannel)
+		expectOnlyUserEvent(t, h, c)
+
+		advance(time.Second)
+		c.startTyping(testChannel)
+		expectTyping(t, c, EventTypingStart)
+	})
+}
+
+func TestTypingStop(t *testing.T) {
+	forEachTypingStore(t, func(t *testing.T, h *Hub, c *Client, advance func(time.Duration)) {
+		c.startTyping(testChannel)
+		expectTyping(t, c, EventTypingStart)
+
+		h.StopTyping(testUser, testChannel)
+		expectTyping(t, c, EventTypingStop)
+
+		// Stopping twice broadcasts once, and the stop lifts the throttle.
+		h.StopTyping(testUser, testChannel)
+		expectOnlyUserEvent(t, h, c)
+		c.startTyping(testChannel)
+		expectTyping(t, c, EventTypingStart)
+	})
+}
+
+func TestTypingStopAfterTimeout(t *testing.T) {
+	forEachTypingStore(t, func(t *testing.T, h *Hub, c *Client, advance func(time.Duration)) {
+		c.startTyping(testChannel)
+		expectTyping(t, c, EventTypingStart)
+
+		// Clients drop the indicator on their own after typingTimeout, so a
+		// later stop, e.g. from a message, has nothing left to end.
+		advance(typingTimeout)
+		h.StopTyping(testUser, testChannel)
+		expectOnlyUserEvent(t, h, c)
+	})
+}
+
+func TestStopTypingWithoutStart(t *testing.T) {
+	forEachTypingStore(t, func(t *testing.T, h *Hub, c *Client, advance func(time.Duration)) {
+		h.StopTyping(testUser, testChannel)
+		expectOnlyUserEvent(t, h, c)
+	})
+}
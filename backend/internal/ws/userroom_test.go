@@ -0,0 +1,80 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// connectUser is connectTestClient for any user.
+func connectUser(t *testing.T, h *Hub, userID string) *Client {
+	t.Helper()
+	c := NewClient(h, nil, userID, ConnectOptions{Encoding: EncodingJSON, Intents: IntentsAll})
+	h.register <- c
+	h.Subscribe(h.newSession(c), userRoomPrefix+userID)
+	h.awaitInterest()
+	return c
+}
+
+func inRoom(h *Hub, c *Client, roomID string) bool {
+	s := c.session.Load()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return s != nil && h.rooms[roomID][s] && s.rooms[roomID]
+}
+
+func TestBroadcastToUserReachesEveryConnection(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	phone := connectUser(t, h, testUser)
+	desktop := connectUser(t, h, testUser)
+	other := connectUser(t, h, otherUser)
+
+	h.BroadcastToUser(otherUser, Event{Type: EventConversationCreate, Data: map[string]string{"id": "other"}})
+	h.BroadcastToUser(testUser, Event{Type: EventConversationCreate, Data: map[string]string{"id": "mine"}})
+	for _, c := range []*Client{phone, desktop} {
+		f := nextFrame(t, c)
+		if f.Type != EventConversationCreate || string(f.Data) != `{"id":"mine"}` {
+			t.Fatalf("got %s %s, want only the user's own event", f.Type, f.Data)
+		}
+	}
+	if f := nextFrame(t, other); string(f.Data) != `{"id":"other"}` {
+		t.Fatalf("other user got %s", f.Data)
+	}
+}
+
+func TestSubscribeToForeignUserRoomIsForbidden(t *testing.T) {
+	h, _ := newTestHubDB(t, NewMemoryBroker(), nil)
+	c := connectUser(t, h, testUser)
+
+	c.subscribe("SUBSCRIBE", userRoomPrefix+otherUser)
+	f := nextFrame(t, c)
+	var data ErrorData
+	json.Unmarshal(f.Data, &data)
+	if f.Type != EventError || data.Code != ErrCodeForbidden {
+		t.Fatalf("got %s %s, want FORBIDDEN", f.Type, f.Data)
+	}
+	if inRoom(h, c, userRoomPrefix+otherUser) {
+		t.Fatal("joined another user's room")
+	}
+}
+
+func TestJoinUserSubscribesConnectionsOnEveryNode(t *testing.T) {
+	rdb := newTestRedis(t)
+	nodeA, _ := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	nodeB, _ := newTestHubDB(t, NewRedisBroker(rdb), rdb)
+	awaitRedisSubscribers(t, rdb, 2, controlRoom)
+	c := connectUser(t, nodeA, testUser)
+	other := connectUser(t, nodeA, otherUser)
+
+	room := dmRoomPrefix + testConversation
+	nodeB.JoinUser(testUser, room)
+	waitFor(t, "join", func() bool { return inRoom(nodeA, c, room) })
+	if inRoom(nodeA, other, room) {
+		t.Fatal("JoinUser subscribed another user")
+	}
+	awaitRedisSubscribers(t, rdb, 1, room)
+
+	nodeB.BroadcastToRoom(room, Event{Type: EventMessageCreate, Data: map[string]string{"content": "hi"}})
+	if f := nextFrame(t, c); f.Type != EventMessageCreate {
+		t.Fatalf("got %s, want the DM message", f.Type)
+	}
+}
@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"pwdh-aether/internal/model"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectChannelType(mock sqlmock.Sqlmock, channelType string) {
+	mock.ExpectQuery(`FROM channels WHERE id = \$1`).WithArgs(testChannel).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "guild_id", "name", "type", "category", "position", "created_at"}).
+			AddRow(testChannel, testGuild, "lounge", channelType, nil, 0, time.Now()))
+}
+
+func TestVoiceStateUpdateRelaysToGuild(t *testing.T) {
+	h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+	c := connectUser(t, h, testUser)
+	h.Subscribe(c.session.Load(), testRoom)
+	channelID := testChannel
+
+	expectIsMember(mock, true)
+	expectChannelType(mock, model.ChannelVoice)
+	c.updateVoiceState(VoiceStateData{GuildID: testGuild, ChannelID: &channelID, UserID: otherUser, SelfMute: true})
+
+	f := nextFrame(t, c)
+	var data VoiceStateData
+	json.Unmarshal(f.Data, &data)
+	if f.Type != EventVoiceStateUpdate || data.UserID != testUser || data.ChannelID == nil || !data.SelfMute {
+		t.Fatalf("got %s %s, want the voice state of the sender", f.Type, f.Data)
+	}
+}
+
+func TestVoiceStateUpdateRejectsInvalidTarget(t *testing.T) {
+	channelID := testChannel
+	for name, tc := range map[string]struct {
+		member bool
+		typ    string
+		code   string
+	}{
+		"non-member":   {member: false, code: ErrCodeForbidden},
+		"text channel": {member: true, typ: model.ChannelText, code: ErrCodeInvalidPayload},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, mock := newTestHubDB(t, NewMemoryBroker(), nil)
+			c := connectUser(t, h, testUser)
+			h.Subscribe(c.session.Load(), testRoom)
+			expectIsMember(mock, tc.member)
+			if tc.typ != "" {
+				expectChannelType(mock, tc.typ)
+			}
+
+			c.updateVoiceState(VoiceStateData{GuildID: testGuild, ChannelID: &channelID})
+			f := nextFrame(t, c)
+			var data ErrorData
+			json.Unmarshal(f.Data, &data)
+			if f.Type != EventError || data.Code != tc.code || data.Op != "VOICE_STATE_UPDATE" {
+				t.Fatalf("got %s %s, want a %s error", f.Type, f.Data, tc.code)
+			}
+			expectOnlyUserEvent(t, h, c)
+		})
+	}
+}
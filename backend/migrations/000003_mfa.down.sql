@@ -0,0 +1,4 @@
+ALTER TABLE guilds DROP COLUMN IF EXISTS require_mfa;
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
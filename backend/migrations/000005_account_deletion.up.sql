@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN deletion_guild_policy VARCHAR(10);
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX idx_users_deletion ON users(deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL AND deleted_at IS NULL;
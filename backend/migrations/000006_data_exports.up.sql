@@ -0,0 +1,13 @@
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(10) NOT NULL DEFAULT 'PENDING',
+    object_key TEXT,
+    size_bytes BIGINT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX idx_data_exports_user ON data_exports(user_id, created_at DESC);
+CREATE INDEX idx_data_exports_expires ON data_exports(expires_at) WHERE status = 'READY';
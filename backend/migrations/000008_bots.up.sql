@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE bots (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_id UUID NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    token_created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_bots_owner ON bots(owner_id);
@@ -0,0 +1,3 @@
+ALTER TABLE user_presence ADD COLUMN manual_status VARCHAR(20);
+
+UPDATE user_presence SET manual_status = 'DND' WHERE status = 'DND';
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS user_presence_history ON user_presence;
+DROP FUNCTION IF EXISTS record_presence_history();
+DROP TABLE IF EXISTS presence_history;
@@ -0,0 +1,28 @@
+CREATE TABLE presence_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL,
+    manual_status VARCHAR(20),
+    game_name VARCHAR(100),
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_presence_history_user ON presence_history(user_id, changed_at);
+
+-- Every change of status or game is recorded, whichever query made it.
+CREATE FUNCTION record_presence_history() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT'
+        OR NEW.status IS DISTINCT FROM OLD.status
+        OR NEW.manual_status IS DISTINCT FROM OLD.manual_status
+        OR NEW.game_name IS DISTINCT FROM OLD.game_name THEN
+        INSERT INTO presence_history (user_id, status, manual_status, game_name)
+        VALUES (NEW.user_id, COALESCE(NEW.status, 'OFFLINE'), NEW.manual_status, NEW.game_name);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_presence_history
+    AFTER INSERT OR UPDATE ON user_presence
+    FOR EACH ROW EXECUTE FUNCTION record_presence_history();
@@ -0,0 +1,2 @@
+UPDATE users SET totp_enabled = FALSE WHERE totp_secret_encrypted IS NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret_encrypted;
@@ -0,0 +1,76 @@
+"use client";
+
+import { useState } from "react";
+import Link from "next/link";
+import { api } from "@/lib/api";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+
+export default function ForgotPasswordPage() {
+  const [email, setEmail] = useState("");
+  const [sent, setSent] = useState(false);
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    setLoading(true);
+
+    try {
+      await api.post("/api/auth/forgot", { email });
+      setSent(true);
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Anfrage fehlgeschlagen");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="glass w-full max-w-md rounded-2xl p-8 mx-4">
+      <div className="mb-8 text-center">
+        <h1 className="text-3xl font-bold neon-text">PWDH-Aether</h1>
+        <p className="mt-2 text-muted-foreground">Passwort vergessen</p>
+      </div>
+
+      {sent ? (
+        <p className="text-center text-sm text-muted-foreground">
+          Falls ein Konto mit dieser Adresse existiert, ist ein Link zum Zuruecksetzen unterwegs.
+        </p>
+      ) : (
+        <form onSubmit={handleSubmit} className="space-y-4">
+          {error && (
+            <div className="rounded-lg bg-destructive/10 border border-destructive/20 p-3 text-sm text-destructive">
+              {error}
+            </div>
+          )}
+
+          <div className="space-y-2">
+            <Label htmlFor="email">E-Mail</Label>
+            <Input
+              id="email"
+              type="email"
+              placeholder="deine@email.de"
+              value={email}
+              onChange={(e) => setEmail(e.target.value)}
+              required
+              className="bg-background/50"
+            />
+          </div>
+
+          <Button type="submit" className="w-full glow" disabled={loading}>
+            {loading ? "Wird gesendet..." : "Link senden"}
+          </Button>
+        </form>
+      )}
+
+      <p className="mt-6 text-center text-sm text-muted-foreground">
+        <Link href="/login" className="text-primary hover:underline">
+          Zurueck zum Login
+        </Link>
+      </p>
+    </div>
+  );
+}
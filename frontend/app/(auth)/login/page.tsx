@@ -1,20 +1,46 @@
 "use client";
 
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { useRouter } from "next/navigation";
 import Link from "next/link";
 import { useAuthStore } from "@/store/use-auth-store";
+import { api } from "@/lib/api";
+import type { OIDCProvider } from "@/types";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
 
 export default function LoginPage() {
   const router = useRouter();
-  const { login } = useAuthStore();
+  const { login, verifyMfa } = useAuthStore();
   const [email, setEmail] = useState("");
   const [password, setPassword] = useState("");
+  const [mfaTicket, setMfaTicket] = useState<string | null>(null);
+  const [code, setCode] = useState("");
   const [error, setError] = useState("");
   const [loading, setLoading] = useState(false);
+  const [providers, setProviders] = useState<OIDCProvider[]>([]);
+
+  useEffect(() => {
+    // A provider login that needs a second factor continues here.
+    const ticket = sessionStorage.getItem("mfa_ticket");
+    if (ticket) {
+      sessionStorage.removeItem("mfa_ticket");
+      setMfaTicket(ticket);
+    }
+    api.get<OIDCProvider[]>("/api/auth/oidc/providers").then(setProviders).catch(() => {});
+  }, []);
+
+  const handleProvider = async (provider: string) => {
+    setError("");
+    try {
+      const { url } = await api.post<{ url: string }>(`/api/auth/oidc/${provider}/authorize`);
+      sessionStorage.setItem("oidc_mode", "login");
+      window.location.href = url;
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Anmeldung fehlgeschlagen");
+    }
+  };
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -22,7 +48,15 @@ export default function LoginPage() {
     setLoading(true);
 
     try {
-      await login(email, password);
+      if (mfaTicket) {
+        await verifyMfa(mfaTicket, code);
+      } else {
+        const ticket = await login(email, password);
+        if (ticket) {
+          setMfaTicket(ticket);
+          return;
+        }
+      }
       router.push("/channels");
     } catch (err) {
       setError(err instanceof Error ? err.message : "Login fehlgeschlagen");
@@ -45,38 +79,79 @@ export default function LoginPage() {
           </div>
         )}
 
-        <div className="space-y-2">
-          <Label htmlFor="email">E-Mail</Label>
-          <Input
-            id="email"
-            type="email"
-            placeholder="deine@email.de"
-            value={email}
-            onChange={(e) => setEmail(e.target.value)}
-            required
-            className="bg-background/50"
-          />
-        </div>
+        {mfaTicket ? (
+          <div className="space-y-2">
+            <Label htmlFor="code">Zwei-Faktor-Code</Label>
+            <Input
+              id="code"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              placeholder="123456 oder Wiederherstellungscode"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              required
+              autoFocus
+              className="bg-background/50"
+            />
+          </div>
+        ) : (
+          <>
+            <div className="space-y-2">
+              <Label htmlFor="email">E-Mail</Label>
+              <Input
+                id="email"
+                type="email"
+                placeholder="deine@email.de"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                required
+                className="bg-background/50"
+              />
+            </div>
 
-        <div className="space-y-2">
-          <Label htmlFor="password">Passwort</Label>
-          <Input
-            id="password"
-            type="password"
-            placeholder="••••••••"
-            value={password}
-            onChange={(e) => setPassword(e.target.value)}
-            required
-            className="bg-background/50"
-          />
-        </div>
+            <div className="space-y-2">
+              <Label htmlFor="password">Passwort</Label>
+              <Input
+                id="password"
+                type="password"
+                placeholder="••••••••"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+                className="bg-background/50"
+              />
+            </div>
+          </>
+        )}
 
         <Button type="submit" className="w-full glow" disabled={loading}>
-          {loading ? "Wird angemeldet..." : "Anmelden"}
+          {loading ? "Wird angemeldet..." : mfaTicket ? "Bestaetigen" : "Anmelden"}
         </Button>
       </form>
 
+      {!mfaTicket && providers.length > 0 && (
+        <div className="mt-4 space-y-2">
+          {providers.map((p) => (
+            <Button
+              key={p.id}
+              type="button"
+              variant="outline"
+              className="w-full"
+              onClick={() => handleProvider(p.id)}
+            >
+              Weiter mit {p.name}
+            </Button>
+          ))}
+        </div>
+      )}
+
       <p className="mt-6 text-center text-sm text-muted-foreground">
+        <Link href="/forgot-password" className="text-primary hover:underline">
+          Passwort vergessen?
+        </Link>
+      </p>
+
+      <p className="mt-2 text-center text-sm text-muted-foreground">
         Noch kein Konto?{" "}
         <Link href="/register" className="text-primary hover:underline">
           Registrieren
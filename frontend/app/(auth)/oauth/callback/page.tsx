@@ -0,0 +1,83 @@
+"use client";
+
+import { Suspense, useEffect, useRef, useState } from "react";
+import { useRouter, useSearchParams } from "next/navigation";
+import Link from "next/link";
+import { api } from "@/lib/api";
+import { useAuthStore } from "@/store/use-auth-store";
+
+function OAuthCallbackStatus() {
+  const router = useRouter();
+  const params = useSearchParams();
+  const { loginWithProvider } = useAuthStore();
+  const [error, setError] = useState("");
+  const handled = useRef(false);
+
+  useEffect(() => {
+    // The code and state can only be redeemed once.
+    if (handled.current) return;
+    handled.current = true;
+
+    const code = params.get("code");
+    const state = params.get("state");
+    const mode = sessionStorage.getItem("oidc_mode");
+    sessionStorage.removeItem("oidc_mode");
+
+    if (!code || !state) {
+      setError(params.get("error_description") || params.get("error") || "Anmeldung abgebrochen");
+      return;
+    }
+
+    if (mode === "reauth") {
+      // The token confirms the next password change or account deletion.
+      api
+        .post<{ reauth_token: string }>("/api/users/@me/reauth/callback", { code, state })
+        .then(({ reauth_token }) => {
+          sessionStorage.setItem("reauth_token", reauth_token);
+          router.replace(sessionStorage.getItem("reauth_return") || "/channels");
+          sessionStorage.removeItem("reauth_return");
+        })
+        .catch((err) => setError(err instanceof Error ? err.message : "Bestaetigung fehlgeschlagen"));
+      return;
+    }
+
+    const finish =
+      mode === "link"
+        ? api.post("/api/users/@me/identities/callback", { code, state }).then(() => null)
+        : loginWithProvider(code, state);
+
+    finish
+      .then((ticket) => {
+        if (ticket) {
+          sessionStorage.setItem("mfa_ticket", ticket);
+          router.replace("/login");
+          return;
+        }
+        router.replace("/channels");
+      })
+      .catch((err) => setError(err instanceof Error ? err.message : "Anmeldung fehlgeschlagen"));
+  }, [params, loginWithProvider, router]);
+
+  if (error) {
+    return <p className="text-center text-sm text-destructive">{error}</p>;
+  }
+  return <p className="text-center text-sm text-muted-foreground">Wird angemeldet...</p>;
+}
+
+export default function OAuthCallbackPage() {
+  return (
+    <div className="glass w-full max-w-md rounded-2xl p-8 mx-4">
+      <div className="mb-8 text-center">
+        <h1 className="text-3xl font-bold neon-text">PWDH-Aether</h1>
+      </div>
+      <Suspense>
+        <OAuthCallbackStatus />
+      </Suspense>
+      <p className="mt-6 text-center text-sm text-muted-foreground">
+        <Link href="/login" className="text-primary hover:underline">
+          Zurueck zur Anmeldung
+        </Link>
+      </p>
+    </div>
+  );
+}
@@ -0,0 +1,73 @@
+"use client";
+
+import { Suspense, useState } from "react";
+import { useRouter, useSearchParams } from "next/navigation";
+import { api } from "@/lib/api";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+
+function ResetPasswordForm() {
+  const router = useRouter();
+  const token = useSearchParams().get("token") ?? "";
+  const [password, setPassword] = useState("");
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError("");
+    setLoading(true);
+
+    try {
+      await api.post("/api/auth/reset", { token, password });
+      router.push("/login");
+    } catch (err) {
+      setError(err instanceof Error ? err.message : "Zuruecksetzen fehlgeschlagen");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <form onSubmit={handleSubmit} className="space-y-4">
+      {error && (
+        <div className="rounded-lg bg-destructive/10 border border-destructive/20 p-3 text-sm text-destructive">
+          {error}
+        </div>
+      )}
+
+      <div className="space-y-2">
+        <Label htmlFor="password">Neues Passwort</Label>
+        <Input
+          id="password"
+          type="password"
+          placeholder="••••••••"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+          required
+          minLength={6}
+          className="bg-background/50"
+        />
+      </div>
+
+      <Button type="submit" className="w-full glow" disabled={loading || !token}>
+        {loading ? "Wird gespeichert..." : "Passwort setzen"}
+      </Button>
+    </form>
+  );
+}
+
+export default function ResetPasswordPage() {
+  return (
+    <div className="glass w-full max-w-md rounded-2xl p-8 mx-4">
+      <div className="mb-8 text-center">
+        <h1 className="text-3xl font-bold neon-text">PWDH-Aether</h1>
+        <p className="mt-2 text-muted-foreground">Neues Passwort setzen</p>
+      </div>
+      <Suspense>
+        <ResetPasswordForm />
+      </Suspense>
+    </div>
+  );
+}
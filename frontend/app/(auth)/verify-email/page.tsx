@@ -0,0 +1,57 @@
+"use client";
+
+import { Suspense, useEffect, useState } from "react";
+import { useSearchParams } from "next/navigation";
+import Link from "next/link";
+import { api } from "@/lib/api";
+
+function VerifyEmailStatus() {
+  const token = useSearchParams().get("token");
+  const [status, setStatus] = useState<"pending" | "done" | "failed">("pending");
+  const [error, setError] = useState("");
+
+  useEffect(() => {
+    if (!token) {
+      setStatus("failed");
+      return;
+    }
+    api
+      .post("/api/auth/verify", { token })
+      .then(() => setStatus("done"))
+      .catch((err) => {
+        setError(err instanceof Error ? err.message : "");
+        setStatus("failed");
+      });
+  }, [token]);
+
+  if (status === "pending") {
+    return <p className="text-center text-sm text-muted-foreground">Wird bestaetigt...</p>;
+  }
+  if (status === "failed") {
+    return (
+      <p className="text-center text-sm text-destructive">
+        Der Link ist ungueltig oder abgelaufen.{error && ` (${error})`}
+      </p>
+    );
+  }
+  return <p className="text-center text-sm text-muted-foreground">Deine E-Mail-Adresse ist bestaetigt.</p>;
+}
+
+export default function VerifyEmailPage() {
+  return (
+    <div className="glass w-full max-w-md rounded-2xl p-8 mx-4">
+      <div className="mb-8 text-center">
+        <h1 className="text-3xl font-bold neon-text">PWDH-Aether</h1>
+        <p className="mt-2 text-muted-foreground">E-Mail bestaetigen</p>
+      </div>
+      <Suspense>
+        <VerifyEmailStatus />
+      </Suspense>
+      <p className="mt-6 text-center text-sm text-muted-foreground">
+        <Link href="/channels" className="text-primary hover:underline">
+          Weiter zu PWDH-Aether
+        </Link>
+      </p>
+    </div>
+  );
+}
@@ -93,6 +93,9 @@ export function ChatItem({ message, showHeader }: ChatItemProps) {
             <span className="font-semibold text-sm hover:underline cursor-pointer">
               {message.user.username}
             </span>
+            {message.user.bot && (
+              <span className="rounded bg-primary/20 px-1 text-[10px] font-semibold text-primary">BOT</span>
+            )}
             <span className="text-xs text-muted-foreground">
               {formatRelativeTime(message.created_at)}
             </span>
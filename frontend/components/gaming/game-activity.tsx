@@ -45,7 +45,6 @@ export function GameActivity({ guildId, members }: GameActivityProps) {
   const handleSetActivity = async () => {
     try {
       await api.patch("/api/presence", {
-        status: "ONLINE",
         game_name: gameName || undefined,
         custom_status: customStatus || undefined,
       });
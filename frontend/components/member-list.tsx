@@ -62,6 +62,11 @@ export function MemberList() {
                   />
                 </div>
                 <span className="text-sm truncate">{member.user.username}</span>
+                {member.user.bot && (
+                  <Badge variant="outline" className="text-[10px] px-1 py-0 h-4 border-primary/50 text-primary">
+                    BOT
+                  </Badge>
+                )}
                 {member.role === "OWNER" && (
                   <Badge variant="outline" className="ml-auto text-[10px] px-1 py-0 h-4 border-yellow-500/50 text-yellow-500">
                     ♛
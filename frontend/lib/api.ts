@@ -2,6 +2,8 @@ const API_URL = process.env.NEXT_PUBLIC_API_URL || "http://localhost:8080";
 
 class ApiClient {
   private token: string | null = null;
+  private refreshToken: string | null = null;
+  private refreshing: Promise<boolean> | null = null;
 
   setToken(token: string | null) {
     this.token = token;
@@ -19,7 +21,54 @@ class ApiClient {
     return this.token;
   }
 
-  private async request<T>(path: string, options: RequestInit = {}): Promise<T> {
+  setRefreshToken(token: string | null) {
+    this.refreshToken = token;
+    if (token) {
+      localStorage.setItem("refresh_token", token);
+    } else {
+      localStorage.removeItem("refresh_token");
+    }
+  }
+
+  getRefreshToken(): string | null {
+    if (!this.refreshToken && typeof window !== "undefined") {
+      this.refreshToken = localStorage.getItem("refresh_token");
+    }
+    return this.refreshToken;
+  }
+
+  // Swaps the refresh token for a new token pair. Concurrent callers share
+  // one request, since every refresh token can only be used once.
+  refresh(): Promise<boolean> {
+    if (this.refreshing) return this.refreshing;
+
+    const refreshToken = this.getRefreshToken();
+    if (!refreshToken) return Promise.resolve(false);
+
+    this.refreshing = fetch(`${API_URL}/api/auth/refresh`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    })
+      .then(async (res) => {
+        if (!res.ok) {
+          this.setToken(null);
+          this.setRefreshToken(null);
+          return false;
+        }
+        const body = await res.json();
+        this.setToken(body.access_token);
+        this.setRefreshToken(body.refresh_token);
+        return true;
+      })
+      .catch(() => false)
+      .finally(() => {
+        this.refreshing = null;
+      });
+    return this.refreshing;
+  }
+
+  private async request<T>(path: string, options: RequestInit = {}, retry = true): Promise<T> {
     const token = this.getToken();
     const headers: Record<string, string> = {
       "Content-Type": "application/json",
@@ -30,11 +79,17 @@ class ApiClient {
       headers["Authorization"] = `Bearer ${token}`;
     }
 
+    // Cookies carry the binding of a provider login to this browser.
     const res = await fetch(`${API_URL}${path}`, {
       ...options,
       headers,
+      credentials: "include",
     });
 
+    if (res.status === 401 && retry && !path.startsWith("/api/auth/") && (await this.refresh())) {
+      return this.request<T>(path, options, false);
+    }
+
     if (!res.ok) {
       const body = await res.json().catch(() => ({ error: res.statusText }));
       throw new ApiError(res.status, body.error || "Request failed");
@@ -83,10 +138,10 @@ class ApiClient {
     return res.json();
   }
 
-  getWsUrl(): string {
-    const token = this.getToken();
+  async getWsUrl(): Promise<string> {
+    const { ticket } = await this.post<{ ticket: string }>("/api/gateway/ticket");
     const wsBase = API_URL.replace(/^http/, "ws");
-    return `${wsBase}/ws?token=${token}`;
+    return `${wsBase}/ws?ticket=${encodeURIComponent(ticket)}`;
   }
 }
 
@@ -1,13 +1,18 @@
 import { create } from "zustand";
 import { api } from "@/lib/api";
-import type { User, TokenResponse } from "@/types";
+import type { User, TokenResponse, MFAChallenge } from "@/types";
 
 interface AuthState {
   user: User | null;
   isLoading: boolean;
   isAuthenticated: boolean;
 
-  login: (email: string, password: string) => Promise<void>;
+  // login resolves with a ticket when the account needs a second factor.
+  login: (email: string, password: string) => Promise<string | null>;
+  // loginWithProvider finishes an OIDC login; like login it may resolve
+  // with an MFA ticket.
+  loginWithProvider: (code: string, state: string) => Promise<string | null>;
+  verifyMfa: (ticket: string, code: string) => Promise<void>;
   register: (username: string, email: string, password: string) => Promise<void>;
   logout: () => void;
   loadUser: () => Promise<void>;
@@ -19,19 +24,48 @@ export const useAuthStore = create<AuthState>((set) => ({
   isAuthenticated: false,
 
   login: async (email, password) => {
-    const res = await api.post<TokenResponse>("/api/auth/login", { email, password });
+    const res = await api.post<TokenResponse | MFAChallenge>("/api/auth/login", { email, password });
+    if ("mfa_required" in res) {
+      return res.mfa_ticket;
+    }
+    api.setToken(res.access_token);
+    api.setRefreshToken(res.refresh_token);
+    set({ user: res.user, isAuthenticated: true, isLoading: false });
+    return null;
+  },
+
+  loginWithProvider: async (code, state) => {
+    const res = await api.post<TokenResponse | MFAChallenge>("/api/auth/oidc/callback", { code, state });
+    if ("mfa_required" in res) {
+      return res.mfa_ticket;
+    }
     api.setToken(res.access_token);
+    api.setRefreshToken(res.refresh_token);
+    set({ user: res.user, isAuthenticated: true, isLoading: false });
+    return null;
+  },
+
+  verifyMfa: async (ticket, code) => {
+    const res = await api.post<TokenResponse>("/api/auth/mfa", { mfa_ticket: ticket, code });
+    api.setToken(res.access_token);
+    api.setRefreshToken(res.refresh_token);
     set({ user: res.user, isAuthenticated: true, isLoading: false });
   },
 
   register: async (username, email, password) => {
     const res = await api.post<TokenResponse>("/api/auth/register", { username, email, password });
     api.setToken(res.access_token);
+    api.setRefreshToken(res.refresh_token);
     set({ user: res.user, isAuthenticated: true, isLoading: false });
   },
 
   logout: () => {
+    const refreshToken = api.getRefreshToken();
+    if (refreshToken) {
+      api.post("/api/auth/logout", { refresh_token: refreshToken }).catch(() => {});
+    }
     api.setToken(null);
+    api.setRefreshToken(null);
     set({ user: null, isAuthenticated: false, isLoading: false });
   },
 
@@ -45,6 +79,7 @@ export const useAuthStore = create<AuthState>((set) => ({
       set({ user, isAuthenticated: true, isLoading: false });
     } catch {
       api.setToken(null);
+      api.setRefreshToken(null);
       set({ user: null, isAuthenticated: false, isLoading: false });
     }
   },
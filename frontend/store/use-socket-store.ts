@@ -27,27 +27,7 @@ export const useSocketStore = create<SocketState>((set, get) => ({
     const existing = get().ws;
     if (existing && existing.readyState === WebSocket.OPEN) return;
 
-    const wsUrl = api.getWsUrl();
-    const ws = new WebSocket(wsUrl);
-
-    ws.onopen = () => {
-      set({ connected: true });
-    };
-
-    ws.onmessage = (ev) => {
-      try {
-        const event: WSEvent = JSON.parse(ev.data);
-        const handlers = get().handlers.get(event.t);
-        if (handlers) {
-          handlers.forEach((h) => h(event.d));
-        }
-      } catch {
-        // ignore parse errors
-      }
-    };
-
-    ws.onclose = () => {
-      set({ connected: false, ws: null });
+    const reconnect = () => {
       setTimeout(() => {
         if (api.getToken()) {
           get().connect();
@@ -55,11 +35,40 @@ export const useSocketStore = create<SocketState>((set, get) => ({
       }, 3000);
     };
 
-    ws.onerror = () => {
-      ws.close();
-    };
-
-    set({ ws });
+    api
+      .getWsUrl()
+      .then((wsUrl) => {
+        const ws = new WebSocket(wsUrl);
+
+        ws.onopen = () => {
+          ws.send(JSON.stringify({ op: "IDENTIFY" }));
+          set({ connected: true });
+        };
+
+        ws.onmessage = (ev) => {
+          try {
+            const event: WSEvent = JSON.parse(ev.data);
+            const handlers = get().handlers.get(event.t);
+            if (handlers) {
+              handlers.forEach((h) => h(event.d));
+            }
+          } catch {
+            // ignore parse errors
+          }
+        };
+
+        ws.onclose = () => {
+          set({ connected: false, ws: null });
+          reconnect();
+        };
+
+        ws.onerror = () => {
+          ws.close();
+        };
+
+        set({ ws });
+      })
+      .catch(reconnect);
   },
 
   disconnect: () => {
@@ -3,6 +3,9 @@ export interface User {
   username: string;
   email: string;
   avatar_url: string | null;
+  email_verified?: boolean;
+  deletion_scheduled_at?: string;
+  bot?: boolean;
   created_at: string;
 }
 
@@ -92,6 +95,7 @@ export interface SoundboardClip {
 export interface UserPresence {
   user_id: string;
   status: "ONLINE" | "IDLE" | "DND" | "OFFLINE";
+  manual_status?: "IDLE" | "DND" | "INVISIBLE" | null;
   game_name: string | null;
   game_started_at: string | null;
   custom_status: string | null;
@@ -104,5 +108,26 @@ export interface WSEvent {
 
 export interface TokenResponse {
   access_token: string;
+  refresh_token: string;
+  expires_in: number;
   user: User;
 }
+
+export interface MFAChallenge {
+  mfa_required: true;
+  mfa_ticket: string;
+}
+
+export interface OIDCProvider {
+  id: string;
+  name: string;
+}
+
+export interface Identity {
+  id: string;
+  user_id: string;
+  provider: string;
+  subject: string;
+  email: string | null;
+  created_at: string;
+}